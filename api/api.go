@@ -2,20 +2,28 @@
 package phocus_api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log" // formatted logging
+	"maps"
+	"math"
 	"math/rand"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time" // for sleeping
-	"unicode/utf8"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	history "github.com/wolffshots/phocus/v2/history"
 	messages "github.com/wolffshots/phocus/v2/messages"
+	serial "github.com/wolffshots/phocus/v2/serial"
 )
 
 const MAX_QUEUE_LENGTH = 50
@@ -31,8 +39,30 @@ var QueueMutex sync.Mutex
 // ValueMutex controls access to the values
 var ValueMutex sync.Mutex
 
+// LastQPGSResponse is the latest QPGSn response from any of the inverters
 var LastQPGSResponse *messages.QPGSnResponse
 
+// LastResponses are the latest responses of each inverter by inverter number and then by command, ie QPGSn
+var LastResponses = map[int]map[string]any{}
+
+// LocalResponses are the latest responses from the connected unit by command, ie QPIGS
+var LocalResponses = map[string]any{}
+
+// Local is the inverter number of the Updates of LocalResponses, which can't be a QPGSn inverter number
+const Local = -1
+
+// Settings is the latest QPIRI response with the ratings and settings of the inverter
+var Settings *messages.QPIRIResponse
+
+// SerialStatus is the latest state of the connection to the inverter
+var SerialStatus = serial.Status{State: serial.Connected}
+
+// InvertersMutex controls access to the Inverters
+var InvertersMutex sync.Mutex
+
+// Inverters are the inverter numbers that are polled with QPGSn
+var Inverters = []int{1, 2}
+
 var upgrader = websocket.Upgrader{
 	// ReadBufferSize:  4096,
 	// WriteBufferSize: 4096,
@@ -45,37 +75,113 @@ var upgrader = websocket.Upgrader{
 }
 
 // AddQPGSnMessages is the meat of the QueueQPGSn functionality
-// TODO refactor to take a number in for how many inverters
-func AddQPGSnMessages(timeBetween time.Duration) error {
+//
+// Enqueues a QPGSn message for each of the given inverter numbers,
+// waiting timeBetween after each one, unless there are already as many
+// QPGSn messages waiting as there are inverters
+func AddQPGSnMessages(timeBetween time.Duration, inverters []int) error {
 	QueueMutex.Lock()
-	if len(Queue) < 2 {
-		Queue = append(
-			Queue,
-			messages.Message{ID: uuid.New(), Command: "QPGS1", Payload: ""},
-		)
+	pending := 0
+	for _, message := range Queue {
+		if _, isQPGSn := messages.ParseQPGSnCommand(message.Command); isQPGSn {
+			pending++
+		}
+	}
+	if pending >= len(inverters) {
 		QueueMutex.Unlock()
-		time.Sleep(timeBetween)
+		return errors.New("queue too long")
+	}
+	QueueMutex.Unlock()
+	for _, inverterNum := range inverters {
 		QueueMutex.Lock()
-		Queue = append(
-			Queue,
-			messages.Message{ID: uuid.New(), Command: "QPGS2", Payload: ""},
-		)
+		Push(messages.Message{ID: uuid.New(), Command: messages.QPGSnCommand(inverterNum), Payload: ""})
 		QueueMutex.Unlock()
 		time.Sleep(timeBetween)
-	} else {
-		QueueMutex.Unlock()
-		return errors.New("queue too long")
 	}
 	return nil
 }
 
-// QueueQPGSn is a simple loop to add QPGSn to the Queue as long as it isn't too long
+// QueueQPGSn is a simple loop to add QPGSn to the Queue for each of the current Inverters as long as it isn't too long
+//
+// It waits as long as it would between messages before trying again when the Queue is too long
 func QueueQPGSn(delaySeconds int, randDelaySeconds int) {
 	for {
-		AddQPGSnMessages(time.Duration(delaySeconds+rand.Intn(randDelaySeconds)) * time.Second)
+		delay := time.Duration(delaySeconds+rand.Intn(randDelaySeconds)) * time.Second
+		if err := AddQPGSnMessages(delay, GetInverters()); err != nil {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// AddMessage enqueues a command unless it is already waiting in the Queue or the Queue is full
+func AddMessage(command string) error {
+	QueueMutex.Lock()
+	defer QueueMutex.Unlock()
+	if len(Queue) >= MAX_QUEUE_LENGTH {
+		return errors.New("queue too long")
+	}
+	for _, message := range Queue {
+		if message.Command == command {
+			return errors.New("already queued")
+		}
+	}
+	Push(messages.Message{ID: uuid.New(), Command: command, Payload: ""})
+	return nil
+}
+
+// Push appends a message to the Queue and publishes an enqueue event
+//
+// Expects QueueMutex to be locked
+func Push(message messages.Message) {
+	Queue = append(Queue, message)
+	PublishEvent(EventEnqueue, message)
+}
+
+// Pop removes the first message from the Queue and publishes a dequeue event
+//
+// Expects QueueMutex to be locked and the Queue not to be empty
+func Pop() messages.Message {
+	message := Queue[0]
+	Queue = Queue[1:]
+	PublishEvent(EventDequeue, message)
+	return message
+}
+
+// QueuePeriodic is a simple loop to add a command to the Queue every interval
+func QueuePeriodic(command string, interval time.Duration) {
+	for {
+		AddMessage(command)
+		time.Sleep(interval)
 	}
 }
 
+// SetInverters replaces the inverter numbers that are polled with QPGSn
+func SetInverters(inverters []int) {
+	InvertersMutex.Lock()
+	Inverters = append([]int{}, inverters...)
+	InvertersMutex.Unlock()
+	// inverters which aren't polled anymore shouldn't count towards the system
+	ValueMutex.Lock()
+	for inverter := range LastResponses {
+		if !slices.Contains(inverters, inverter) {
+			delete(LastResponses, inverter)
+		}
+	}
+	ValueMutex.Unlock()
+}
+
+// GetInverters returns a copy of the inverter numbers that are polled with QPGSn
+func GetInverters() []int {
+	InvertersMutex.Lock()
+	defer InvertersMutex.Unlock()
+	return append([]int{}, Inverters...)
+}
+
+// GetInvertersJSON is called to view the inverter numbers that are being polled as JSON
+func GetInvertersJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, GetInverters())
+}
+
 // PostMessage enqueues a new message manually (requires knowledge of commands and a generated uuid on the request)
 // as long as there is space in the queue for it
 func PostMessage(c *gin.Context) {
@@ -85,18 +191,50 @@ func PostMessage(c *gin.Context) {
 	if err := c.BindJSON(&newMessage); err != nil || newMessage.Command == "" {
 		log.Printf("Error binding to JSON: %v", err)
 		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Coudln't bind JSON to message"})
+	} else if err := Enqueue(newMessage); errors.Is(err, ErrQueueFull) {
+		c.IndentedJSON(http.StatusInsufficientStorage, gin.H{"message": "Message Queue already full!"})
+	} else if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 	} else {
-		QueueMutex.Lock()
-		// append new message to the Queue if there is space
-		if len(Queue) < MAX_QUEUE_LENGTH {
-			Queue = append(Queue, newMessage)
-			QueueMutex.Unlock()
-			c.IndentedJSON(http.StatusCreated, newMessage)
-		} else {
-			QueueMutex.Unlock()
-			c.IndentedJSON(http.StatusInsufficientStorage, gin.H{"message": "Message Queue already full!"})
-		}
+		c.IndentedJSON(http.StatusCreated, newMessage)
+	}
+}
+
+// ErrQueueFull is returned by Enqueue when there are already MAX_QUEUE_LENGTH messages queued
+var ErrQueueFull = errors.New("message queue already full")
+
+// Enqueue validates a message and appends it to the Queue if there is space
+func Enqueue(message messages.Message) error {
+	if err := ValidateMessage(message); err != nil {
+		return err
+	}
+	QueueMutex.Lock()
+	defer QueueMutex.Unlock()
+	if len(Queue) >= MAX_QUEUE_LENGTH {
+		return ErrQueueFull
 	}
+	Push(message)
+	return nil
+}
+
+// ValidateMessage checks the value of setter commands and the date of energy
+// queries before they are queued so that a bad value is refused straight away
+// instead of when it is sent
+func ValidateMessage(message messages.Message) error {
+	if period, isEnergy := messages.EnergyPeriods[message.Command]; isEnergy {
+		return period.Validate(message.Payload)
+	}
+	setter, value, isSetter := messages.ParseSetter(message.Command, message.Payload)
+	if !isSetter {
+		return nil
+	}
+	_, err := setter.Validate(value)
+	return err
+}
+
+// GetSetters is called to view the setter commands and their options or ranges as JSON
+func GetSetters(c *gin.Context) {
+	c.JSON(http.StatusOK, messages.ListSetters())
 }
 
 // GetQueue is called to view the current Queue as JSON
@@ -107,52 +245,342 @@ func GetQueue(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, tempQueue)
 }
 
+// SetLast stores the latest QPGSn response as the latest of its inverter, nil forgets them all
 func SetLast(newResponse *messages.QPGSnResponse) {
 	ValueMutex.Lock()
 	LastQPGSResponse = newResponse
+	if newResponse == nil {
+		for _, responses := range LastResponses {
+			delete(responses, "QPGSn")
+		}
+	} else {
+		setLastResponse(newResponse.InverterNumber, "QPGSn", newResponse)
+	}
 	ValueMutex.Unlock()
 }
 
+// SetLastResponse stores the latest response to the command from the inverter and pushes it to the subscribers
+func SetLastResponse(inverter int, command string, response any) {
+	ValueMutex.Lock()
+	setLastResponse(inverter, command, response)
+	ValueMutex.Unlock()
+}
+
+// setLastResponse is SetLastResponse for when ValueMutex is already locked
+func setLastResponse(inverter int, command string, response any) {
+	if LastResponses[inverter] == nil {
+		LastResponses[inverter] = map[string]any{}
+	}
+	LastResponses[inverter][command] = response
+	push(Update{Inverter: inverter, Command: command, Response: encode(response)})
+}
+
+// SetLocalResponse stores the latest response to the command from the connected unit,
+// ie QPIGS, and pushes it to the subscribers as an Update of the Local inverter
+func SetLocalResponse(command string, response any) {
+	ValueMutex.Lock()
+	setLocalResponse(command, response)
+	ValueMutex.Unlock()
+}
+
+// setLocalResponse is SetLocalResponse for when ValueMutex is already locked
+func setLocalResponse(command string, response any) {
+	LocalResponses[command] = response
+	push(Update{Inverter: Local, Command: command, Response: encode(response)})
+}
+
+// push sends the update to the websockets and /events
+func push(update Update) {
+	Broadcast(update)
+	PublishEvent(EventResponse, update)
+}
+
+// lastUpdates returns the latest responses that match the subscription as updates
+// in order of inverter number and command, starting with the Local ones
+//
+// Expects ValueMutex to be locked
+func lastUpdates(subscription Subscription) []Update {
+	updates := []Update{}
+	for _, command := range slices.Sorted(maps.Keys(LocalResponses)) {
+		update := Update{Inverter: Local, Command: command}
+		if subscription.Matches(update) {
+			update.Response = encode(LocalResponses[command])
+			updates = append(updates, update)
+		}
+	}
+	for _, inverter := range slices.Sorted(maps.Keys(LastResponses)) {
+		for _, command := range slices.Sorted(maps.Keys(LastResponses[inverter])) {
+			update := Update{Inverter: inverter, Command: command}
+			if subscription.Matches(update) {
+				update.Response = encode(LastResponses[inverter][command])
+				updates = append(updates, update)
+			}
+		}
+	}
+	return updates
+}
+
+// lastQPGSn returns the latest QPGSn response of each inverter in order of inverter number
+//
+// Expects ValueMutex to be locked
+func lastQPGSn() []*messages.QPGSnResponse {
+	var responses []*messages.QPGSnResponse
+	for _, inverter := range slices.Sorted(maps.Keys(LastResponses)) {
+		if response, ok := LastResponses[inverter]["QPGSn"].(*messages.QPGSnResponse); ok {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
 // GetLast is called to view the current Last Response as JSON
 func GetLast(c *gin.Context) {
 	ValueMutex.Lock()
-	c.JSON(http.StatusOK, LastQPGSResponse)
+	jsonResponse := messages.EncodeQPGSn(LastQPGSResponse)
 	ValueMutex.Unlock()
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(jsonResponse))
 }
 
-// GetLast is called to view the current Last Response as JSON on a websocket
-func GetLastWS(ctx *gin.Context) {
-	w, r := ctx.Writer, ctx.Request
-	c, err := upgrader.Upgrade(w, r, nil)
+// lastOfInverter returns the latest QPGSn response of the inverter in the path or
+// responds with 400 if it isn't a number or 404 if the inverter hasn't responded yet
+func lastOfInverter(c *gin.Context) (*messages.QPGSnResponse, bool) {
+	inverter, err := strconv.Atoi(c.Param("inverter"))
 	if err != nil {
-		log.Println("upgrade err:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("inverter should be a number but was %s", c.Param("inverter"))})
+		return nil, false
+	}
+	ValueMutex.Lock()
+	response, ok := LastResponses[inverter]["QPGSn"].(*messages.QPGSnResponse)
+	ValueMutex.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("no response from inverter %d yet", inverter)})
+		return nil, false
+	}
+	return response, true
+}
+
+// GetLastOfInverter is called to view the latest response of an inverter as JSON
+func GetLastOfInverter(c *gin.Context) {
+	response, ok := lastOfInverter(c)
+	if !ok {
 		return
 	}
-	defer c.Close()
-	for {
-		ValueMutex.Lock()
-		bytesResponse := []byte(messages.EncodeQPGSn(LastQPGSResponse))
-		ValueMutex.Unlock()
-		if utf8.Valid(bytesResponse) {
-			_ = c.WriteMessage(websocket.TextMessage, bytesResponse)
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(messages.EncodeQPGSn(response)))
+}
+
+// GetLastFieldOfInverter is called to view a field of the latest response of an inverter as JSON,
+// ie {"BatteryVoltage":51.1}, with nested fields like InverterStatus.MPPT
+func GetLastFieldOfInverter(c *gin.Context) {
+	response, ok := lastOfInverter(c)
+	if !ok {
+		return
+	}
+	// the field is read from the JSON so that it has the same shape as /last
+	var value any
+	_ = json.Unmarshal([]byte(messages.EncodeQPGSn(response)), &value)
+	for _, name := range strings.Split(c.Param("field"), ".") {
+		fields, isStruct := value.(map[string]any)
+		if value, ok = fields[name]; !isStruct || !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("%s isn't a field of the response", c.Param("field"))})
+			return
 		}
-		time.Sleep(50 * time.Millisecond)
 	}
+	c.JSON(http.StatusOK, gin.H{c.Param("field"): value})
+}
+
+// System is the combined state of the paralleled inverters, with the powers and
+// currents summed and the battery voltage and state of charge averaged
+type System struct {
+	Inverters               []int // the inverter numbers which have responded
+	ACOutputApparentPower   messages.VoltAmps
+	ACOutputActivePower     messages.Watts
+	PVInputPower            messages.Watts
+	BatteryChargingCurrent  messages.Amps
+	BatteryDischargeCurrent messages.Amps
+	BatteryVoltage          messages.Volts
+	BatteryStateOfCharge    messages.Percent
+}
+
+// system combines the latest QPGSn responses of the inverters
+//
+// Expects ValueMutex to be locked
+func system() System {
+	system := System{Inverters: []int{}}
+	responses := lastQPGSn()
+	for _, response := range responses {
+		system.Inverters = append(system.Inverters, response.InverterNumber)
+		system.ACOutputApparentPower += response.ACOutputApparentPower
+		system.ACOutputActivePower += response.ACOutputActivePower
+		system.PVInputPower += messages.Watts(math.Round(float64(response.PVInputVoltage) * float64(response.PVInputCurrent)))
+		system.BatteryChargingCurrent += response.BatteryChargingCurrent
+		system.BatteryDischargeCurrent += response.BatteryDischargeCurrent
+		system.BatteryVoltage += response.BatteryVoltage
+		system.BatteryStateOfCharge += response.BatteryStateOfCharge
+	}
+	if len(responses) > 0 {
+		system.BatteryVoltage /= messages.Volts(len(responses))
+		system.BatteryStateOfCharge = messages.Percent(math.Round(float64(system.BatteryStateOfCharge) / float64(len(responses))))
+	}
+	return system
+}
+
+// GetSystem is called to view the combined state of the paralleled inverters as JSON
+func GetSystem(c *gin.Context) {
+	ValueMutex.Lock()
+	system := system()
+	ValueMutex.Unlock()
+	c.JSON(http.StatusOK, system)
+}
+
+// GetLastWS is called to view the Last Response as JSON on a websocket, starting with
+// the current one and then each new QPGSn response as it arrives
+//
+// ?inverter=1 only sends the responses of inverter 1
+func GetLastWS(ctx *gin.Context) {
+	serveWS(ctx, Subscription{Commands: []string{"QPGSn"}}, false)
+}
+
+// GetWS is called to view the latest responses of every type as Updates on a websocket,
+// starting with the current ones and then each new response as it arrives
+//
+// ?inverter=1&command=QPGSn only sends the QPGSn responses of inverter 1
+func GetWS(ctx *gin.Context) {
+	serveWS(ctx, Subscription{}, true)
+}
+
+// SetSettings stores the latest ratings and settings of the inverter
+func SetSettings(settings *messages.QPIRIResponse) {
+	ValueMutex.Lock()
+	Settings = settings
+	if settings != nil {
+		setLocalResponse("QPIRI", settings)
+	}
+	ValueMutex.Unlock()
+}
+
+// GetSettings is called to view the latest ratings and settings of the inverter as JSON
+func GetSettings(c *gin.Context) {
+	ValueMutex.Lock()
+	settings := Settings
+	ValueMutex.Unlock()
+	c.JSON(http.StatusOK, settings)
+}
+
+// SetSerialStatus stores the latest state of the connection to the inverter
+func SetSerialStatus(status serial.Status) {
+	ValueMutex.Lock()
+	SerialStatus = status
+	ValueMutex.Unlock()
+}
+
+// GetSerialStatus is called to view the state of the connection to the inverter as JSON
+func GetSerialStatus(c *gin.Context) {
+	ValueMutex.Lock()
+	status := SerialStatus
+	ValueMutex.Unlock()
+	c.JSON(http.StatusOK, status)
 }
 
 type LastStateOfCharge struct {
-	BatteryStateOfCharge string
+	BatteryStateOfCharge any // a number, the padded string when messages.LegacyJSON is set or "null" before the first response
 }
 
-// GetLastStateOfCharge is called to view the current Last State of Charge as JSON
+// GetLastStateOfCharge is called to view the current Last State of Charge as JSON,
+// which is the average of the inverters since they share the battery
 func GetLastStateOfCharge(c *gin.Context) {
 	ValueMutex.Lock()
-	if LastQPGSResponse != nil {
-		c.JSON(http.StatusOK, LastStateOfCharge{BatteryStateOfCharge: LastQPGSResponse.BatteryStateOfCharge})
+	system := system()
+	ValueMutex.Unlock()
+	if len(system.Inverters) > 0 {
+		var stateOfCharge any = system.BatteryStateOfCharge
+		if messages.LegacyJSON() {
+			stateOfCharge = fmt.Sprintf("%03d", system.BatteryStateOfCharge)
+		}
+		c.JSON(http.StatusOK, LastStateOfCharge{BatteryStateOfCharge: stateOfCharge})
 	} else {
 		c.JSON(http.StatusOK, LastStateOfCharge{BatteryStateOfCharge: "null"})
 	}
-	ValueMutex.Unlock()
+}
+
+// History is the history of a field between From and To, with each point averaged over Step if it isn't 0
+type History struct {
+	Source string // ie qpgs1
+	Field  string // ie BatteryVoltage
+	From   time.Time
+	To     time.Time
+	Step   string
+	Points []history.Point
+}
+
+// parseTime reads a time as RFC 3339 or seconds since the epoch, falling back to the default if it is empty
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseStep reads a step as a duration like 5m or as seconds
+func parseStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// GetHistory is called to view the history of a field of an inverter (?inverter=1&field=BatteryVoltage)
+// or of another query (?query=qpigs&field=PVChargingPower) as JSON
+//
+// from and to are RFC 3339 or seconds since the epoch and default to the last day, step is
+// a duration like 5m or seconds and defaults to every value for up to a day or about a thousand
+// averages for longer
+func GetHistory(c *gin.Context) {
+	source := c.Query("query")
+	if inverter := c.Query("inverter"); inverter != "" {
+		inverterNum, err := strconv.Atoi(inverter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("inverter should be a number but was %s", inverter)})
+			return
+		}
+		source = fmt.Sprintf("qpgs%d", inverterNum)
+	}
+	field := c.Query("field")
+	if source == "" || field == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "inverter or query and field are needed"})
+		return
+	}
+	now := time.Now()
+	to, err := parseTime(c.Query("to"), now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("to should be RFC 3339 or seconds: %v", err)})
+		return
+	}
+	from, err := parseTime(c.Query("from"), to.Add(-24*time.Hour))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("from should be RFC 3339 or seconds: %v", err)})
+		return
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "from should be before to"})
+		return
+	}
+	step := history.DefaultStep(from, to)
+	if c.Query("step") != "" {
+		step, err = parseStep(c.Query("step"))
+		if err != nil || step < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("step should be a duration like 5m or seconds but was %s", c.Query("step"))})
+			return
+		}
+	}
+	points, err := history.Query(source, field, from, to, step)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, History{Source: source, Field: field, From: from, To: to, Step: step.String(), Points: points})
 }
 
 // GetHealth is a simple endpoint to return a 200
@@ -188,6 +616,9 @@ func GetMessage(c *gin.Context) {
 // DeleteQueue clears the current Queue
 func DeleteQueue(c *gin.Context) {
 	QueueMutex.Lock()
+	for _, message := range Queue {
+		PublishEvent(EventDequeue, message)
+	}
 	Queue = []messages.Message{}
 	QueueMutex.Unlock()
 	c.Status(http.StatusNoContent)
@@ -203,6 +634,7 @@ func DeleteMessage(c *gin.Context) {
 		for index, a := range Queue {
 			if a.ID.String() == id {
 				Queue = append(Queue[:index], Queue[index+1:]...)
+				PublishEvent(EventDequeue, a)
 				QueueMutex.Unlock()
 				c.Status(http.StatusNoContent)
 				return
@@ -238,7 +670,17 @@ func SetupRouter(mode string, profiling bool) *gin.Engine {
 	router.GET("/queue/:id", GetMessage)
 	router.GET("/last", GetLast)
 	router.GET("/last-ws", GetLastWS)
+	router.GET("/ws", GetWS)
+	router.GET("/events", GetEvents)
 	router.GET("/last/soc", GetLastStateOfCharge)
+	router.GET("/last/system", GetSystem)
+	router.GET("/last/:inverter", GetLastOfInverter)
+	router.GET("/last/:inverter/:field", GetLastFieldOfInverter)
+	router.GET("/inverters", GetInvertersJSON)
+	router.GET("/serial", GetSerialStatus)
+	router.GET("/settings", GetSettings)
+	router.GET("/setters", GetSetters)
+	router.GET("/history", GetHistory)
 	router.POST("/queue", PostMessage)
 	router.DELETE("/queue", DeleteQueue)
 	router.DELETE("/queue/:id", DeleteMessage)
@@ -7,13 +7,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid" // for generating UUIDs for commands
 	"github.com/gorilla/websocket"
+	history "github.com/wolffshots/phocus/v2/history"
 	messages "github.com/wolffshots/phocus/v2/messages"
+	serial "github.com/wolffshots/phocus/v2/serial"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,15 +24,68 @@ import (
 func TestAddQPGSnMessages(t *testing.T) {
 	assert.Equal(t, 1, len(Queue))
 	assert.Equal(t, "QID", Queue[0].Command)
-	err := AddQPGSnMessages(0)
+	err := AddQPGSnMessages(0, []int{1, 2})
 	assert.Equal(t, 3, len(Queue))
 	assert.Equal(t, "QID", Queue[0].Command)
 	assert.Equal(t, "QPGS1", Queue[1].Command)
 	assert.Equal(t, "QPGS2", Queue[2].Command)
 	assert.NoError(t, err)
-	err = AddQPGSnMessages(0) // shouldn't add to the queue since there is already over 2
+	err = AddQPGSnMessages(0, []int{1, 2}) // shouldn't add to the queue since there is already over 2
 	assert.Equal(t, 3, len(Queue))
 	assert.Equal(t, errors.New("queue too long"), err)
+
+	// more inverters allow for a longer queue
+	err = AddQPGSnMessages(0, []int{0, 1, 2, 3})
+	assert.Equal(t, 7, len(Queue))
+	assert.Equal(t, "QPGS0", Queue[3].Command)
+	assert.Equal(t, "QPGS1", Queue[4].Command)
+	assert.Equal(t, "QPGS2", Queue[5].Command)
+	assert.Equal(t, "QPGS3", Queue[6].Command)
+	assert.NoError(t, err)
+
+	// only the QPGSn messages that are waiting count towards the limit
+	Queue = Queue[:1]
+	for _, command := range []string{"QPIGS", "QPIWS", "QPIRI", "QET"} {
+		Queue = append(Queue, messages.Message{ID: uuid.New(), Command: command})
+	}
+	err = AddQPGSnMessages(0, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, len(Queue))
+	assert.Equal(t, "QPGS2", Queue[6].Command)
+
+	Queue = Queue[:1]
+}
+
+func TestAddMessage(t *testing.T) {
+	assert.Equal(t, 1, len(Queue))
+	err := AddMessage("QPIGS")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(Queue))
+	assert.Equal(t, "QPIGS", Queue[1].Command)
+
+	// only queued once until it has been handled
+	err = AddMessage("QPIGS")
+	assert.EqualError(t, err, "already queued")
+	assert.Equal(t, 2, len(Queue))
+
+	for len(Queue) < MAX_QUEUE_LENGTH {
+		Queue = append(Queue, Queue[0])
+	}
+	err = AddMessage("QPIRI")
+	assert.EqualError(t, err, "queue too long")
+
+	Queue = Queue[:1]
+}
+
+func TestQueuePeriodic(t *testing.T) {
+	go QueuePeriodic("QPIGS", time.Hour)
+	time.Sleep(10 * time.Millisecond)
+
+	QueueMutex.Lock()
+	assert.Equal(t, 2, len(Queue))
+	assert.Equal(t, "QPIGS", Queue[1].Command)
+	Queue = Queue[:1]
+	QueueMutex.Unlock()
 }
 
 func TestPostMessage(t *testing.T) {
@@ -187,9 +243,11 @@ func TestSetAndGetLast(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, "/last", nil)
 	assert.Equal(t, err, nil)
 	router.ServeHTTP(w, req)
-	assert.Equal(t, "{\"InverterNumber\":1,\"OtherUnits\":true,\"SerialNumber\":\"92932004102443\",\"OperationMode\":\"Off-grid\",\"FaultCode\":\"\",\"ACInputVoltage\":\"237.0\",\"ACInputFrequency\":\"50.01\",\"ACOutputVoltage\":\"000.0\",\"ACOutputFrequency\":\"00.00\",\"ACOutputApparentPower\":\"0483\",\"ACOutputActivePower\":\"0387\",\"PercentageOfNominalOutputPower\":\"009\",\"BatteryVoltage\":\"51.1\",\"BatteryChargingCurrent\":\"000\",\"BatteryStateOfCharge\":\"069\",\"PVInputVoltage\":\"020.4\",\"TotalChargingCurrent\":\"000\",\"TotalACOutputApparentPower\":\"00942\",\"TotalACOutputActivePower\":\"00792\",\"TotalPercentageOfNominalOutputPower\":\"007\",\"InverterStatus\":{\"MPPT\":\"off\",\"ACCharging\":\"off\",\"SolarCharging\":\"off\",\"BatteryStatus\":\"Battery voltage normal\",\"ACInput\":\"connected\",\"ACOutput\":\"on\",\"Reserved\":\"0\"},\"ACOutputMode\":\"Parallel output\",\"BatteryChargerSourcePriority\":\"Solar first\",\"MaxChargingCurrentSet\":\"060\",\"MaxChargingCurrentPossible\":\"080\",\"MaxACChargingCurrentSet\":\"10\",\"PVInputCurrent\":\"00.0\",\"BatteryDischargeCurrent\":\"006\",\"Checksum\":\"0xf22d\"}", w.Body.String())
+	assert.Equal(t, "{\"Version\":2,\"InverterNumber\":1,\"OtherUnits\":true,\"SerialNumber\":\"92932004102443\",\"OperationMode\":\"Off-grid\",\"FaultCode\":\"\",\"ACInputVoltage\":237,\"ACInputFrequency\":50.01,\"ACOutputVoltage\":0,\"ACOutputFrequency\":0,\"ACOutputApparentPower\":483,\"ACOutputActivePower\":387,\"PercentageOfNominalOutputPower\":9,\"BatteryVoltage\":51.1,\"BatteryChargingCurrent\":0,\"BatteryStateOfCharge\":69,\"PVInputVoltage\":20.4,\"TotalChargingCurrent\":0,\"TotalACOutputApparentPower\":942,\"TotalACOutputActivePower\":792,\"TotalPercentageOfNominalOutputPower\":7,\"InverterStatus\":{\"MPPT\":\"off\",\"ACCharging\":\"off\",\"SolarCharging\":\"off\",\"BatteryStatus\":\"Battery voltage normal\",\"ACInput\":\"connected\",\"ACOutput\":\"on\",\"Reserved\":\"0\"},\"ACOutputMode\":\"Parallel output\",\"BatteryChargerSourcePriority\":\"Solar first\",\"MaxChargingCurrentSet\":60,\"MaxChargingCurrentPossible\":80,\"MaxACChargingCurrentSet\":10,\"PVInputCurrent\":0,\"BatteryDischargeCurrent\":6,\"Checksum\":\"0xf22d\"}", w.Body.String())
 
-	// test with realistic response
+	// test with realistic response and the strings of version 1
+	messages.SetLegacyJSON(true)
+	defer messages.SetLegacyJSON(false)
 	input = "(1 92932004102543 B 00 237.0 50.01 000.0 00.00 0483 0387 009 51.1 000 069 020.4 000 00942 00792 007 00000010 1 1 060 080 10 00.0 006\xf2\x2d\r"
 	actual, err = messages.InterpretQPGSn(input, 2)
 	assert.Equal(t, err, nil)
@@ -228,6 +286,17 @@ func TestGetLastStateOfCharge(t *testing.T) {
 	assert.Equal(t, err, nil)
 	router.ServeHTTP(w, req)
 
+	assert.Equal(t, "{\"BatteryStateOfCharge\":69}", w.Body.String())
+
+	// test with the strings of version 1
+	messages.SetLegacyJSON(true)
+	defer messages.SetLegacyJSON(false)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/last/soc", nil)
+	assert.Equal(t, err, nil)
+	router.ServeHTTP(w, req)
+
 	assert.Equal(t, "{\"BatteryStateOfCharge\":\"069\"}", w.Body.String())
 }
 
@@ -388,14 +457,81 @@ func TestDeleteMessage(t *testing.T) {
 	assert.Equal(t, want, Queue) // assert that it hasn't changed
 }
 
+func TestInverters(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+
+	assert.Equal(t, []int{1, 2}, GetInverters())
+
+	inverters := []int{0, 1, 2}
+	SetInverters(inverters)
+	inverters[0] = 5 // shouldn't change the stored inverters
+	assert.Equal(t, []int{0, 1, 2}, GetInverters())
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/inverters", nil)
+	assert.NoError(t, err)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[0,1,2]", w.Body.String())
+
+	SetInverters([]int{1, 2})
+}
+
+func TestSerialStatus(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/serial", nil)
+	assert.NoError(t, err)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"State\":\"connected\",\"Attempt\":0,\"Backoff\":\"\",\"Error\":\"\"}", w.Body.String())
+
+	SetSerialStatus(serial.Status{State: serial.Reconnecting, Attempt: 2, Backoff: "2s", Error: "no such file or directory"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{\"State\":\"reconnecting\",\"Attempt\":2,\"Backoff\":\"2s\",\"Error\":\"no such file or directory\"}", w.Body.String())
+
+	SetSerialStatus(serial.Status{State: serial.Connected})
+}
+
+func TestSettings(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/settings", nil)
+	assert.NoError(t, err)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "null", w.Body.String())
+
+	SetSettings(&messages.QPIRIResponse{BatteryType: "User", BatteryFloatVoltage: "54.0"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "\"BatteryFloatVoltage\":\"54.0\",\"BatteryType\":\"User\"")
+
+	SetSettings(nil)
+}
+
 func TestQueueQPGSn(t *testing.T) {
+	QueueMutex.Lock()
+	Queue = []messages.Message{{ID: uuid.New(), Command: "QPIGS"}}
+	QueueMutex.Unlock()
+
 	// Start the adder in a goroutine
 	go QueueQPGSn(100, 5)
 
 	// Wait for a specific duration to allow the server to start
 	time.Sleep(51 * time.Millisecond)
 
-	assert.Equal(t, 1, len(Queue))
+	// the QPIGS that is waiting doesn't hold up the first inverter, the next waits for the delay
+	QueueMutex.Lock()
+	assert.Equal(t, 2, len(Queue))
+	assert.Equal(t, "QPGS1", Queue[len(Queue)-1].Command)
+	Queue = Queue[:0]
+	QueueMutex.Unlock()
 }
 
 func TestLastAndLastWS(t *testing.T) {
@@ -430,14 +566,11 @@ func TestLastAndLastWS(t *testing.T) {
 	assert.Equal(t, err, nil)
 	SetLast(actual)
 	// Listen to and verify the WebSocket message
-	_, _, err = conn.ReadMessage() // read first message and ignore it
+	_, _, err = conn.ReadMessage() // read the response from when it connected and ignore it
 	if err != nil {
 		t.Fatalf("conn.ReadMessage error: %v", err)
 	}
-	var receivedMessage []byte
-	for messageCount := 0; messageCount < 10; messageCount++ {
-		_, receivedMessage, err = conn.ReadMessage()
-	}
+	_, receivedMessage, err := conn.ReadMessage() // and then the one that was pushed
 
 	assert.Equal(t, []byte(messages.EncodeQPGSn(actual)), receivedMessage)
 	assert.Equal(t, nil, err)
@@ -473,3 +606,203 @@ func TestWriteError(t *testing.T) {
 	_, _, err := dialer.Dial("ws"+ts.URL[4:]+"/last-ws", nil)
 	assert.Error(t, errors.New("bad handshake"), err) // because it couldn't be written
 }
+
+func TestPostSetter(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+	Queue = make([]messages.Message, 0)
+
+	post := func(message messages.Message) int {
+		body, err := json.Marshal(message)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "/queue", bytes.NewBuffer(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, post(messages.Message{ID: uuid.New(), Command: "POP", Payload: "Solar first"}))
+	assert.Equal(t, http.StatusCreated, post(messages.Message{ID: uuid.New(), Command: "PBFT", Payload: "54.0"}))
+	assert.Equal(t, http.StatusBadRequest, post(messages.Message{ID: uuid.New(), Command: "POP", Payload: "05"}))
+	assert.Equal(t, http.StatusBadRequest, post(messages.Message{ID: uuid.New(), Command: "PBFT70.0", Payload: ""}))
+	assert.Equal(t, http.StatusCreated, post(messages.Message{ID: uuid.New(), Command: "QED", Payload: "20240131"}))
+	assert.Equal(t, http.StatusCreated, post(messages.Message{ID: uuid.New(), Command: "QEY", Payload: ""}))
+	assert.Equal(t, http.StatusBadRequest, post(messages.Message{ID: uuid.New(), Command: "QEM", Payload: "2024"}))
+	assert.Equal(t, 4, len(Queue))
+
+	Queue = make([]messages.Message, 0)
+}
+
+func TestGetSetters(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/setters", nil)
+	assert.NoError(t, err)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var setters []messages.Setter
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &setters))
+	assert.Equal(t, len(messages.Setters), len(setters))
+	assert.Equal(t, "MCHGC", setters[0].Command)
+}
+
+func TestEnqueue(t *testing.T) {
+	Queue = make([]messages.Message, 0)
+
+	assert.NoError(t, Enqueue(messages.Message{ID: uuid.New(), Command: "MCHGC", Payload: "60"}))
+	assert.ErrorIs(t, Enqueue(messages.Message{ID: uuid.New(), Command: "MCHGC", Payload: "600"}), messages.ErrInvalidSetting)
+	for len(Queue) < MAX_QUEUE_LENGTH {
+		Queue = append(Queue, messages.Message{ID: uuid.New(), Command: "QID"})
+	}
+	assert.ErrorIs(t, Enqueue(messages.Message{ID: uuid.New(), Command: "QID"}), ErrQueueFull)
+
+	Queue = make([]messages.Message, 0)
+}
+
+func TestGetHistory(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/history?"+query, nil)
+		assert.NoError(t, err)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the history isn't available until it is opened
+	assert.Equal(t, http.StatusServiceUnavailable, get("inverter=1&field=BatteryVoltage").Code)
+
+	assert.NoError(t, history.Open(filepath.Join(t.TempDir(), "history.db")))
+	defer history.Close()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, history.Record("qpgs1", &messages.QPGSnResponse{BatteryVoltage: 51.1}, start))
+	assert.NoError(t, history.Record("qpgs1", &messages.QPGSnResponse{BatteryVoltage: 51.3}, start.Add(30*time.Second)))
+	assert.NoError(t, history.Record("qpigs", &messages.QPIGSResponse{PVChargingPower: "00350"}, start))
+
+	w := get("inverter=1&field=BatteryVoltage&from=2024-01-01T11:00:00Z&to=1704110400")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var actual History
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.Equal(t, "qpgs1", actual.Source)
+	assert.Equal(t, "0s", actual.Step)
+	assert.Len(t, actual.Points, 1, "to is inclusive")
+
+	w = get("inverter=1&field=BatteryVoltage&from=1704106800&to=1704114000&step=1m")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.Equal(t, "1m0s", actual.Step)
+	assert.Len(t, actual.Points, 1)
+	assert.InDelta(t, 51.2, actual.Points[0].Value, 1e-9)
+
+	w = get("query=qpigs&field=PVChargingPower&from=1704106800&to=1704114000&step=60")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.Equal(t, "qpigs", actual.Source)
+	assert.Equal(t, 350.0, actual.Points[0].Value)
+
+	// the last day by default
+	w = get("inverter=2&field=BatteryVoltage")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+	assert.Equal(t, 24*time.Hour, actual.To.Sub(actual.From))
+	assert.Empty(t, actual.Points)
+
+	for _, query := range []string{
+		"field=BatteryVoltage",
+		"inverter=1",
+		"inverter=one&field=BatteryVoltage",
+		"inverter=1&field=BatteryVoltage&from=yesterday",
+		"inverter=1&field=BatteryVoltage&to=tomorrow",
+		"inverter=1&field=BatteryVoltage&from=1704114000&to=1704106800",
+		"inverter=1&field=BatteryVoltage&step=often",
+		"inverter=1&field=BatteryVoltage&step=-1m",
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+}
+
+func TestLastOfEachInverter(t *testing.T) {
+	router := SetupRouter(gin.TestMode, false)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		assert.NoError(t, err)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	SetLast(nil)
+	defer SetLast(nil)
+	defer SetInverters([]int{1, 2})
+
+	w := get("/last/system")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"Inverters":[],"ACOutputApparentPower":0,"ACOutputActivePower":0,"PVInputPower":0,"BatteryChargingCurrent":0,"BatteryDischargeCurrent":0,"BatteryVoltage":0,"BatteryStateOfCharge":0}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/last/1").Code)
+
+	first := &messages.QPGSnResponse{InverterNumber: 1, ACOutputActivePower: 387, ACOutputApparentPower: 483, PVInputVoltage: 200, PVInputCurrent: 2.5, BatteryVoltage: 51.1, BatteryStateOfCharge: 69, BatteryChargingCurrent: 10, InverterStatus: messages.InverterStatus{MPPT: "on"}}
+	second := &messages.QPGSnResponse{InverterNumber: 2, ACOutputActivePower: 405, ACOutputApparentPower: 459, PVInputVoltage: 180, PVInputCurrent: 2, BatteryVoltage: 51.3, BatteryStateOfCharge: 70, BatteryChargingCurrent: 12}
+	SetLast(first)
+	SetLast(second)
+
+	// each inverter is kept no matter which was polled last
+	w = get("/last/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, messages.EncodeQPGSn(first), w.Body.String())
+	assert.Equal(t, messages.EncodeQPGSn(second), get("/last/2").Body.String())
+	assert.Equal(t, messages.EncodeQPGSn(second), get("/last").Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/last/3").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/last/first").Code)
+
+	w = get("/last/1/BatteryVoltage")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"BatteryVoltage":51.1}`, w.Body.String())
+	assert.Equal(t, `{"InverterStatus.MPPT":"on"}`, get("/last/1/InverterStatus.MPPT").Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/last/1/Voltage").Code)
+	assert.Equal(t, http.StatusNotFound, get("/last/1/BatteryVoltage.Volts").Code)
+	assert.Equal(t, http.StatusNotFound, get("/last/3/BatteryVoltage").Code)
+
+	// the fields have the shape of version 1 with the legacy flag
+	messages.SetLegacyJSON(true)
+	assert.Equal(t, `{"BatteryVoltage":"51.1"}`, get("/last/1/BatteryVoltage").Body.String())
+	assert.Equal(t, `{"BatteryStateOfCharge":"070"}`, get("/last/soc").Body.String())
+	messages.SetLegacyJSON(false)
+
+	// the powers are summed and the battery is averaged
+	var system System
+	assert.NoError(t, json.Unmarshal(get("/last/system").Body.Bytes(), &system))
+	assert.Equal(t, []int{1, 2}, system.Inverters)
+	assert.Equal(t, messages.Watts(792), system.ACOutputActivePower)
+	assert.Equal(t, messages.VoltAmps(942), system.ACOutputApparentPower)
+	assert.Equal(t, messages.Watts(860), system.PVInputPower)
+	assert.Equal(t, messages.Amps(22), system.BatteryChargingCurrent)
+	assert.InDelta(t, 51.2, float64(system.BatteryVoltage), 1e-9)
+	assert.Equal(t, messages.Percent(70), system.BatteryStateOfCharge)
+	assert.Equal(t, `{"BatteryStateOfCharge":70}`, get("/last/soc").Body.String())
+
+	// inverters which aren't polled anymore are forgotten
+	SetInverters([]int{2})
+	assert.Equal(t, http.StatusNotFound, get("/last/1").Code)
+	assert.NoError(t, json.Unmarshal(get("/last/system").Body.Bytes(), &system))
+	assert.Equal(t, []int{2}, system.Inverters)
+	assert.Equal(t, messages.Watts(405), system.ACOutputActivePower)
+
+	// including QPGS0, which isn't kept with the responses of the connected unit
+	SetInverters([]int{0, 2})
+	SetLast(&messages.QPGSnResponse{InverterNumber: 0, ACOutputActivePower: 100, BatteryStateOfCharge: 50})
+	SetLocalResponse("QPIGS", &messages.QPIGSResponse{BatteryVoltage: "52.40"})
+	SetSettings(&messages.QPIRIResponse{})
+	defer SetSettings(nil)
+	assert.NoError(t, json.Unmarshal(get("/last/system").Body.Bytes(), &system))
+	assert.Equal(t, []int{0, 2}, system.Inverters)
+	assert.Equal(t, messages.Watts(505), system.ACOutputActivePower)
+	SetInverters([]int{2})
+	assert.Equal(t, http.StatusNotFound, get("/last/0").Code)
+	assert.NoError(t, json.Unmarshal(get("/last/system").Body.Bytes(), &system))
+	assert.Equal(t, []int{2}, system.Inverters)
+	assert.Equal(t, messages.Watts(405), system.ACOutputActivePower)
+	assert.Equal(t, messages.Percent(70), system.BatteryStateOfCharge)
+	ValueMutex.Lock()
+	assert.Contains(t, LocalResponses, "QPIGS", "the connected unit isn't removed with the inverters")
+	assert.Contains(t, LocalResponses, "QPIRI")
+	ValueMutex.Unlock()
+}
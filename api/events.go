@@ -0,0 +1,182 @@
+package phocus_api
+
+import (
+	"encoding/json" // encoding the events
+	"fmt"           // writing the events
+	"net/http"      // status codes
+	"slices"        // filtering the events
+	"strconv"       // parsing Last-Event-ID
+	"sync"          // guarding the events
+	"time"          // keeping the stream alive
+
+	"github.com/gin-gonic/gin"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+// the types of the events on /events
+const (
+	EventResponse = "response" // a new response from an inverter as an Update
+	EventEnqueue  = "enqueue"  // a message was added to the Queue
+	EventDequeue  = "dequeue"  // a message was removed from the Queue because it was sent or deleted
+	EventResult   = "result"   // the Result of sending a message
+	EventError    = "error"    // a Failure while polling the inverter
+)
+
+const (
+	eventBuffer = 256              // how many events are kept to resume from with Last-Event-ID
+	keepAlive   = 15 * time.Second // how often a comment is sent so that proxies don't close the stream
+)
+
+// Event is something that happened, which is sent on /events
+type Event struct {
+	ID   uint64 // increases by 1 for each event since phocus started, starting at 1
+	Type string // ie EventResponse
+	Data json.RawMessage
+}
+
+// Result is the outcome of sending a message, Error is empty if it succeeded
+type Result struct {
+	Message  messages.Message
+	Response json.RawMessage
+	Error    string
+}
+
+// Failure is an error while polling the inverter
+type Failure struct {
+	Message *messages.Message // the message that failed or nil if it wasn't caused by one
+	Error   string
+}
+
+// events are the latest eventBuffer events with the event with ID n at n % eventBuffer,
+// and the listeners that are told when there is a new one
+var events = struct {
+	sync.Mutex
+	ring      [eventBuffer]Event
+	next      uint64 // the ID of the next event
+	listeners map[chan struct{}]struct{}
+}{next: 1, listeners: map[chan struct{}]struct{}{}}
+
+// PublishEvent adds an event with the data as JSON and tells the listeners about it
+func PublishEvent(eventType string, data any) {
+	encoded, _ := json.Marshal(data) // err ignored because the data are plain structs
+	events.Lock()
+	defer events.Unlock()
+	events.ring[events.next%eventBuffer] = Event{ID: events.next, Type: eventType, Data: encoded}
+	events.next++
+	for listener := range events.listeners {
+		select {
+		case listener <- struct{}{}:
+		default: // it already knows that there are new events
+		}
+	}
+}
+
+// PublishError publishes a Failure event for an error which wasn't caused by a message
+func PublishError(err error) {
+	PublishEvent(EventError, Failure{Error: err.Error()})
+}
+
+// PublishResult publishes the Result of sending a message and a Failure event if it failed
+func PublishResult(message messages.Message, response any, err error) {
+	result := Result{Message: message, Response: encode(response)}
+	if err != nil {
+		result.Error = err.Error()
+		PublishEvent(EventError, Failure{Message: &message, Error: err.Error()})
+	}
+	PublishEvent(EventResult, result)
+}
+
+// eventsAfter returns the events that are still kept which came after the event with the ID
+//
+// Every event that is kept is returned if the ID is from before phocus was restarted
+func eventsAfter(id uint64) []Event {
+	events.Lock()
+	defer events.Unlock()
+	oldest := uint64(1)
+	if events.next > eventBuffer {
+		oldest = events.next - eventBuffer
+	}
+	from := oldest
+	if id < events.next && id+1 > oldest {
+		from = id + 1
+	}
+	var after []Event
+	for id := from; id < events.next; id++ {
+		after = append(after, events.ring[id%eventBuffer])
+	}
+	return after
+}
+
+// lastEventID is the ID of the latest event or 0 if there haven't been any
+func lastEventID() uint64 {
+	events.Lock()
+	defer events.Unlock()
+	return events.next - 1
+}
+
+// listen returns a channel that receives when there are new events until it is passed to unlisten
+func listen() chan struct{} {
+	events.Lock()
+	defer events.Unlock()
+	listener := make(chan struct{}, 1)
+	events.listeners[listener] = struct{}{}
+	return listener
+}
+
+// unlisten stops telling the listener about new events
+func unlisten(listener chan struct{}) {
+	events.Lock()
+	defer events.Unlock()
+	delete(events.listeners, listener)
+}
+
+// GetEvents is called to stream the events as Server-Sent Events, starting after the
+// event in the Last-Event-ID header or with the next one if there isn't one
+//
+// ?type=response&type=error only sends those types of events
+func GetEvents(c *gin.Context) {
+	last := lastEventID()
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Last-Event-ID should be a number but was %s", header)})
+			return
+		}
+		last = id
+	}
+	types := c.QueryArray("type")
+
+	// listening before the events are read means that none are missed
+	listener := listen()
+	defer unlisten(listener)
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // so that nginx doesn't buffer the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	for {
+		for _, event := range eventsAfter(last) {
+			last = event.ID
+			if len(types) > 0 && !slices.Contains(types, event.Type) {
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		select {
+		case <-listener:
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
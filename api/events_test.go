@@ -0,0 +1,169 @@
+package phocus_api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	messages "github.com/wolffshots/phocus/v2/messages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsAfter(t *testing.T) {
+	start := lastEventID()
+	PublishEvent(EventEnqueue, messages.Message{Command: "QID"})
+	PublishEvent(EventDequeue, messages.Message{Command: "QID"})
+	PublishEvent(EventResult, Result{Message: messages.Message{Command: "QID"}})
+
+	after := eventsAfter(start)
+	assert.Len(t, after, 3)
+	assert.Equal(t, start+1, after[0].ID)
+	assert.Equal(t, EventEnqueue, after[0].Type)
+	assert.Len(t, eventsAfter(start+2), 1)
+	assert.Empty(t, eventsAfter(start+3))
+
+	// only the latest eventBuffer events are kept
+	for range eventBuffer {
+		PublishEvent(EventEnqueue, messages.Message{Command: "QID"})
+	}
+	after = eventsAfter(start)
+	assert.Len(t, after, eventBuffer)
+	assert.Equal(t, start+4, after[0].ID)
+	assert.Equal(t, lastEventID(), after[eventBuffer-1].ID)
+
+	// IDs from before a restart get everything that is kept
+	assert.Len(t, eventsAfter(lastEventID()+100), eventBuffer)
+}
+
+func TestPublishResult(t *testing.T) {
+	start := lastEventID()
+	message := messages.Message{ID: uuid.New(), Command: "QPGS1"}
+	PublishResult(message, &messages.QPGSnResponse{InverterNumber: 1}, nil)
+	PublishResult(message, nil, errors.New("no response"))
+	PublishError(errors.New("discovery failed"))
+
+	after := eventsAfter(start)
+	assert.Len(t, after, 4)
+	var result Result
+	assert.Equal(t, EventResult, after[0].Type)
+	assert.NoError(t, json.Unmarshal(after[0].Data, &result))
+	assert.Equal(t, message, result.Message)
+	assert.JSONEq(t, messages.EncodeQPGSn(&messages.QPGSnResponse{InverterNumber: 1}), string(result.Response))
+	assert.Empty(t, result.Error)
+
+	// failures are an error event as well as a result
+	var failure Failure
+	assert.Equal(t, EventError, after[1].Type)
+	assert.NoError(t, json.Unmarshal(after[1].Data, &failure))
+	assert.Equal(t, &message, failure.Message)
+	assert.Equal(t, "no response", failure.Error)
+	assert.Equal(t, EventResult, after[2].Type)
+	assert.NoError(t, json.Unmarshal(after[2].Data, &result))
+	assert.Equal(t, "no response", result.Error)
+	assert.Equal(t, `{"Message":null,"Error":"discovery failed"}`, string(after[3].Data))
+}
+
+// readEvent reads the next event from a stream, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) Event {
+	var event Event
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.Type != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.ID, err = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			assert.NoError(t, err)
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.Data = json.RawMessage(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	ts := httptest.NewServer(SetupRouter(gin.TestMode, false))
+	defer ts.Close()
+	Queue = make([]messages.Message, 0)
+	defer func() { Queue = make([]messages.Message, 0) }()
+	SetLast(nil)
+	defer SetLast(nil)
+	stream := func(query string, lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events"+query, nil)
+		assert.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	// only new events are sent without a Last-Event-ID
+	PublishEvent(EventError, Failure{Error: "before connecting"})
+	reader, stop := stream("", "")
+	message := messages.Message{ID: uuid.New(), Command: "QPIGS"}
+	assert.NoError(t, Enqueue(message))
+	enqueued := readEvent(t, reader)
+	assert.Equal(t, EventEnqueue, enqueued.Type)
+	assert.JSONEq(t, fmt.Sprintf(`{"id":"%s","command":"QPIGS","payload":""}`, message.ID), string(enqueued.Data))
+
+	QueueMutex.Lock()
+	Pop()
+	QueueMutex.Unlock()
+	dequeued := readEvent(t, reader)
+	assert.Equal(t, EventDequeue, dequeued.Type)
+	assert.Equal(t, enqueued.ID+1, dequeued.ID)
+
+	// the responses are the same updates that are pushed to the websockets
+	response := &messages.QPGSnResponse{InverterNumber: 2, BatteryVoltage: 51.3}
+	SetLast(response)
+	update := readEvent(t, reader)
+	assert.Equal(t, EventResponse, update.Type)
+	var actual Update
+	assert.NoError(t, json.Unmarshal(update.Data, &actual))
+	assert.Equal(t, Update{Inverter: 2, Command: "QPGSn", Response: encode(response)}, actual)
+	stop()
+
+	// reconnecting with the Last-Event-ID resumes after it
+	reader, stop = stream("", strconv.FormatUint(enqueued.ID, 10))
+	assert.Equal(t, dequeued, readEvent(t, reader))
+	assert.Equal(t, update.ID, readEvent(t, reader).ID)
+	stop()
+
+	// and the types can be filtered
+	reader, stop = stream("?type=response", strconv.FormatUint(enqueued.ID, 10))
+	assert.Equal(t, update.ID, readEvent(t, reader).ID)
+	stop()
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "latest")
+	SetupRouter(gin.TestMode, false).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,247 @@
+package phocus_api
+
+import (
+	"encoding/json" // encoding the updates
+	"log"           // logging disconnects
+	"slices"        // matching subscriptions
+	"strconv"       // parsing the inverters of a subscription
+	"strings"       // matching commands
+	"sync"          // guarding the subscribers
+	"time"          // ping and write deadlines
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+const (
+	writeWait  = 10 * time.Second  // how long a write to a client may take
+	pongWait   = 60 * time.Second  // how long to wait for a pong before the client is assumed gone
+	pingPeriod = pongWait * 9 / 10 // how often clients are pinged, which must be less than pongWait
+	sendBuffer = 16                // how many updates a client can fall behind by before it is dropped
+	readLimit  = 4096              // how big a subscription sent by a client may be
+)
+
+// Update is a new response pushed to the subscribers
+type Update struct {
+	Inverter int             // the inverter number or Local for responses from the connected unit
+	Command  string          // ie QPGSn
+	Response json.RawMessage // the response as JSON
+}
+
+// Subscription is what a subscriber wants updates for, empty for everything
+type Subscription struct {
+	Inverters []int    // ie [1, 2]
+	Commands  []string // ie ["QPGSn", "QPIWS"]
+}
+
+// Matches checks if the update is one that was subscribed to
+func (subscription Subscription) Matches(update Update) bool {
+	if len(subscription.Inverters) > 0 && !slices.Contains(subscription.Inverters, update.Inverter) {
+		return false
+	}
+	if len(subscription.Commands) > 0 && !slices.ContainsFunc(subscription.Commands, func(command string) bool {
+		return strings.EqualFold(command, update.Command)
+	}) {
+		return false
+	}
+	return true
+}
+
+// subscriber receives the updates that match its subscription until it is unsubscribed
+type subscriber struct {
+	updates      chan Update
+	subscription Subscription
+	dropped      bool // it was unsubscribed because it fell behind rather than because it disconnected
+}
+
+// hub is the subscribers that updates are pushed to
+var hub = struct {
+	sync.Mutex
+	subscribers map[*subscriber]struct{}
+}{subscribers: map[*subscriber]struct{}{}}
+
+// subscribe adds a subscriber, whose updates channel is closed when it is unsubscribed
+func subscribe(subscription Subscription) *subscriber {
+	hub.Lock()
+	defer hub.Unlock()
+	subscriber := &subscriber{updates: make(chan Update, sendBuffer), subscription: subscription}
+	hub.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+// unsubscribe removes the subscriber and closes its updates, it can be called more than once
+func unsubscribe(subscriber *subscriber) {
+	hub.Lock()
+	defer hub.Unlock()
+	unsubscribeLocked(subscriber)
+}
+
+// unsubscribeLocked is unsubscribe for when the hub is already locked
+func unsubscribeLocked(subscriber *subscriber) {
+	if _, ok := hub.subscribers[subscriber]; ok {
+		delete(hub.subscribers, subscriber)
+		close(subscriber.updates)
+	}
+}
+
+// resubscribe replaces what the subscriber wants updates for
+func resubscribe(subscriber *subscriber, subscription Subscription) {
+	hub.Lock()
+	defer hub.Unlock()
+	subscriber.subscription = subscription
+}
+
+// wasDropped checks if the subscriber was unsubscribed because it fell behind
+func wasDropped(subscriber *subscriber) bool {
+	hub.Lock()
+	defer hub.Unlock()
+	return subscriber.dropped
+}
+
+// Subscribers is how many subscribers there are
+func Subscribers() int {
+	hub.Lock()
+	defer hub.Unlock()
+	return len(hub.subscribers)
+}
+
+// Broadcast pushes the update to every subscriber that wants it without waiting
+//
+// Subscribers that have fallen sendBuffer updates behind are unsubscribed so that
+// a slow client can't hold up the others or the polling of the inverter
+func Broadcast(update Update) {
+	hub.Lock()
+	defer hub.Unlock()
+	for subscriber := range hub.subscribers {
+		if !subscriber.subscription.Matches(update) {
+			continue
+		}
+		select {
+		case subscriber.updates <- update:
+		default:
+			log.Printf("Dropping a subscriber that is %d updates behind\n", sendBuffer)
+			subscriber.dropped = true
+			unsubscribeLocked(subscriber)
+		}
+	}
+}
+
+// encode encodes a response as it is shown on /last
+func encode(response any) json.RawMessage {
+	if response, ok := response.(*messages.QPGSnResponse); ok {
+		return json.RawMessage(messages.EncodeQPGSn(response))
+	}
+	encoded, _ := json.Marshal(response) // err ignored because the responses are plain structs
+	return encoded
+}
+
+// parseSubscription reads a subscription from the query, ie ?inverter=1&inverter=2&command=QPGSn
+func parseSubscription(c *gin.Context, fallback Subscription) Subscription {
+	subscription := fallback
+	if commands := c.QueryArray("command"); len(commands) > 0 {
+		subscription.Commands = commands
+	}
+	for _, inverter := range c.QueryArray("inverter") {
+		if inverterNum, err := strconv.Atoi(inverter); err == nil {
+			subscription.Inverters = append(subscription.Inverters, inverterNum)
+		}
+	}
+	return subscription
+}
+
+// serveWS pushes the current state and then every update that matches the subscription
+// in the query to a websocket until it is closed
+//
+// The client can change its subscription by sending a new one as JSON. Enveloped clients
+// get each update as an Update, the others only get the response.
+func serveWS(ctx *gin.Context, fallback Subscription, enveloped bool) {
+	subscription := parseSubscription(ctx, fallback)
+
+	// subscribing while the values are locked means that no update is missed or sent twice
+	// and subscribing before upgrading means that none are missed once the client is connected
+	ValueMutex.Lock()
+	subscriber := subscribe(subscription)
+	current := lastUpdates(subscription)
+	if !enveloped && len(subscription.Inverters) == 0 && slices.Equal(subscription.Commands, fallback.Commands) {
+		// /last-ws has always started with the latest response of any inverter, even before the first one
+		current = []Update{{Command: "QPGSn", Response: encode(LastQPGSResponse)}}
+	}
+	ValueMutex.Unlock()
+
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Println("upgrade err:", err)
+		unsubscribe(subscriber)
+		return
+	}
+	go writeWS(conn, subscriber, current, enveloped)
+	readWS(conn, subscriber)
+}
+
+// readWS reads subscriptions and pongs from the client until it disconnects
+func readWS(conn *websocket.Conn, subscriber *subscriber) {
+	defer unsubscribe(subscriber)
+	conn.SetReadLimit(readLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var subscription Subscription
+		if err := json.Unmarshal(message, &subscription); err != nil {
+			log.Printf("Ignoring a subscription that isn't valid: %v\n", err)
+			continue
+		}
+		resubscribe(subscriber, subscription)
+	}
+}
+
+// writeWS writes the current state and then the updates and pings to the client
+// until it is unsubscribed or a write fails
+func writeWS(conn *websocket.Conn, subscriber *subscriber, current []Update, enveloped bool) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+		unsubscribe(subscriber)
+	}()
+	write := func(update Update) error {
+		data := []byte(update.Response)
+		if enveloped {
+			data, _ = json.Marshal(update)
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	for _, update := range current {
+		if write(update) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case update, ok := <-subscriber.updates:
+			if !ok {
+				// clients that disconnected are unsubscribed too but there is no one to tell
+				if wasDropped(subscriber) {
+					_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+					_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				}
+				return
+			}
+			if write(update) != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package phocus_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	messages "github.com/wolffshots/phocus/v2/messages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	update := Update{Inverter: 1, Command: "QPGSn"}
+	assert.True(t, Subscription{}.Matches(update))
+	assert.True(t, Subscription{Inverters: []int{1, 2}}.Matches(update))
+	assert.True(t, Subscription{Commands: []string{"qpgsn"}}.Matches(update))
+	assert.False(t, Subscription{Inverters: []int{2}}.Matches(update))
+	assert.False(t, Subscription{Inverters: []int{1}, Commands: []string{"QPIGS"}}.Matches(update))
+}
+
+func TestBroadcast(t *testing.T) {
+	wanted := subscribe(Subscription{Inverters: []int{1}})
+	defer unsubscribe(wanted)
+	other := subscribe(Subscription{Inverters: []int{2}})
+	defer unsubscribe(other)
+
+	Broadcast(Update{Inverter: 1, Command: "QPGSn", Response: json.RawMessage("{}")})
+	assert.Len(t, wanted.updates, 1)
+	assert.Len(t, other.updates, 0)
+
+	// subscribers that don't keep up are dropped instead of holding up the rest
+	for range sendBuffer {
+		Broadcast(Update{Inverter: 1, Command: "QPGSn", Response: json.RawMessage("{}")})
+	}
+	for range sendBuffer {
+		<-wanted.updates
+	}
+	_, open := <-wanted.updates
+	assert.False(t, open)
+	hub.Lock()
+	assert.NotContains(t, hub.subscribers, wanted)
+	assert.Contains(t, hub.subscribers, other)
+	hub.Unlock()
+	assert.True(t, wasDropped(wanted))
+	assert.False(t, wasDropped(other))
+}
+
+func TestWriteWSClose(t *testing.T) {
+	// serve writes to each client with writeWS until its subscriber is unsubscribed
+	subscribers := make(chan *subscriber, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		subscriber := subscribe(Subscription{})
+		subscribers <- subscriber
+		writeWS(conn, subscriber, nil, true)
+	}))
+	defer ts.Close()
+	closed := func(unsubscribe func(*subscriber)) error {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:], nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer conn.Close()
+		unsubscribe(<-subscribers)
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err = conn.ReadMessage()
+		return err
+	}
+
+	// subscribers that fell behind are told why they were dropped
+	err := closed(func(subscriber *subscriber) {
+		hub.Lock()
+		defer hub.Unlock()
+		subscriber.dropped = true // like Broadcast does
+		unsubscribeLocked(subscriber)
+	})
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err)
+
+	// but not the ones that were unsubscribed because they disconnected
+	err = closed(unsubscribe)
+	assert.Error(t, err)
+	assert.False(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err)
+}
+
+func TestWS(t *testing.T) {
+	upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	ts := httptest.NewServer(SetupRouter(gin.TestMode, false))
+	defer ts.Close()
+	SetLast(nil)
+	defer SetLast(nil)
+	read := func(conn *websocket.Conn) Update {
+		var update Update
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		assert.NoError(t, conn.ReadJSON(&update))
+		return update
+	}
+	subscribed := func(subscription Subscription) func() bool {
+		return func() bool {
+			hub.Lock()
+			defer hub.Unlock()
+			for subscriber := range hub.subscribers {
+				if slices.Equal(subscriber.subscription.Commands, subscription.Commands) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	first := &messages.QPGSnResponse{InverterNumber: 1, BatteryVoltage: 51.1}
+	SetLast(first)
+	SetLocalResponse("QPIGS", &messages.QPIGSResponse{BatteryVoltage: "52.40"})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"/ws?inverter=1&command=QPGSn", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the current state is sent straight away
+	assert.Equal(t, Update{Inverter: 1, Command: "QPGSn", Response: encode(first)}, read(conn))
+
+	// and only the updates that were subscribed to after that
+	SetLast(&messages.QPGSnResponse{InverterNumber: 2, BatteryVoltage: 51.2})
+	second := &messages.QPGSnResponse{InverterNumber: 1, BatteryVoltage: 51.3}
+	SetLast(second)
+	assert.Equal(t, Update{Inverter: 1, Command: "QPGSn", Response: encode(second)}, read(conn))
+
+	// the subscription can be changed by sending a new one
+	assert.NoError(t, conn.WriteJSON(Subscription{Commands: []string{"QPIWS"}}))
+	assert.Eventually(t, subscribed(Subscription{Commands: []string{"QPIWS"}}), time.Second, 10*time.Millisecond)
+	SetLast(second)
+	SetLocalResponse("QPIWS", &messages.QPIWSResponse{InverterFault: true})
+	update := read(conn)
+	assert.Equal(t, "QPIWS", update.Command)
+	assert.Contains(t, string(update.Response), `"InverterFault":true`)
+
+	// subscriptions that can't be read are ignored
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("QPGSn")))
+	SetLocalResponse("QPIWS", &messages.QPIWSResponse{})
+	assert.Equal(t, "QPIWS", read(conn).Command)
+
+	// the subscriber is removed once the client disconnects
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return !subscribed(Subscription{Commands: []string{"QPIWS"}})() }, time.Second, 10*time.Millisecond)
+}
+
+func TestLastWSForOneInverter(t *testing.T) {
+	upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	ts := httptest.NewServer(SetupRouter(gin.TestMode, false))
+	defer ts.Close()
+	SetLast(nil)
+	defer SetLast(nil)
+
+	first := &messages.QPGSnResponse{InverterNumber: 1, BatteryVoltage: 51.1}
+	second := &messages.QPGSnResponse{InverterNumber: 2, BatteryVoltage: 51.2}
+	SetLast(first)
+	SetLast(second)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[4:]+"/last-ws?inverter=1", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	// only the response is sent, starting with the latest of the inverter
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, messages.EncodeQPGSn(first), string(message))
+	SetLast(second)
+	SetLast(first)
+	_, message, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, messages.EncodeQPGSn(first), string(message))
+}
@@ -0,0 +1,178 @@
+// Package phocus_energy integrates the power reported by the
+// inverters into kWh counters for the Home Assistant energy dashboard
+package phocus_energy
+
+import (
+	"encoding/json" // encoding and persisting the counters
+	"errors"        // checking for a missing file
+	"fmt"           // string formatting
+	"log"           // logging to stdout
+	"os"            // persisting the counters
+	"strings"       // checking serial numbers
+	"sync"          // guarding the counters
+	"time"          // integrating over time
+
+	messages "github.com/wolffshots/phocus/v2/messages"
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt"
+)
+
+// Counters are the energy in kWh that an inverter has converted since phocus started counting
+type Counters struct {
+	PVEnergy               float64 `ha:"pv_energy,name=PV Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:solar-power"`
+	ACOutputEnergy         float64 `ha:"ac_output_energy,name=AC Output Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:power-socket"`
+	BatteryChargeEnergy    float64 `ha:"battery_charge_energy,name=Battery Charge Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:battery-plus"`
+	BatteryDischargeEnergy float64 `ha:"battery_discharge_energy,name=Battery Discharge Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:battery-minus"`
+	GridImportEnergy       float64 `ha:"grid_import_energy,name=Grid Import Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:transmission-tower-import"`
+}
+
+// power is what an inverter was converting in W when it was polled
+type power struct {
+	at               time.Time
+	pv               float64
+	acOutput         float64
+	batteryCharge    float64
+	batteryDischarge float64
+	gridImport       float64
+}
+
+// MaxGap is the longest time between two readings that is integrated over,
+// longer gaps (ie while phocus was stopped or the serial was down) are skipped
+// instead of guessing what happened in between
+const MaxGap = 5 * time.Minute
+
+// saveInterval is how often the counters are written to the file at most
+const saveInterval = time.Minute
+
+// state is the counters and last reading of each inverter by serial number
+var state = struct {
+	sync.Mutex
+	file     string
+	counters map[string]*Counters
+	last     map[string]power
+	saved    time.Time
+}{counters: map[string]*Counters{}, last: map[string]power{}}
+
+// Load reads the counters kept in file and persists them there from now on
+//
+// A file that doesn't exist yet starts the counters from zero
+func Load(file string) error {
+	state.Lock()
+	defer state.Unlock()
+	state.file = file
+	state.counters = map[string]*Counters{}
+	state.last = map[string]power{}
+	state.saved = time.Time{}
+	contents, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, &state.counters)
+}
+
+// read is the power in a QPGSn response
+//
+// The inverters don't report how much they draw from the grid so it is
+// estimated as what goes out to the loads and the battery less what comes
+// from PV, while the inverter is in grid mode
+func read(response *messages.QPGSnResponse, at time.Time) power {
+	reading := power{
+		at:               at,
+		pv:               float64(response.PVInputVoltage) * float64(response.PVInputCurrent),
+		acOutput:         float64(response.ACOutputActivePower),
+		batteryCharge:    float64(response.BatteryVoltage) * float64(response.BatteryChargingCurrent),
+		batteryDischarge: float64(response.BatteryVoltage) * float64(response.BatteryDischargeCurrent),
+	}
+	if response.OperationMode == messages.OperationModes["L"] {
+		reading.gridImport = max(0, reading.acOutput+reading.batteryCharge-reading.pv)
+	}
+	return reading
+}
+
+// Add integrates the power in the response since the last one from the same inverter
+//
+// Returns the counters of the inverter, or nil for responses from units
+// without a serial number. The error is from saving the counters, which
+// are still counted in memory if it fails
+func Add(response *messages.QPGSnResponse, at time.Time) (*Counters, error) {
+	if response == nil || strings.Trim(response.SerialNumber, "0 ") == "" {
+		return nil, nil
+	}
+	state.Lock()
+	defer state.Unlock()
+	serial := response.SerialNumber
+	counters, found := state.counters[serial]
+	if !found {
+		counters = &Counters{}
+		state.counters[serial] = counters
+	}
+	reading := read(response, at)
+	if last, found := state.last[serial]; found {
+		elapsed := reading.at.Sub(last.at)
+		if elapsed > 0 && elapsed <= MaxGap {
+			// trapezoidal rule from W over the elapsed time to kWh
+			hours := elapsed.Hours()
+			counters.PVEnergy += (last.pv + reading.pv) / 2 * hours / 1000
+			counters.ACOutputEnergy += (last.acOutput + reading.acOutput) / 2 * hours / 1000
+			counters.BatteryChargeEnergy += (last.batteryCharge + reading.batteryCharge) / 2 * hours / 1000
+			counters.BatteryDischargeEnergy += (last.batteryDischarge + reading.batteryDischarge) / 2 * hours / 1000
+			counters.GridImportEnergy += (last.gridImport + reading.gridImport) / 2 * hours / 1000
+		}
+	}
+	state.last[serial] = reading
+	copied := *counters
+	if state.file == "" || at.Sub(state.saved) < saveInterval {
+		return &copied, nil
+	}
+	state.saved = at
+	return &copied, save()
+}
+
+// save writes the counters to the file, through a temporary file so that a
+// crash while writing doesn't lose them
+//
+// Expects the state to be locked
+func save() error {
+	contents, err := json.Marshal(state.counters)
+	if err != nil {
+		return err
+	}
+	temporary := state.file + ".tmp"
+	err = os.WriteFile(temporary, contents, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, state.file)
+}
+
+// Save writes the counters to the file straight away, ie before stopping
+func Save() error {
+	state.Lock()
+	defer state.Unlock()
+	if state.file == "" {
+		return nil
+	}
+	return save()
+}
+
+// Topic is where the counters of the QPGSn inverter are published under the base topic
+func Topic(inverterNum int) string {
+	return fmt.Sprintf("stats/energy%d", inverterNum)
+}
+
+// Encode encodes the counters as JSON
+func Encode(counters *Counters) string {
+	jsonCounters, _ := json.Marshal(counters) // err ignored because it can't fail with this input
+	return string(jsonCounters)
+}
+
+// Publish sends the counters of the QPGSn inverter to MQTT
+func Publish(client phocus_mqtt.Client, counters *Counters, inverterNum int) error {
+	jsonCounters := Encode(counters)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic(Topic(inverterNum)), 0, true, jsonCounters, 10)
+	if err != nil {
+		log.Printf("MQTT send of energy%d failed with: %v\n", inverterNum, err)
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package phocus_energy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+// response is a QPGSn response from an inverter in the mode with the powers in W, V and A
+func response(serial string, mode string, pvVoltage float64, pvCurrent float64, acOutput int, batteryVoltage float64, charging float64, discharging float64) *messages.QPGSnResponse {
+	return &messages.QPGSnResponse{
+		InverterNumber:          1,
+		SerialNumber:            serial,
+		OperationMode:           messages.OperationModes[mode],
+		PVInputVoltage:          messages.Volts(pvVoltage),
+		PVInputCurrent:          messages.Amps(pvCurrent),
+		ACOutputActivePower:     messages.Watts(acOutput),
+		BatteryVoltage:          messages.Volts(batteryVoltage),
+		BatteryChargingCurrent:  messages.Amps(charging),
+		BatteryDischargeCurrent: messages.Amps(discharging),
+	}
+}
+
+func TestAdd(t *testing.T) {
+	assert.NoError(t, Load(""))
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// the first reading only starts the integration
+	counters, err := Add(response("92932004102443", "B", 200, 5, 600, 50, 8, 0), start)
+	assert.NoError(t, err)
+	assert.Equal(t, &Counters{}, counters)
+
+	// 3 minutes at 1000 W of PV, 600 W of output and 400 W into the battery
+	counters, err = Add(response("92932004102443", "B", 200, 5, 600, 50, 8, 0), start.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.05, counters.PVEnergy, 1e-9)
+	assert.InDelta(t, 0.03, counters.ACOutputEnergy, 1e-9)
+	assert.InDelta(t, 0.02, counters.BatteryChargeEnergy, 1e-9)
+	assert.Zero(t, counters.BatteryDischargeEnergy)
+	assert.Zero(t, counters.GridImportEnergy, "nothing is imported while off-grid")
+
+	// the power ramping down to nothing over the next 3 minutes is averaged
+	counters, err = Add(response("92932004102443", "B", 0, 0, 0, 50, 0, 0), start.Add(6*time.Minute))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.075, counters.PVEnergy, 1e-9)
+	assert.InDelta(t, 0.045, counters.ACOutputEnergy, 1e-9)
+
+	// gaps longer than MaxGap aren't counted
+	counters, err = Add(response("92932004102443", "B", 200, 5, 600, 50, 0, 12), start.Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.075, counters.PVEnergy, 1e-9)
+	counters, err = Add(response("92932004102443", "B", 200, 5, 600, 50, 0, 12), start.Add(3*time.Hour+MaxGap))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.075+1000*MaxGap.Hours()/1000, counters.PVEnergy, 1e-9)
+	assert.InDelta(t, 600*MaxGap.Hours()/1000, counters.BatteryDischargeEnergy, 1e-9)
+
+	// readings from the past aren't counted either
+	counters, err = Add(response("92932004102443", "B", 200, 5, 600, 50, 0, 12), start)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.075+1000*MaxGap.Hours()/1000, counters.PVEnergy, 1e-9)
+
+	// units without a serial number aren't counted
+	counters, err = Add(response("00000000000000", "B", 200, 5, 600, 50, 0, 0), start)
+	assert.NoError(t, err)
+	assert.Nil(t, counters)
+	counters, err = Add(nil, start)
+	assert.NoError(t, err)
+	assert.Nil(t, counters)
+}
+
+func TestGridImport(t *testing.T) {
+	assert.NoError(t, Load(""))
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// in grid mode the loads and charging that PV doesn't cover come from the grid
+	_, err := Add(response("92932004102443", "L", 100, 2, 500, 50, 10, 0), start)
+	assert.NoError(t, err)
+	counters, err := Add(response("92932004102443", "L", 100, 2, 500, 50, 10, 0), start.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.04, counters.GridImportEnergy, 1e-9)
+
+	// and nothing is exported when PV covers more than that
+	counters, err = Add(response("92932004102443", "L", 300, 10, 500, 50, 10, 0), start.Add(6*time.Minute))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.06, counters.GridImportEnergy, 1e-9)
+}
+
+func TestPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "energy.json")
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// a missing file starts from zero
+	assert.NoError(t, Load(file))
+	_, err := Add(response("92932004102443", "B", 200, 5, 0, 50, 0, 0), start)
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"92932004102443": {"PVEnergy":0,"ACOutputEnergy":0,"BatteryChargeEnergy":0,"BatteryDischargeEnergy":0,"GridImportEnergy":0}
+	}`, string(contents))
+
+	// the counters aren't written more often than every saveInterval
+	_, err = Add(response("92932004102453", "B", 100, 5, 0, 50, 0, 0), start)
+	assert.NoError(t, err)
+	_, err = Add(response("92932004102443", "B", 200, 5, 0, 50, 0, 0), start.Add(saveInterval/2))
+	assert.NoError(t, err)
+	contents, err = os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(contents), "92932004102453")
+
+	// unless they are saved straight away
+	assert.NoError(t, Save())
+	contents, err = os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "92932004102453")
+	assert.NoError(t, Load(file))
+	counters, err := Add(response("92932004102443", "B", 0, 0, 0, 50, 0, 0), start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1000*(saveInterval/2).Hours()/1000, counters.PVEnergy, 1e-9)
+
+	// counting carries on from what was loaded
+	counters, err = Add(response("92932004102443", "B", 200, 5, 0, 50, 0, 0), start.Add(time.Hour+MaxGap))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1000*(saveInterval/2).Hours()/1000+500*MaxGap.Hours()/1000, counters.PVEnergy, 1e-9)
+
+	// a file that can't be read is an error
+	assert.NoError(t, os.WriteFile(file, []byte("not json"), 0o644))
+	assert.Error(t, Load(file))
+	assert.NoError(t, Load(filepath.Join(t.TempDir(), "missing", "energy.json")))
+	_, err = Add(response("92932004102443", "B", 200, 5, 0, 50, 0, 0), start)
+	assert.Error(t, err, "the directory doesn't exist so it can't be saved")
+}
+
+func TestPublish(t *testing.T) {
+	assert.Equal(t, "stats/energy2", Topic(2))
+	assert.Equal(t, "null", Encode(nil))
+	assert.Equal(t, `{"PVEnergy":1.5,"ACOutputEnergy":0,"BatteryChargeEnergy":0,"BatteryDischargeEnergy":0,"GridImportEnergy":0}`, Encode(&Counters{PVEnergy: 1.5}))
+	assert.EqualError(t, Publish(nil, &Counters{}, 1), "client not defined in send")
+}
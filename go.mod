@@ -10,9 +10,10 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
 	github.com/sigurn/crc16 v0.0.0-20211026045750-20ab5afb07e3
-	github.com/stretchr/testify v1.8.4
+	github.com/stretchr/testify v1.10.0
 	github.com/wolffshots/ha_types v1.1.1
 	go.bug.st/serial v1.5.0
+	go.etcd.io/bbolt v1.4.3
 )
 
 require (
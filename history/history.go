@@ -0,0 +1,320 @@
+// Package phocus_history keeps an on-disk history of the numbers
+// in the responses so that they can be charted without Home Assistant
+package phocus_history
+
+import (
+	"bytes"           // comparing keys
+	"encoding/binary" // encoding keys and values
+	"errors"          // custom errors
+	"fmt"             // string formatting
+	"math"            // encoding floats
+	"reflect"         // reading the fields of responses
+	"strconv"         // parsing numbers in strings
+	"sync"            // guarding the store
+	"time"            // timestamps and retention
+
+	messages "github.com/wolffshots/phocus/v2/messages"
+	bolt "go.etcd.io/bbolt" // embedded store
+)
+
+// Point is the value of a field at a time, or the average over the step starting at the time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+const (
+	RawRetention    = 7 * 24 * time.Hour   // how long every value is kept for
+	MinuteRetention = 365 * 24 * time.Hour // how long the 1 minute averages are kept for
+	pruneInterval   = time.Hour            // how often the expired values are removed
+)
+
+// buckets of the store which hold a bucket for each series (ie qpgs1/BatteryVoltage)
+var (
+	rawBucket    = []byte("raw")    // keyed by the time in ms with the value
+	minuteBucket = []byte("minute") // keyed by the start of the minute in ms with the sum and count of the values
+)
+
+// ErrClosed is returned when the store hasn't been opened
+var ErrClosed = errors.New("history is not open")
+
+// store is the open database and when it was last pruned
+var store = struct {
+	sync.Mutex
+	db     *bolt.DB
+	pruned time.Time
+}{}
+
+// Open opens or creates the history in file, closing the one that was open
+func Open(file string) error {
+	db, err := bolt.Open(file, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{rawBucket, minuteBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	if store.db != nil {
+		store.db.Close()
+	}
+	store.db = db
+	store.pruned = time.Time{}
+	return nil
+}
+
+// Close closes the history, it can be opened again
+func Close() error {
+	store.Lock()
+	defer store.Unlock()
+	if store.db == nil {
+		return nil
+	}
+	err := store.db.Close()
+	store.db = nil
+	return err
+}
+
+// Source is what the history of a response is kept under, ie qpgs1 for QPGS1
+//
+// Returns false for responses without a history
+func Source(response any) (string, bool) {
+	switch response := response.(type) {
+	case *messages.QPGSnResponse:
+		if response == nil {
+			return "", false
+		}
+		return fmt.Sprintf("qpgs%d", response.InverterNumber), true
+	case *messages.QPIGSResponse:
+		return "qpigs", response != nil
+	case *messages.QPIWSResponse:
+		return "qpiws", response != nil
+	case *messages.QPIRIResponse:
+		return "qpiri", response != nil
+	default:
+		return "", false
+	}
+}
+
+// Fields are the numbers in a response by the name of their field, bools and statuses
+// are 1 or 0 and strings are kept if they are numbers like the measurements of QPIGS
+//
+// Fields of nested structs are named after the struct, ie DeviceStatus.LoadOn, and
+// fields tagged with `ha:"-"` are skipped like they are for the sensors
+func Fields(response any) map[string]float64 {
+	fields := map[string]float64{}
+	value := reflect.Indirect(reflect.ValueOf(response))
+	if value.Kind() == reflect.Struct {
+		flatten(value, "", fields)
+	}
+	return fields
+}
+
+// flatten adds the numbers in the struct to fields with the prefix before their names
+func flatten(structure reflect.Value, prefix string, fields map[string]float64) {
+	for i := 0; i < structure.NumField(); i++ {
+		field := structure.Type().Field(i)
+		value := structure.Field(i)
+		name := prefix + field.Name
+		if field.Tag.Get("ha") == "-" {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Struct:
+			flatten(value, name+".", fields)
+		case reflect.Float32, reflect.Float64:
+			fields[name] = value.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fields[name] = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fields[name] = float64(value.Uint())
+		case reflect.String:
+			if status, ok := value.Interface().(messages.Status); ok {
+				// statuses which weren't sent by older firmware are empty
+				switch status {
+				case messages.Statuses["1"]:
+					fields[name] = 1
+				case messages.Statuses["0"]:
+					fields[name] = 0
+				}
+			} else if number, err := strconv.ParseFloat(value.String(), 64); err == nil {
+				fields[name] = number
+			}
+		case reflect.Bool:
+			fields[name] = 0
+			if value.Bool() {
+				fields[name] = 1
+			}
+		}
+	}
+}
+
+// key is the time in ms as big endian so that the keys sort by time
+func key(at time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(at.UnixMilli()))
+}
+
+// keyTime is the time of a key
+func keyTime(key []byte) time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(key)))
+}
+
+// series is the name of the bucket of a field of a source
+func series(source string, field string) []byte {
+	return []byte(source + "/" + field)
+}
+
+// Record adds the numbers in the response to the history under the source at the time
+//
+// Values older than their retention are pruned every hour as well
+func Record(source string, response any, at time.Time) error {
+	store.Lock()
+	defer store.Unlock()
+	if store.db == nil {
+		return ErrClosed
+	}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for field, value := range Fields(response) {
+			raw, err := tx.Bucket(rawBucket).CreateBucketIfNotExists(series(source, field))
+			if err != nil {
+				return err
+			}
+			err = raw.Put(key(at), binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+			if err != nil {
+				return err
+			}
+			minute, err := tx.Bucket(minuteBucket).CreateBucketIfNotExists(series(source, field))
+			if err != nil {
+				return err
+			}
+			sum, count := 0.0, 0.0
+			if aggregate := minute.Get(key(at.Truncate(time.Minute))); aggregate != nil {
+				sum = math.Float64frombits(binary.BigEndian.Uint64(aggregate[:8]))
+				count = math.Float64frombits(binary.BigEndian.Uint64(aggregate[8:]))
+			}
+			aggregate := binary.BigEndian.AppendUint64(nil, math.Float64bits(sum+value))
+			aggregate = binary.BigEndian.AppendUint64(aggregate, math.Float64bits(count+1))
+			err = minute.Put(key(at.Truncate(time.Minute)), aggregate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || at.Sub(store.pruned) < pruneInterval {
+		return err
+	}
+	store.pruned = at
+	return prune(at)
+}
+
+// prune removes the values which are older than their retention at the time
+//
+// Expects the store to be locked
+func prune(now time.Time) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, retention := range []struct {
+			bucket []byte
+			cutoff []byte
+		}{
+			{rawBucket, key(now.Add(-RawRetention))},
+			{minuteBucket, key(now.Add(-MinuteRetention))},
+		} {
+			parent := tx.Bucket(retention.bucket)
+			err := parent.ForEachBucket(func(name []byte) error {
+				bucket := parent.Bucket(name)
+				// the keys are collected first since deleting while iterating skips keys
+				var expired [][]byte
+				cursor := bucket.Cursor()
+				for key, _ := cursor.First(); key != nil && bytes.Compare(key, retention.cutoff) < 0; key, _ = cursor.Next() {
+					expired = append(expired, key)
+				}
+				for _, key := range expired {
+					if err := bucket.Delete(key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Query returns the history of a field of a source between from and to
+//
+// A step shorter than a minute returns every value, averaged over the step
+// if it isn't 0, and is only kept for RawRetention. Longer steps average
+// the 1 minute averages which are kept for MinuteRetention.
+func Query(source string, field string, from time.Time, to time.Time, step time.Duration) ([]Point, error) {
+	store.Lock()
+	defer store.Unlock()
+	if store.db == nil {
+		return nil, ErrClosed
+	}
+	resolution := rawBucket
+	if step >= time.Minute {
+		resolution = minuteBucket
+	}
+	points := []Point{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(resolution).Bucket(series(source, field))
+		if bucket == nil {
+			return nil
+		}
+		var window time.Time
+		sum, count := 0.0, 0.0
+		add := func() {
+			if count > 0 {
+				points = append(points, Point{Time: window, Value: sum / count})
+			}
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek(key(from)); key != nil && !keyTime(key).After(to); key, value = cursor.Next() {
+			at := keyTime(key)
+			valueSum, valueCount := math.Float64frombits(binary.BigEndian.Uint64(value[:8])), 1.0
+			if len(value) == 16 {
+				valueCount = math.Float64frombits(binary.BigEndian.Uint64(value[8:]))
+			}
+			if step > 0 {
+				at = at.Truncate(step)
+			}
+			if step == 0 {
+				points = append(points, Point{Time: at, Value: valueSum})
+				continue
+			}
+			if !at.Equal(window) {
+				add()
+				window, sum, count = at, 0, 0
+			}
+			sum += valueSum
+			count += valueCount
+		}
+		add()
+		return nil
+	})
+	return points, err
+}
+
+// DefaultStep is the step to use when none is asked for, every value for up to a
+// day and otherwise whole minutes that give about a thousand points
+func DefaultStep(from time.Time, to time.Time) time.Duration {
+	if to.Sub(from) <= 24*time.Hour {
+		return 0
+	}
+	step := (to.Sub(from) / 1000).Truncate(time.Minute)
+	return max(step, time.Minute)
+}
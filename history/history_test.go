@@ -0,0 +1,163 @@
+package phocus_history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+// open opens a history in a temporary directory which is closed after the test
+func open(t *testing.T) {
+	assert.NoError(t, Open(filepath.Join(t.TempDir(), "history.db")))
+	t.Cleanup(func() { assert.NoError(t, Close()) })
+}
+
+func TestSource(t *testing.T) {
+	source, found := Source(&messages.QPGSnResponse{InverterNumber: 2})
+	assert.True(t, found)
+	assert.Equal(t, "qpgs2", source)
+	source, found = Source(&messages.QPIGSResponse{})
+	assert.True(t, found)
+	assert.Equal(t, "qpigs", source)
+	_, found = Source(&messages.QIDResponse{})
+	assert.False(t, found)
+	_, found = Source((*messages.QPIRIResponse)(nil))
+	assert.False(t, found)
+	_, found = Source((*messages.QPGSnResponse)(nil))
+	assert.False(t, found)
+}
+
+func TestFields(t *testing.T) {
+	fields := Fields(&messages.QPGSnResponse{
+		Version:                  messages.QPGSnVersion,
+		InverterNumber:           1,
+		SerialNumber:             "92932004102443",
+		BatteryVoltage:           51.1,
+		TotalACOutputActivePower: 792,
+		Checksum:                 "0xf22d",
+	})
+	assert.Equal(t, 51.1, fields["BatteryVoltage"])
+	assert.Equal(t, 792.0, fields["TotalACOutputActivePower"])
+	assert.NotContains(t, fields, "Version", "fields that aren't sensors are skipped")
+	assert.NotContains(t, fields, "InverterNumber")
+	assert.Contains(t, fields, "SerialNumber", "it is a number even though it isn't measured")
+
+	// the measurements of QPIGS are strings and the device status is nested
+	fields = Fields(&messages.QPIGSResponse{
+		BatteryVoltage:  "52.40",
+		PVChargingPower: "00350",
+		EEPROMVersion:   "",
+		DeviceStatus:    messages.DeviceStatus{LoadOn: messages.Statuses["1"]},
+	})
+	assert.Equal(t, map[string]float64{"BatteryVoltage": 52.4, "PVChargingPower": 350, "DeviceStatus.LoadOn": 1}, fields)
+
+	// bools are 1 or 0
+	fields = Fields(&messages.QPIWSResponse{InverterFault: true})
+	assert.Equal(t, 1.0, fields["InverterFault"])
+	assert.Equal(t, 0.0, fields["BusOver"])
+
+	assert.Empty(t, Fields(nil))
+	assert.Empty(t, Fields((*messages.QPIGSResponse)(nil)))
+}
+
+func TestRecordAndQuery(t *testing.T) {
+	open(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, voltage := range []float64{50, 51, 52, 53, 54, 55} {
+		assert.NoError(t, Record("qpgs1", &messages.QPGSnResponse{BatteryVoltage: messages.Volts(voltage)}, start.Add(time.Duration(i)*20*time.Second)))
+	}
+
+	// every value without a step
+	points, err := Query("qpgs1", "BatteryVoltage", start, start.Add(time.Hour), 0)
+	assert.NoError(t, err)
+	assert.Len(t, points, 6)
+	assert.True(t, start.Add(20*time.Second).Equal(points[1].Time))
+	assert.Equal(t, 51.0, points[1].Value)
+
+	// from and to are inclusive
+	points, err = Query("qpgs1", "BatteryVoltage", start.Add(20*time.Second), start.Add(40*time.Second), 0)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+
+	// short steps average every value
+	points, err = Query("qpgs1", "BatteryVoltage", start, start.Add(time.Hour), 40*time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, points, 3)
+	assert.Equal(t, 50.5, points[0].Value)
+	assert.Equal(t, 54.5, points[2].Value)
+
+	// longer steps average the minutes
+	points, err = Query("qpgs1", "BatteryVoltage", start, start.Add(time.Hour), time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+	assert.Equal(t, 51.0, points[0].Value)
+	assert.Equal(t, 54.0, points[1].Value)
+	assert.True(t, start.Add(time.Minute).Equal(points[1].Time))
+	points, err = Query("qpgs1", "BatteryVoltage", start, start.Add(time.Hour), time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, points, 1)
+	assert.Equal(t, 52.5, points[0].Value)
+
+	// missing series are empty
+	points, err = Query("qpgs2", "BatteryVoltage", start, start.Add(time.Hour), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Point{}, points)
+}
+
+func TestPrune(t *testing.T) {
+	open(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, Record("qpigs", &messages.QPIGSResponse{BatteryVoltage: "52.40"}, start))
+
+	// a week later the values are only kept as minutes
+	assert.NoError(t, Record("qpigs", &messages.QPIGSResponse{BatteryVoltage: "53.00"}, start.Add(RawRetention+time.Hour)))
+	points, err := Query("qpigs", "BatteryVoltage", start, start.Add(MinuteRetention), 0)
+	assert.NoError(t, err)
+	assert.Len(t, points, 1)
+	assert.Equal(t, 53.0, points[0].Value)
+	points, err = Query("qpigs", "BatteryVoltage", start, start.Add(MinuteRetention), time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+
+	// and a year later not at all
+	assert.NoError(t, Record("qpigs", &messages.QPIGSResponse{BatteryVoltage: "54.00"}, start.Add(MinuteRetention+time.Hour)))
+	points, err = Query("qpigs", "BatteryVoltage", start, start.Add(2*MinuteRetention), time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+	assert.Equal(t, 53.0, points[0].Value)
+}
+
+func TestPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.db")
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, Open(file))
+	assert.NoError(t, Record("qpiri", &messages.QPIRIResponse{}, start))
+	assert.NoError(t, Record("energy1", &struct{ PVEnergy float64 }{1.5}, start))
+	assert.NoError(t, Close())
+	assert.NoError(t, Close(), "closing twice is fine")
+
+	// the history isn't kept while it is closed
+	assert.ErrorIs(t, Record("energy1", &struct{ PVEnergy float64 }{2}, start), ErrClosed)
+	_, err := Query("energy1", "PVEnergy", start, start, 0)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	assert.NoError(t, Open(file))
+	defer Close()
+	points, err := Query("energy1", "PVEnergy", start, start, 0)
+	assert.NoError(t, err)
+	assert.Len(t, points, 1)
+	assert.Equal(t, 1.5, points[0].Value)
+
+	assert.Error(t, Open(filepath.Join(t.TempDir(), "missing", "history.db")))
+}
+
+func TestDefaultStep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Duration(0), DefaultStep(start, start.Add(24*time.Hour)))
+	assert.Equal(t, time.Minute, DefaultStep(start, start.Add(25*time.Hour)))
+	assert.Equal(t, 10*time.Minute, DefaultStep(start, start.Add(7*24*time.Hour)))
+	assert.Equal(t, 525*time.Minute, DefaultStep(start, start.Add(MinuteRetention)))
+}
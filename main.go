@@ -2,43 +2,83 @@
 package main
 
 import (
-	"errors"  // creating custom errors
-	"fmt"     // string formatting
-	"log"     // formatted logging
-	"os"      // exiting
-	"os/exec" // auto restart
-	"time"    // for sleeping
+	"errors"    // checking errors
+	"flag"      // subcommand arguments
+	"fmt"       // naming the history of the counters
+	"log"       // formatted logging
+	"os"        // exiting
+	"os/signal" // stopping on interrupt
+	"sync"      // registering one at a time
+	"syscall"   // stopping on terminate
+	"time"      // for sleeping
 
 	"encoding/json" // for config reading
 
 	"github.com/gin-gonic/gin"
-	api "github.com/wolffshots/phocus/v2/api"           // api setup
-	messages "github.com/wolffshots/phocus/v2/messages" // message structures
-	mqtt "github.com/wolffshots/phocus/v2/mqtt"         // comms with mqtt broker
-	sensors "github.com/wolffshots/phocus/v2/sensors"   // registering common sensors
-	serial "github.com/wolffshots/phocus/v2/serial"     // comms with inverter
+	"github.com/google/uuid"
+	api "github.com/wolffshots/phocus/v2/api"             // api setup
+	energy "github.com/wolffshots/phocus/v2/energy"       // kWh counters
+	history "github.com/wolffshots/phocus/v2/history"     // on-disk history of the responses
+	messages "github.com/wolffshots/phocus/v2/messages"   // message structures
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"           // comms with mqtt broker
+	sensors "github.com/wolffshots/phocus/v2/sensors"     // registering common sensors
+	serial "github.com/wolffshots/phocus/v2/serial"       // comms with inverter
+	simulator "github.com/wolffshots/phocus/v2/simulator" // inverter simulator
 )
 
 var version = "development"
 
 type Configuration struct {
 	Serial struct {
-		Port    string
-		Baud    int
-		Retries int
+		Port     string
+		Baud     int
+		Retries  int
+		Record   string // file to record every frame written and read to, empty to disable
+		Recovery struct {
+			InitialBackoffSeconds int
+			MaxBackoffSeconds     int
+		}
 	}
 	MQTT struct {
-		Host   string
+		Host   string // can start with ssl://, ws:// or wss:// instead of the default tcp://
 		Port   int
 		Client struct {
 			Name string
 		}
 		Retries int
+		mqtt.Security
+		mqtt.Namespace
 	}
 	Messages struct {
 		Read struct {
 			TimeoutSeconds int
 		}
+		QPIGS struct {
+			IntervalSeconds int // how often to poll QPIGS for single units, 0 to disable
+		}
+		QPIWS struct {
+			IntervalSeconds int // how often to poll the warnings with QPIWS, 0 to disable
+		}
+		QPIRI struct {
+			IntervalSeconds int // how often to poll the settings with QPIRI, defaults to an hour and negative disables
+		}
+		Energy struct {
+			IntervalSeconds int // how often to poll the PV energy counters with QET, QEY, QEM and QED, 0 to disable
+		}
+		LegacyStringJSON bool // publish the QPGSn measurements as the padded strings of version 1 instead of numbers
+	}
+	Inverters struct {
+		Count             int   // polls QPGS1 to QPGSn when Indices is empty
+		Indices           []int // explicit list of parallel indices to poll
+		Discover          bool  // probe QPGS0, QPGS1, ... to find the units instead
+		MaxUnits          int   // highest number of units to probe for when discovering
+		RediscoverMinutes int   // how often to probe for added or removed units
+	}
+	Energy struct {
+		File string // where the kWh counters are kept across restarts, defaults to energy.json
+	}
+	History struct {
+		File string // where the history of the responses is kept, defaults to history.db
 	}
 	DelaySeconds     int
 	RandDelaySeconds int
@@ -55,6 +95,136 @@ func ParseConfig(fileName string) (Configuration, error) {
 	return configuration, err
 }
 
+// QPIRIIntervalSeconds returns how often the settings should be polled with QPIRI
+//
+// Defaults to an hour since the settings rarely change and 0 means that it is disabled
+func (configuration Configuration) QPIRIIntervalSeconds() int {
+	if configuration.Messages.QPIRI.IntervalSeconds == 0 {
+		return 3600
+	} else if configuration.Messages.QPIRI.IntervalSeconds < 0 {
+		return 0
+	}
+	return configuration.Messages.QPIRI.IntervalSeconds
+}
+
+// EnergyFile returns where the kWh counters are kept across restarts
+func (configuration Configuration) EnergyFile() string {
+	if configuration.Energy.File == "" {
+		return "energy.json"
+	}
+	return configuration.Energy.File
+}
+
+// HistoryFile returns where the history of the responses is kept
+func (configuration Configuration) HistoryFile() string {
+	if configuration.History.File == "" {
+		return "history.db"
+	}
+	return configuration.History.File
+}
+
+// InverterIndices returns the parallel indices that should be polled with QPGSn
+//
+// An explicit list of Indices takes precedence over Count and if neither
+// is set it falls back to the original behaviour of polling QPGS1 and QPGS2
+func (configuration Configuration) InverterIndices() []int {
+	if len(configuration.Inverters.Indices) > 0 {
+		return configuration.Inverters.Indices
+	}
+	count := configuration.Inverters.Count
+	if count <= 0 {
+		count = 2
+	}
+	indices := make([]int, count)
+	for i := range indices {
+		indices[i] = i + 1
+	}
+	return indices
+}
+
+// Discover probes for the parallel units and updates the polled inverters
+// and their sensors if the set of units has changed
+func Discover(client mqtt.Client, port serial.Port, configuration Configuration) error {
+	maxUnits := configuration.Inverters.MaxUnits
+	if maxUnits <= 0 {
+		maxUnits = 9
+	}
+	responses, err := messages.Discover(port, time.Duration(configuration.Messages.Read.TimeoutSeconds)*time.Second, maxUnits)
+	if err != nil {
+		return err
+	}
+	for _, response := range responses {
+		sensors.UpdateDevices(response)
+	}
+	current := messages.InverterNumbers(responses)
+	added, removed := messages.DiffInverters(api.GetInverters(), current)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	log.Printf("Discovered inverters %v, added: %v, removed: %v\n", current, added, removed)
+	api.SetInverters(current)
+	err = sensors.Unregister(client, removed)
+	if err != nil {
+		return err
+	}
+	return sensors.Register(client, version, current)
+}
+
+// registering stops registrations from Home Assistant restarting and from
+// newly identified inverters from interleaving
+var registering sync.Mutex
+
+// RegisterAll sends the configs of all of the sensors and controls to Home Assistant
+//
+// Only failing to register the main sensors is returned since the rest are optional
+func RegisterAll(client mqtt.Client, configuration Configuration) error {
+	registering.Lock()
+	defer registering.Unlock()
+	err := sensors.Register(client, version, api.GetInverters())
+	if err != nil {
+		return err
+	}
+	err = sensors.RegisterControls(client)
+	if err != nil {
+		log.Printf("Failed to set up controls with err: %v", err)
+	}
+	if configuration.Messages.QPIGS.IntervalSeconds > 0 {
+		err = sensors.RegisterQPIGS(client)
+		if err != nil {
+			log.Printf("Failed to set up QPIGS sensors with err: %v", err)
+		}
+	}
+	if configuration.Messages.QPIWS.IntervalSeconds > 0 {
+		err = sensors.RegisterQPIWS(client)
+		if err != nil {
+			log.Printf("Failed to set up QPIWS sensors with err: %v", err)
+		}
+	}
+	if configuration.QPIRIIntervalSeconds() > 0 {
+		err = sensors.RegisterQPIRI(client)
+		if err != nil {
+			log.Printf("Failed to set up QPIRI sensors with err: %v", err)
+		}
+	}
+	if configuration.Messages.Energy.IntervalSeconds > 0 {
+		err = sensors.RegisterPVEnergy(client)
+		if err != nil {
+			log.Printf("Failed to set up PV energy sensors with err: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReportSerialStatus publishes a change in the state of the serial connection to mqtt and the api
+func ReportSerialStatus(client mqtt.Client, status serial.Status) {
+	api.SetSerialStatus(status)
+	jsonStatus, _ := json.Marshal(status) // err ignored because it can't fail with this input
+	pubErr := mqtt.Send(client, mqtt.Topic("stats/serial"), 0, true, string(jsonStatus), 10)
+	if pubErr != nil {
+		log.Printf("Failed to post serial status to mqtt: %v\n", pubErr)
+	}
+}
+
 func Router(client mqtt.Client, profiling bool) error {
 	err := api.SetupRouter(gin.ReleaseMode, profiling).Run("0.0.0.0:8080")
 	if err != nil {
@@ -68,11 +238,71 @@ func Router(client mqtt.Client, profiling bool) error {
 	return err
 }
 
+// Simulate runs the inverter simulator on a pty until it is interrupted
+//
+// The slave side of the pty is linked from -link so that it can be
+// used as the Serial.Port in the config of another phocus instance
+func Simulate(arguments []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	link := flags.String("link", "./phocus-simulator", "path to link the simulated serial port to")
+	scenarioFile := flags.String("scenario", "", "JSON file with the scenario to simulate")
+	err := flags.Parse(arguments)
+	if err != nil {
+		return err
+	}
+
+	scenario := simulator.DefaultScenario
+	if *scenarioFile != "" {
+		scenario, err = simulator.LoadScenario(*scenarioFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	pty, err := simulator.OpenPty()
+	if err != nil {
+		return err
+	}
+	defer pty.Close()
+	os.Remove(*link)
+	err = os.Symlink(pty.Path, *link)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(*link)
+	log.Printf("Simulating %d inverters on %s linked from %s\n", len(scenario.Units), pty.Path, *link)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- simulator.New(scenario).Serve(pty.Master)
+	}()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-interrupt:
+		log.Println("Stopping simulator")
+		return nil
+	case err := <-served:
+		return err
+	}
+}
+
 // main is the entrypoint to the app
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Llongfile)
+
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		err := Simulate(os.Args[2:])
+		if err != nil {
+			log.Printf("Failed to run simulator with err: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Println("Starting up phocus")
 	log.Printf("Phocus Version: %s\n\n", version)
+	sensors.SetVersion(version)
 
 	configuration, err := ParseConfig("config.json")
 
@@ -85,13 +315,48 @@ func main() {
 		log.Printf("Error parsing config: %v", err)
 		os.Exit(1)
 	}
+	messages.SetLegacyJSON(configuration.Messages.LegacyStringJSON)
+	err = energy.Load(configuration.EnergyFile())
+	if err != nil {
+		log.Printf("Failed to load the energy counters from %s, starting from zero: %v", configuration.EnergyFile(), err)
+	}
+	err = history.Open(configuration.HistoryFile())
+	if err != nil {
+		log.Printf("Failed to open the history in %s, it won't be kept: %v", configuration.HistoryFile(), err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		// the counters are only saved every minute so the last of them would be lost otherwise
+		err := energy.Save()
+		if err != nil {
+			log.Printf("Failed to save the energy counters: %v", err)
+		}
+		err = history.Close()
+		if err != nil {
+			log.Printf("Failed to close the history: %v", err)
+		}
+		log.Println("Stopping phocus")
+		os.Exit(0)
+	}()
 
 	// mqtt
+	err = mqtt.SetNamespace(configuration.MQTT.Namespace)
+	if err != nil {
+		log.Printf("Invalid MQTT namespace: %v", err)
+		os.Exit(1)
+	}
 	client, err := mqtt.Setup(
 		configuration.MQTT.Host,
 		configuration.MQTT.Port,
 		configuration.MQTT.Retries,
 		configuration.MQTT.Client.Name,
+		configuration.MQTT.Security,
+		append(
+			sensors.Subscriptions(api.Enqueue),
+			sensors.Rediscovery(func(client mqtt.Client) error { return RegisterAll(client, configuration) }),
+		)...,
 	)
 
 	if err != nil {
@@ -99,39 +364,82 @@ func main() {
 		os.Exit(1)
 	}
 	// reset error
-	pubErr := mqtt.Send(client, "phocus/stats/error", 0, true, "", 10)
+	pubErr := mqtt.Send(client, mqtt.Topic("stats/error"), 0, true, "", 10)
 	if pubErr != nil {
 		log.Printf("Failed to clear previous error: %v\n", pubErr)
 	}
 
 	// send new version
-	pubErr = mqtt.Send(client, "phocus/stats/version", 0, true, version, 10)
+	pubErr = mqtt.Send(client, mqtt.Topic("stats/version"), 0, true, version, 10)
 	if pubErr != nil {
 		log.Printf("Failed to set phocus version: %v\n", pubErr)
 	}
 
+	// spawns a go-routine which handles web requests
+	// it is started before serial so that it is available during recovery
+	go Router(client, configuration.Profiling)
+
 	// serial
+	initialBackoffSeconds := configuration.Serial.Recovery.InitialBackoffSeconds
+	if initialBackoffSeconds <= 0 {
+		initialBackoffSeconds = 5
+	}
+	maxBackoffSeconds := configuration.Serial.Recovery.MaxBackoffSeconds
+	if maxBackoffSeconds < initialBackoffSeconds {
+		maxBackoffSeconds = 300
+	}
+	recovery := serial.NewRecovery(
+		time.Duration(initialBackoffSeconds)*time.Second,
+		time.Duration(maxBackoffSeconds)*time.Second,
+		configuration.Serial.Retries,
+		func(status serial.Status) { ReportSerialStatus(client, status) },
+	)
 	port, err := serial.Setup(
 		configuration.Serial.Port,
 		configuration.Serial.Baud,
 		configuration.Serial.Retries,
 	)
+	if configuration.Serial.Record != "" {
+		recorder, recordErr := serial.OpenRecorder(configuration.Serial.Record)
+		if recordErr != nil {
+			log.Printf("Failed to open serial recording with err: %v", recordErr)
+		} else {
+			defer recorder.Close()
+			port = port.Recorded(recorder)
+			log.Printf("Recording serial traffic to %s\n", configuration.Serial.Record)
+		}
+	}
 	if err != nil {
 		pubErr := mqtt.Error(client, 0, true, err, 10)
 		if pubErr != nil {
 			log.Printf("Failed to post previous error (%v) to mqtt: %v\n", err, pubErr)
 		}
 		log.Printf("Failed to set up serial with err: %v", err)
-		os.Exit(1)
+		err = recovery.Recover(&port)
+		if err != nil {
+			log.Printf("Failed to recover serial with err: %v", err)
+			os.Exit(1)
+		}
 	}
-	defer port.Port.Close()
+	ReportSerialStatus(client, recovery.Status())
+	defer func() {
+		if port.Port != nil {
+			port.Port.Close()
+		}
+	}()
 
-	// spawns a go-routine which handles web requests
-	go Router(client, configuration.Profiling)
+	api.SetInverters(configuration.InverterIndices())
+	if configuration.Inverters.Discover {
+		err = Discover(client, port, configuration)
+		if err != nil {
+			log.Printf("Failed to discover inverters, falling back to configured ones: %v\n", err)
+		}
+	}
+	log.Printf("Polling inverters: %v\n", api.GetInverters())
 
 	// sensors
 	// we only add them once we know the mqtt, serial and http aspects are up
-	err = sensors.Register(client, version)
+	err = RegisterAll(client, configuration)
 	if err != nil {
 		pubErr := mqtt.Error(client, 0, true, err, 10)
 		if pubErr != nil {
@@ -146,44 +454,121 @@ func main() {
 
 	// spawn go-routine to repeatedly enQueue QPGSn commands
 	go api.QueueQPGSn(configuration.DelaySeconds, configuration.RandDelaySeconds)
+	if configuration.Messages.QPIGS.IntervalSeconds > 0 {
+		go api.QueuePeriodic("QPIGS", time.Duration(configuration.Messages.QPIGS.IntervalSeconds)*time.Second)
+	}
+	if configuration.Messages.QPIWS.IntervalSeconds > 0 {
+		go api.QueuePeriodic("QPIWS", time.Duration(configuration.Messages.QPIWS.IntervalSeconds)*time.Second)
+	}
+	if qpiriIntervalSeconds := configuration.QPIRIIntervalSeconds(); qpiriIntervalSeconds > 0 {
+		go api.QueuePeriodic("QPIRI", time.Duration(qpiriIntervalSeconds)*time.Second)
+	}
+	if configuration.Messages.Energy.IntervalSeconds > 0 {
+		// the dated counters are queried for the current period when they are sent
+		for _, command := range []string{"QET", "QEY", "QEM", "QED"} {
+			go api.QueuePeriodic(command, time.Duration(configuration.Messages.Energy.IntervalSeconds)*time.Second)
+		}
+	}
+
+	lastDiscovery := time.Now()
 
 	// loop to check Queue and deQueue index 0, run it process result and wait 30 seconds
 	for {
 		api.QueueMutex.Lock()
+		// periodically check whether units have been added or removed
+		if configuration.Inverters.Discover && configuration.Inverters.RediscoverMinutes > 0 &&
+			time.Since(lastDiscovery) > time.Duration(configuration.Inverters.RediscoverMinutes)*time.Minute {
+			err := Discover(client, port, configuration)
+			if err != nil {
+				api.PublishError(err)
+				pubErr := mqtt.Error(client, 0, true, err, 10)
+				if pubErr != nil {
+					log.Printf("Failed to post previous error (%v) to mqtt: %v\n", err, pubErr)
+				}
+			}
+			lastDiscovery = time.Now()
+		}
+		needsRecovery := false
 		// if there is an entry at [0] then run that command
 		if len(api.Queue) > 0 {
-			QPGSnResponse, err := messages.Interpret(client, port, api.Queue[0], time.Duration(configuration.Messages.Read.TimeoutSeconds)*time.Second)
+			response, err := messages.Interpret(client, port, api.Queue[0], time.Duration(configuration.Messages.Read.TimeoutSeconds)*time.Second)
+			api.PublishResult(api.Queue[0], response, err)
 			if err != nil {
 				pubErr := mqtt.Error(client, 0, true, err, 10)
 				if pubErr != nil {
 					log.Printf("Failed to post previous error (%v) to mqtt: %v\n", err, pubErr)
 				}
-				if fmt.Sprint(err) == "read returned nothing" { // immediately jailed when read timeout
-					port.Port.Close()
-					pubErr := mqtt.Error(client, 0, true, errors.New("read timed out, waiting 2 minutes then restarting"), 10)
+				needsRecovery = serial.NeedsRecovery(err)
+			}
+			if sensors.UpdateDevices(response) {
+				// the devices of the sensors changed so their configs need to be sent again
+				go func() {
+					err := RegisterAll(client, configuration)
+					if err != nil {
+						log.Printf("Failed to register the updated devices with err: %v", err)
+					}
+				}()
+			}
+			if source, found := history.Source(response); found {
+				err := history.Record(source, response, time.Now())
+				if err != nil && !errors.Is(err, history.ErrClosed) {
+					log.Printf("Failed to record the history of %s: %v\n", source, err)
+				}
+			}
+			switch response := response.(type) {
+			case *messages.QIDResponse:
+				// the firmware is only needed once the inverter is known (the queue is already locked here)
+				api.Push(messages.Message{ID: uuid.New(), Command: "QVFW", Payload: ""})
+			case *messages.QPGSnResponse:
+				api.SetLast(response)
+				counters, err := energy.Add(response, time.Now())
+				if err != nil {
+					log.Printf("Failed to save the energy counters: %v\n", err)
+				}
+				if counters != nil {
+					api.SetLastResponse(response.InverterNumber, "energy", counters)
+					pubErr := energy.Publish(client, counters, response.InverterNumber)
 					if pubErr != nil {
-						log.Printf("Failed to post previous error (%v) to mqtt: %v\n", err, pubErr)
+						api.PublishError(fmt.Errorf("failed to publish the energy of inverter %d: %w", response.InverterNumber, pubErr))
 					}
-					time.Sleep(2 * time.Minute)
-					cmd, err := exec.Command("bash", "-c", "sudo service phocus restart").Output()
-					// it should die here
-					log.Printf("cmd=================>%s\n", cmd)
-					if err != nil {
-						log.Printf("Error execing cmd: %v", err)
+					err = history.Record(fmt.Sprintf("energy%d", response.InverterNumber), counters, time.Now())
+					if err != nil && !errors.Is(err, history.ErrClosed) {
+						log.Printf("Failed to record the history of energy%d: %v\n", response.InverterNumber, err)
 					}
-					// if it reaches here at all that implies it didn't restart properly
-					os.Exit(1)
+				}
+			case *messages.QPIGSResponse:
+				api.SetLocalResponse("QPIGS", response)
+			case *messages.QPIWSResponse:
+				api.SetLocalResponse("QPIWS", response)
+			case *messages.QPIRIResponse:
+				api.SetSettings(response)
+				pubErr := sensors.PublishControlStates(client, response)
+				if pubErr != nil {
+					log.Printf("Failed to publish control states: %v\n", pubErr)
 				}
 			}
-			if QPGSnResponse != nil {
-				api.SetLast(QPGSnResponse)
+			api.Pop()
+			if response, ok := response.(*messages.SetterResponse); ok && response.Result == "ACK" {
+				pubErr := sensors.EchoState(client, response)
+				if pubErr != nil {
+					log.Printf("Failed to echo state of %s: %v\n", response.Command, pubErr)
+				}
+				// refresh the settings after a setter was accepted (the queue is already locked here)
+				api.Push(messages.Message{ID: uuid.New(), Command: "QPIRI", Payload: ""})
 			}
-			api.Queue = api.Queue[1:]
 		} else {
 			// min sleep between actual comms with inverter
 			time.Sleep(time.Duration(configuration.MinDelaySeconds) * time.Second)
 		}
 		api.QueueMutex.Unlock()
+		if needsRecovery {
+			// the queue is unlocked so the api and queueing keep running while the port is reopened
+			err := recovery.Recover(&port)
+			if err != nil {
+				log.Printf("Failed to recover serial with err: %v", err)
+				os.Exit(1)
+			}
+		}
 		// min sleep between Queue checks
 		time.Sleep(1 * time.Second)
 	}
@@ -17,12 +17,50 @@ func TestParseConfig(t *testing.T) {
 	assert.Equal(t, 2400, configuration.Serial.Baud)
 	assert.Equal(t, "/dev/ttyUSB0", configuration.Serial.Port)
 	assert.Equal(t, 5, configuration.Serial.Retries)
+	assert.Equal(t, 5, configuration.Serial.Recovery.InitialBackoffSeconds)
+	assert.Equal(t, 300, configuration.Serial.Recovery.MaxBackoffSeconds)
+	assert.Equal(t, "", configuration.Serial.Record)
 	assert.Equal(t, 5, configuration.MQTT.Retries)
+	assert.Equal(t, "", configuration.MQTT.Username)
+	assert.Equal(t, "phocus", configuration.MQTT.BaseTopic)
+	assert.Equal(t, "homeassistant", configuration.MQTT.DiscoveryPrefix)
+	assert.Equal(t, "", configuration.MQTT.InstanceID)
+	assert.Equal(t, false, configuration.MQTT.TLS.InsecureSkipVerify)
 	assert.Equal(t, 2, configuration.Messages.Read.TimeoutSeconds)
 	assert.Equal(t, 2*time.Second, time.Duration(configuration.Messages.Read.TimeoutSeconds)*time.Second)
+	assert.Equal(t, 0, configuration.Messages.QPIGS.IntervalSeconds)
+	assert.Equal(t, 60, configuration.Messages.QPIWS.IntervalSeconds)
+	assert.Equal(t, 3600, configuration.QPIRIIntervalSeconds())
 	assert.Equal(t, 15, configuration.DelaySeconds)
 	assert.Equal(t, 5, configuration.RandDelaySeconds)
 	assert.Equal(t, 5, configuration.MinDelaySeconds)
+	assert.Equal(t, 2, configuration.Inverters.Count)
+	assert.Equal(t, []int{1, 2}, configuration.InverterIndices())
+	assert.Equal(t, false, configuration.Inverters.Discover)
+	assert.Equal(t, 9, configuration.Inverters.MaxUnits)
+	assert.Equal(t, 60, configuration.Inverters.RediscoverMinutes)
+}
+
+func TestInverterIndices(t *testing.T) {
+	configuration := Configuration{}
+	assert.Equal(t, []int{1, 2}, configuration.InverterIndices())
+
+	configuration.Inverters.Count = 4
+	assert.Equal(t, []int{1, 2, 3, 4}, configuration.InverterIndices())
+
+	configuration.Inverters.Indices = []int{0, 2, 5}
+	assert.Equal(t, []int{0, 2, 5}, configuration.InverterIndices())
+}
+
+func TestQPIRIIntervalSeconds(t *testing.T) {
+	configuration := Configuration{}
+	assert.Equal(t, 3600, configuration.QPIRIIntervalSeconds())
+
+	configuration.Messages.QPIRI.IntervalSeconds = 600
+	assert.Equal(t, 600, configuration.QPIRIIntervalSeconds())
+
+	configuration.Messages.QPIRI.IntervalSeconds = -1
+	assert.Equal(t, 0, configuration.QPIRIIntervalSeconds())
 }
 
 func TestRouter(t *testing.T) {
@@ -49,3 +87,9 @@ func TestRouter(t *testing.T) {
 	// Perform additional test logic or assertions related to the running server here
 	close(startCh)
 }
+
+func TestRegisterAll(t *testing.T) {
+	var client mqtt.Client
+	err := RegisterAll(client, Configuration{})
+	assert.EqualError(t, err, "client not defined in send")
+}
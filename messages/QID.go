@@ -14,7 +14,7 @@ import (
 )
 
 type QIDResponse struct {
-	SerialNumber string
+	SerialNumber string `ha:"serial,name=Serial,icon=mdi:update,category=diagnostic"`
 }
 
 func SendQID(port phocus_serial.Port, payload interface{}) (int, error) {
@@ -76,7 +76,7 @@ func EncodeQID(response *QIDResponse) string {
 
 func PublishQID(client phocus_mqtt.Client, response *QIDResponse) error {
 	jsonResponse := EncodeQID(response)
-	err := phocus_mqtt.Send(client, "phocus/stats/qid", 0, true, jsonResponse, 10)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic("stats/qid"), 0, true, jsonResponse, 10)
 	if err != nil {
 		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", "QID", err, jsonResponse)
 	} else {
@@ -5,6 +5,7 @@ import (
 	"errors"        // creating custom err messages
 	"fmt"           // string formatting
 	"log"           // logging to std out
+	"strconv"       // parsing inverter numbers
 	"strings"       // string manipulation
 	"time"          // sleeping
 
@@ -24,6 +25,9 @@ var OperationModes = map[string]OperationMode{
 	"D": "Shutdown",
 }
 
+// Options are the values that a OperationMode can have
+func (OperationMode) Options() []string { return enumerate(OperationModes) }
+
 type FaultCode string
 
 var FaultCodes = map[string]FaultCode{
@@ -74,6 +78,9 @@ var BatteryStatuses = map[string]BatteryStatus{
 	"00": "Battery voltage normal",
 }
 
+// Options are the values that a BatteryStatus can have
+func (BatteryStatus) Options() []string { return enumerate(BatteryStatuses) }
+
 type GridAvailability string
 
 var GridAvailabilities = map[string]GridAvailability{
@@ -81,16 +88,19 @@ var GridAvailabilities = map[string]GridAvailability{
 	"0": "connected",
 }
 
+// Options are the values that a GridAvailability can have
+func (GridAvailability) Options() []string { return enumerate(GridAvailabilities) }
+
 type Reserved string
 
 type InverterStatus struct {
-	MPPT          Status
-	ACCharging    Status
-	SolarCharging Status
-	BatteryStatus BatteryStatus // 2 bits
-	ACInput       GridAvailability
-	ACOutput      Status
-	Reserved      Reserved
+	MPPT          Status           `ha:",icon=mdi:solar-power"`
+	ACCharging    Status           `ha:",icon=mdi:transmission-tower"`
+	SolarCharging Status           `ha:",icon=mdi:solar-power"`
+	BatteryStatus BatteryStatus    `ha:",icon=mdi:battery"` // 2 bits
+	ACInput       GridAvailability `ha:"ac_input_mode,name=AC Input Mode,icon=mdi:meter-electric"`
+	ACOutput      Status           `ha:",icon=mdi:power-plug"`
+	Reserved      Reserved         `ha:"-"`
 }
 
 type ACOutputMode string
@@ -103,6 +113,9 @@ var ACOutputModes = map[string]ACOutputMode{
 	"4": "Phase 3 of 3-phase output",
 }
 
+// Options are the values that a ACOutputMode can have
+func (ACOutputMode) Options() []string { return enumerate(ACOutputModes) }
+
 type BatteryChargerSourcePriority string
 
 var BatteryChargerSourcePriorities = map[string]BatteryChargerSourcePriority{
@@ -111,8 +124,52 @@ var BatteryChargerSourcePriorities = map[string]BatteryChargerSourcePriority{
 	"3": "Solar only",
 }
 
+// Options are the values that a BatteryChargerSourcePriority can have
+func (BatteryChargerSourcePriority) Options() []string {
+	return enumerate(BatteryChargerSourcePriorities)
+}
+
+// QPGSnVersion is the version of the shape of QPGSnResponse, which is 2 since
+// the measurements became numbers with units instead of the strings from the inverter
+const QPGSnVersion = 2
+
 type QPGSnResponse struct {
 	// (A BBBBBBBBBBBBBB C DD EEE.E FF.FF GGG.G HH.HH IIII JJJJ KKK LL.L MMM NNN OOO.O PPP QQQQQ RRRRR SSS b7b6b5b4b3b2b1b0 T U VVV WWW XX YY.Y ZZZ<CRC><cr>
+	Version                             int           `ha:"-"` // QPGSnVersion
+	InverterNumber                      int           `ha:"-"`
+	OtherUnits                          bool          `ha:"-"`
+	SerialNumber                        string        `ha:"serial,name=Serial,icon=mdi:update,category=diagnostic"`
+	OperationMode                       OperationMode `ha:",icon=mdi:meter-electric"`
+	FaultCode                           FaultCode     `ha:",icon=mdi:alert"`
+	ACInputVoltage                      Volts         `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACInputFrequency                    Hertz         `ha:",unit=Hz,precision=2,device_class=frequency,state_class=measurement,icon=mdi:sine-wave"`
+	ACOutputVoltage                     Volts         `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACOutputFrequency                   Hertz         `ha:",unit=Hz,precision=2,device_class=frequency,state_class=measurement,icon=mdi:sine-wave"`
+	ACOutputApparentPower               VoltAmps      `ha:",unit=VA,device_class=apparent_power,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACOutputActivePower                 Watts         `ha:",unit=W,device_class=power,state_class=measurement,icon=mdi:lightning-bolt"`
+	PercentageOfNominalOutputPower      Percent       `ha:"output_load,name=Output Load,unit=%,state_class=measurement,icon=mdi:gauge"`
+	BatteryVoltage                      Volts         `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:battery"`
+	BatteryChargingCurrent              Amps          `ha:"battery_charge_current,name=Battery Charge Current,unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	BatteryStateOfCharge                Percent       `ha:",name=Battery SoC,unit=%,device_class=battery,state_class=measurement,icon=mdi:battery"`
+	PVInputVoltage                      Volts         `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	TotalChargingCurrent                Amps          `ha:",unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	TotalACOutputApparentPower          VoltAmps      `ha:",unit=VA,device_class=apparent_power,state_class=measurement,icon=mdi:lightning-bolt"`
+	TotalACOutputActivePower            Watts         `ha:",unit=W,device_class=power,state_class=measurement,icon=mdi:lightning-bolt"`
+	TotalPercentageOfNominalOutputPower Percent       `ha:"total_output_load,name=Total Output Load,unit=%,state_class=measurement,icon=mdi:gauge"`
+	InverterStatus                      InverterStatus
+	ACOutputMode                        ACOutputMode                 `ha:",icon=mdi:power-socket"`
+	BatteryChargerSourcePriority        BatteryChargerSourcePriority `ha:"charger_source_priority,name=Charger Source Priority,icon=mdi:battery-charging"`
+	MaxChargingCurrentSet               Amps                         `ha:",unit=A,device_class=current,icon=mdi:current-dc"`
+	MaxChargingCurrentPossible          Amps                         `ha:",unit=A,device_class=current,icon=mdi:current-dc"`
+	MaxACChargingCurrentSet             Amps                         `ha:",unit=A,device_class=current,icon=mdi:current-dc"`
+	PVInputCurrent                      Amps                         `ha:",unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	BatteryDischargeCurrent             Amps                         `ha:",unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	Checksum                            string                       `ha:"-"`
+}
+
+// LegacyQPGSnResponse is version 1 of QPGSnResponse where the measurements are
+// the zero padded strings from the inverter, ie "020.4" and "00942"
+type LegacyQPGSnResponse struct {
 	InverterNumber                      int
 	OtherUnits                          bool
 	SerialNumber                        string
@@ -144,10 +201,86 @@ type QPGSnResponse struct {
 	Checksum                            string
 }
 
+// Legacy converts the response to version 1 by padding the numbers to the widths of the fields in the protocol
+func (response *QPGSnResponse) Legacy() *LegacyQPGSnResponse {
+	if response == nil {
+		return nil
+	}
+	return &LegacyQPGSnResponse{
+		InverterNumber:                      response.InverterNumber,
+		OtherUnits:                          response.OtherUnits,
+		SerialNumber:                        response.SerialNumber,
+		OperationMode:                       response.OperationMode,
+		FaultCode:                           response.FaultCode,
+		ACInputVoltage:                      fmt.Sprintf("%05.1f", response.ACInputVoltage),
+		ACInputFrequency:                    fmt.Sprintf("%05.2f", response.ACInputFrequency),
+		ACOutputVoltage:                     fmt.Sprintf("%05.1f", response.ACOutputVoltage),
+		ACOutputFrequency:                   fmt.Sprintf("%05.2f", response.ACOutputFrequency),
+		ACOutputApparentPower:               fmt.Sprintf("%04d", response.ACOutputApparentPower),
+		ACOutputActivePower:                 fmt.Sprintf("%04d", response.ACOutputActivePower),
+		PercentageOfNominalOutputPower:      fmt.Sprintf("%03d", response.PercentageOfNominalOutputPower),
+		BatteryVoltage:                      fmt.Sprintf("%04.1f", response.BatteryVoltage),
+		BatteryChargingCurrent:              fmt.Sprintf("%03.0f", response.BatteryChargingCurrent),
+		BatteryStateOfCharge:                fmt.Sprintf("%03d", response.BatteryStateOfCharge),
+		PVInputVoltage:                      fmt.Sprintf("%05.1f", response.PVInputVoltage),
+		TotalChargingCurrent:                fmt.Sprintf("%03.0f", response.TotalChargingCurrent),
+		TotalACOutputApparentPower:          fmt.Sprintf("%05d", response.TotalACOutputApparentPower),
+		TotalACOutputActivePower:            fmt.Sprintf("%05d", response.TotalACOutputActivePower),
+		TotalPercentageOfNominalOutputPower: fmt.Sprintf("%03d", response.TotalPercentageOfNominalOutputPower),
+		InverterStatus:                      response.InverterStatus,
+		ACOutputMode:                        response.ACOutputMode,
+		BatteryChargerSourcePriority:        response.BatteryChargerSourcePriority,
+		MaxChargingCurrentSet:               fmt.Sprintf("%03.0f", response.MaxChargingCurrentSet),
+		MaxChargingCurrentPossible:          fmt.Sprintf("%03.0f", response.MaxChargingCurrentPossible),
+		MaxACChargingCurrentSet:             fmt.Sprintf("%02.0f", response.MaxACChargingCurrentSet),
+		PVInputCurrent:                      fmt.Sprintf("%04.1f", response.PVInputCurrent),
+		BatteryDischargeCurrent:             fmt.Sprintf("%03.0f", response.BatteryDischargeCurrent),
+		Checksum:                            response.Checksum,
+	}
+}
+
+// legacyJSON is whether QPGSn responses are encoded as version 1 for consumers that expect strings
+var legacyJSON = false
+
+// SetLegacyJSON sets whether QPGSn responses are encoded as version 1 for consumers that expect strings
+func SetLegacyJSON(enabled bool) {
+	legacyJSON = enabled
+}
+
+// LegacyJSON is whether QPGSn responses are encoded as version 1
+func LegacyJSON() bool {
+	return legacyJSON
+}
+
+// QPGSnCommand returns the QPGSn query for a specific inverter number
+func QPGSnCommand(inverterNum int) string {
+	return fmt.Sprintf("QPGS%d", inverterNum)
+}
+
+// ParseQPGSnCommand extracts the inverter number from a QPGSn query
+//
+// Returns false if the command is not a QPGSn query for an arbitrary n
+func ParseQPGSnCommand(command string) (int, bool) {
+	suffix, found := strings.CutPrefix(command, "QPGS")
+	if !found || suffix == "" {
+		return 0, false
+	}
+	for _, character := range suffix {
+		if character < '0' || character > '9' {
+			return 0, false
+		}
+	}
+	inverterNum, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, false
+	}
+	return inverterNum, true
+}
+
 func SendQPGSn(port phocus_serial.Port, payload interface{}) (int, error) {
 	switch payload.(type) {
 	case int:
-		query := fmt.Sprintf("QPGS%d", payload)
+		query := QPGSnCommand(payload.(int))
 		written, err := port.Write(port.Port, query)
 		if err != nil {
 			return -1, err
@@ -217,27 +350,29 @@ func InterpretQPGSn(input string, inverterNum int) (*QPGSnResponse, error) {
 	if len(inverterStatusBuffer) != wantedLength {
 		return nil, fmt.Errorf("inverter status buffer should have been %d but was %d", wantedLength, len(inverterStatusBuffer))
 	}
-	return &QPGSnResponse{
+	parsed := numbers{}
+	response := &QPGSnResponse{
+		Version:                             QPGSnVersion,
 		InverterNumber:                      inverterNum,
 		OtherUnits:                          buffer[0] == "1" || buffer[0] == "(1",
 		SerialNumber:                        buffer[1],
 		OperationMode:                       OperationModes[buffer[2]],
 		FaultCode:                           FaultCodes[buffer[3]],
-		ACInputVoltage:                      buffer[4],
-		ACInputFrequency:                    buffer[5],
-		ACOutputVoltage:                     buffer[6],
-		ACOutputFrequency:                   buffer[7],
-		ACOutputApparentPower:               buffer[8],
-		ACOutputActivePower:                 buffer[9],
-		PercentageOfNominalOutputPower:      buffer[10],
-		BatteryVoltage:                      buffer[11],
-		BatteryChargingCurrent:              buffer[12],
-		BatteryStateOfCharge:                buffer[13],
-		PVInputVoltage:                      buffer[14],
-		TotalChargingCurrent:                buffer[15],
-		TotalACOutputApparentPower:          buffer[16],
-		TotalACOutputActivePower:            buffer[17],
-		TotalPercentageOfNominalOutputPower: buffer[18],
+		ACInputVoltage:                      Volts(parsed.float("ACInputVoltage", buffer[4])),
+		ACInputFrequency:                    Hertz(parsed.float("ACInputFrequency", buffer[5])),
+		ACOutputVoltage:                     Volts(parsed.float("ACOutputVoltage", buffer[6])),
+		ACOutputFrequency:                   Hertz(parsed.float("ACOutputFrequency", buffer[7])),
+		ACOutputApparentPower:               VoltAmps(parsed.int("ACOutputApparentPower", buffer[8])),
+		ACOutputActivePower:                 Watts(parsed.int("ACOutputActivePower", buffer[9])),
+		PercentageOfNominalOutputPower:      Percent(parsed.int("PercentageOfNominalOutputPower", buffer[10])),
+		BatteryVoltage:                      Volts(parsed.float("BatteryVoltage", buffer[11])),
+		BatteryChargingCurrent:              Amps(parsed.float("BatteryChargingCurrent", buffer[12])),
+		BatteryStateOfCharge:                Percent(parsed.int("BatteryStateOfCharge", buffer[13])),
+		PVInputVoltage:                      Volts(parsed.float("PVInputVoltage", buffer[14])),
+		TotalChargingCurrent:                Amps(parsed.float("TotalChargingCurrent", buffer[15])),
+		TotalACOutputApparentPower:          VoltAmps(parsed.int("TotalACOutputApparentPower", buffer[16])),
+		TotalACOutputActivePower:            Watts(parsed.int("TotalACOutputActivePower", buffer[17])),
+		TotalPercentageOfNominalOutputPower: Percent(parsed.int("TotalPercentageOfNominalOutputPower", buffer[18])),
 		InverterStatus: InverterStatus{
 			MPPT:          Statuses[inverterStatusBuffer[0]],
 			ACCharging:    Statuses[inverterStatusBuffer[1]],
@@ -249,23 +384,32 @@ func InterpretQPGSn(input string, inverterNum int) (*QPGSnResponse, error) {
 		},
 		ACOutputMode:                 ACOutputModes[buffer[20]],
 		BatteryChargerSourcePriority: BatteryChargerSourcePriorities[buffer[21]],
-		MaxChargingCurrentSet:        buffer[22],
-		MaxChargingCurrentPossible:   buffer[23],
-		MaxACChargingCurrentSet:      buffer[24],
-		PVInputCurrent:               buffer[25],
-		BatteryDischargeCurrent:      buffer[26],
+		MaxChargingCurrentSet:        Amps(parsed.float("MaxChargingCurrentSet", buffer[22])),
+		MaxChargingCurrentPossible:   Amps(parsed.float("MaxChargingCurrentPossible", buffer[23])),
+		MaxACChargingCurrentSet:      Amps(parsed.float("MaxACChargingCurrentSet", buffer[24])),
+		PVInputCurrent:               Amps(parsed.float("PVInputCurrent", buffer[25])),
+		BatteryDischargeCurrent:      Amps(parsed.float("BatteryDischargeCurrent", buffer[26])),
 		Checksum:                     fmt.Sprintf("0x%x", checksum),
-	}, nil
+	}
+	if err := parsed.err(); err != nil {
+		return nil, fmt.Errorf("malformed QPGS%d response: %w", inverterNum, err)
+	}
+	return response, nil
 }
 
+// EncodeQPGSn encodes the response as JSON, as version 1 if LegacyJSON is set
 func EncodeQPGSn(response *QPGSnResponse) string {
-	jsonQPGSnResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	var encoded any = response
+	if legacyJSON {
+		encoded = response.Legacy()
+	}
+	jsonQPGSnResponse, _ := json.Marshal(encoded) // err ignored because it can't fail with this input
 	return string(jsonQPGSnResponse)
 }
 
 func PublishQPGSn(client phocus_mqtt.Client, response *QPGSnResponse, inverterNum int) error {
 	jsonResponse := EncodeQPGSn(response)
-	err := phocus_mqtt.Send(client, fmt.Sprintf("phocus/stats/qpgs%d", inverterNum), 0, false, string(jsonResponse), 10)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic(fmt.Sprintf("stats/qpgs%d", inverterNum)), 0, false, string(jsonResponse), 10)
 	if err != nil {
 		log.Printf("MQTT send of QPGS%d failed with: %v\ntype of thing sent was: %T", inverterNum, err, jsonResponse)
 	} else {
@@ -133,7 +133,7 @@ func TestQPGSn(t *testing.T) {
 	t.Run("TestInterpretQPGSn", func(t *testing.T) {
 		// test grabbed input
 		input := "(1 92932004102443 B 00 237.0 50.01 000.0 00.00 0483 0387 009 51.1 000 069 020.4 000 00942 00792 007 00000010 1 1 060 080 10 00.0 006\x06\x6e\r"
-		want := &QPGSnResponse{5, true, "92932004102443", "Off-grid", "", "237.0", "50.01", "000.0", "00.00", "0483", "0387", "009", "51.1", "000", "069", "020.4", "000", "00942", "00792", "007", InverterStatus{"off", "off", "off", "Battery voltage normal", "connected", "on", "0"}, "Parallel output", "Solar first", "060", "080", "10", "00.0", "006", fmt.Sprintf("0x%02x%02x", 0x06, 0x6e)}
+		want := &QPGSnResponse{QPGSnVersion, 5, true, "92932004102443", "Off-grid", "", 237.0, 50.01, 0, 0, 483, 387, 9, 51.1, 0, 69, 20.4, 0, 942, 792, 7, InverterStatus{"off", "off", "off", "Battery voltage normal", "connected", "on", "0"}, "Parallel output", "Solar first", 60, 80, 10, 0, 6, fmt.Sprintf("0x%02x%02x", 0x06, 0x6e)}
 		actual, err := InterpretQPGSn(input, 5)
 		assert.NoError(t, err)
 		assert.Equal(t, want, actual)
@@ -158,6 +158,12 @@ func TestQPGSn(t *testing.T) {
 		actual, err = InterpretQPGSn(input, 5)
 		assert.Equal(t, (*QPGSnResponse)(nil), actual)
 		assert.Equal(t, errors.New("inverter status buffer should have been 8 but was 5"), err)
+
+		// malformed numbers are errors instead of zeroes
+		input = "(1 92932004102443 B 00 237.0 50.01 000.0 00.00 0483 0387 009 5x.1 000 069 020.4 000 00942 00792 007 00000010 1 1 060 080 10 00.0 --\x06\x6e\r"
+		actual, err = InterpretQPGSn(input, 5)
+		assert.Equal(t, (*QPGSnResponse)(nil), actual)
+		assert.EqualError(t, err, "malformed QPGS5 response: BatteryVoltage should be a number but was \"5x.1\"\nBatteryDischargeCurrent should be a number but was \"--\"")
 	})
 
 	t.Run("TestEncodeQPGSn", func(t *testing.T) {
@@ -168,8 +174,28 @@ func TestQPGSn(t *testing.T) {
 		actual, err := InterpretQPGSn(input, 1)
 		assert.NoError(t, err)
 
-		want := "{\"InverterNumber\":1,\"OtherUnits\":true,\"SerialNumber\":\"92932004102443\",\"OperationMode\":\"Off-grid\",\"FaultCode\":\"\",\"ACInputVoltage\":\"237.0\",\"ACInputFrequency\":\"50.01\",\"ACOutputVoltage\":\"000.0\",\"ACOutputFrequency\":\"00.00\",\"ACOutputApparentPower\":\"0483\",\"ACOutputActivePower\":\"0387\",\"PercentageOfNominalOutputPower\":\"009\",\"BatteryVoltage\":\"51.1\",\"BatteryChargingCurrent\":\"000\",\"BatteryStateOfCharge\":\"069\",\"PVInputVoltage\":\"020.4\",\"TotalChargingCurrent\":\"000\",\"TotalACOutputApparentPower\":\"00942\",\"TotalACOutputActivePower\":\"00792\",\"TotalPercentageOfNominalOutputPower\":\"007\",\"InverterStatus\":{\"MPPT\":\"off\",\"ACCharging\":\"off\",\"SolarCharging\":\"off\",\"BatteryStatus\":\"Battery voltage normal\",\"ACInput\":\"connected\",\"ACOutput\":\"on\",\"Reserved\":\"0\"},\"ACOutputMode\":\"Parallel output\",\"BatteryChargerSourcePriority\":\"Solar first\",\"MaxChargingCurrentSet\":\"060\",\"MaxChargingCurrentPossible\":\"080\",\"MaxACChargingCurrentSet\":\"10\",\"PVInputCurrent\":\"00.0\",\"BatteryDischargeCurrent\":\"006\",\"Checksum\":\"0x066e\"}"
+		want := "{\"Version\":2,\"InverterNumber\":1,\"OtherUnits\":true,\"SerialNumber\":\"92932004102443\",\"OperationMode\":\"Off-grid\",\"FaultCode\":\"\",\"ACInputVoltage\":237,\"ACInputFrequency\":50.01,\"ACOutputVoltage\":0,\"ACOutputFrequency\":0,\"ACOutputApparentPower\":483,\"ACOutputActivePower\":387,\"PercentageOfNominalOutputPower\":9,\"BatteryVoltage\":51.1,\"BatteryChargingCurrent\":0,\"BatteryStateOfCharge\":69,\"PVInputVoltage\":20.4,\"TotalChargingCurrent\":0,\"TotalACOutputApparentPower\":942,\"TotalACOutputActivePower\":792,\"TotalPercentageOfNominalOutputPower\":7,\"InverterStatus\":{\"MPPT\":\"off\",\"ACCharging\":\"off\",\"SolarCharging\":\"off\",\"BatteryStatus\":\"Battery voltage normal\",\"ACInput\":\"connected\",\"ACOutput\":\"on\",\"Reserved\":\"0\"},\"ACOutputMode\":\"Parallel output\",\"BatteryChargerSourcePriority\":\"Solar first\",\"MaxChargingCurrentSet\":60,\"MaxChargingCurrentPossible\":80,\"MaxACChargingCurrentSet\":10,\"PVInputCurrent\":0,\"BatteryDischargeCurrent\":6,\"Checksum\":\"0x066e\"}"
+		jsonResponse = EncodeQPGSn(actual)
+		assert.Equal(t, want, jsonResponse)
+
+		// the strings from before the measurements were numbers are kept for existing consumers
+		SetLegacyJSON(true)
+		defer SetLegacyJSON(false)
+		assert.Equal(t, "null", EncodeQPGSn(nil))
+		want = "{\"InverterNumber\":1,\"OtherUnits\":true,\"SerialNumber\":\"92932004102443\",\"OperationMode\":\"Off-grid\",\"FaultCode\":\"\",\"ACInputVoltage\":\"237.0\",\"ACInputFrequency\":\"50.01\",\"ACOutputVoltage\":\"000.0\",\"ACOutputFrequency\":\"00.00\",\"ACOutputApparentPower\":\"0483\",\"ACOutputActivePower\":\"0387\",\"PercentageOfNominalOutputPower\":\"009\",\"BatteryVoltage\":\"51.1\",\"BatteryChargingCurrent\":\"000\",\"BatteryStateOfCharge\":\"069\",\"PVInputVoltage\":\"020.4\",\"TotalChargingCurrent\":\"000\",\"TotalACOutputApparentPower\":\"00942\",\"TotalACOutputActivePower\":\"00792\",\"TotalPercentageOfNominalOutputPower\":\"007\",\"InverterStatus\":{\"MPPT\":\"off\",\"ACCharging\":\"off\",\"SolarCharging\":\"off\",\"BatteryStatus\":\"Battery voltage normal\",\"ACInput\":\"connected\",\"ACOutput\":\"on\",\"Reserved\":\"0\"},\"ACOutputMode\":\"Parallel output\",\"BatteryChargerSourcePriority\":\"Solar first\",\"MaxChargingCurrentSet\":\"060\",\"MaxChargingCurrentPossible\":\"080\",\"MaxACChargingCurrentSet\":\"10\",\"PVInputCurrent\":\"00.0\",\"BatteryDischargeCurrent\":\"006\",\"Checksum\":\"0x066e\"}"
 		jsonResponse = EncodeQPGSn(actual)
 		assert.Equal(t, want, jsonResponse)
 	})
 }
+
+func TestParseQPGSnCommand(t *testing.T) {
+	inputs := []string{"QPGS0", "QPGS1", "QPGS2", "QPGS12", "QPGS", "QPGS-1", "QPGSx", "QID", "qpgs1"}
+	wantNums := []int{0, 1, 2, 12, 0, 0, 0, 0, 0}
+	wantOks := []bool{true, true, true, true, false, false, false, false, false}
+	for index, input := range inputs {
+		inverterNum, ok := ParseQPGSnCommand(input)
+		assert.Equal(t, wantNums[index], inverterNum, input)
+		assert.Equal(t, wantOks[index], ok, input)
+	}
+	assert.Equal(t, "QPGS7", QPGSnCommand(7))
+}
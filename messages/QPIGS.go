@@ -0,0 +1,173 @@
+package phocus_messages
+
+import (
+	"encoding/json" // encoding to json for mqtt
+	"errors"        // creating custom err messages
+	"fmt"           // string formatting
+	"log"           // logging to std out
+	"strings"       // string manipulation
+	"time"          // timeouts
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"   // checksum calculations
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt" // comms with mqtt broker
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+// DeviceStatus is the device status bits of QPIGS
+type DeviceStatus struct {
+	SBUPriorityVersion                Status `ha:",name=SBU Priority,icon=mdi:information-outline"` // b7
+	ConfigurationChanged              Status `ha:",icon=mdi:cog"`                                   // b6
+	SCCFirmwareUpdated                Status `ha:",icon=mdi:update"`                                // b5
+	LoadOn                            Status `ha:"load_on,name=Load,icon=mdi:power-plug"`           // b4
+	BatteryVoltageSteadyWhileCharging Status `ha:",icon=mdi:battery"`                               // b3
+	Charging                          Status `ha:",icon=mdi:battery-charging"`                      // b2
+	SCCCharging                       Status `ha:",name=Solar Charging,icon=mdi:solar-power"`       // b1
+	ACCharging                        Status `ha:",icon=mdi:transmission-tower"`                    // b0
+	ChargingToFloat                   Status `ha:",icon=mdi:battery-charging"`                      // b10, only on newer firmware
+	SwitchedOn                        Status `ha:",icon=mdi:power"`                                 // b9, only on newer firmware
+	DustproofInstalled                Status `ha:",icon=mdi:air-filter"`                            // b8, only on newer firmware
+}
+
+type QPIGSResponse struct {
+	// (BBB.B CC.C DDD.D EE.E FFFF GGGG HHH III JJ.JJ KKK OOO TTTT EEEE UUU.U WW.WW PPPPP b7b6b5b4b3b2b1b0 QQ VV MMMMM b10b9b8<CRC><cr>
+	ACInputVoltage                 string `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACInputFrequency               string `ha:",unit=Hz,precision=2,device_class=frequency,state_class=measurement,icon=mdi:sine-wave"`
+	ACOutputVoltage                string `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACOutputFrequency              string `ha:",unit=Hz,precision=2,device_class=frequency,state_class=measurement,icon=mdi:sine-wave"`
+	ACOutputApparentPower          string `ha:",unit=VA,device_class=apparent_power,state_class=measurement,icon=mdi:lightning-bolt"`
+	ACOutputActivePower            string `ha:",unit=W,device_class=power,state_class=measurement,icon=mdi:lightning-bolt"`
+	PercentageOfNominalOutputPower string `ha:"output_load,name=Output Load,unit=%,state_class=measurement,icon=mdi:gauge"`
+	BusVoltage                     string `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	BatteryVoltage                 string `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:battery"`
+	BatteryChargingCurrent         string `ha:"battery_charge_current,name=Battery Charge Current,unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	BatteryStateOfCharge           string `ha:",name=Battery SoC,unit=%,device_class=battery,state_class=measurement,icon=mdi:battery"`
+	HeatsinkTemperature            string `ha:",unit=°C,device_class=temperature,state_class=measurement,icon=mdi:thermometer"`
+	PVInputCurrent                 string `ha:",unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	PVInputVoltage                 string `ha:",unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:lightning-bolt"`
+	BatteryVoltageFromSCC          string `ha:",name=Battery Voltage From SCC,unit=V,precision=1,device_class=voltage,state_class=measurement,icon=mdi:battery"`
+	BatteryDischargeCurrent        string `ha:",unit=A,device_class=current,state_class=measurement,icon=mdi:current-dc"`
+	DeviceStatus                   DeviceStatus
+	BatteryVoltageOffsetForFans    string `ha:",icon=mdi:fan"`                                                           // only on newer firmware
+	EEPROMVersion                  string `ha:",icon=mdi:chip"`                                                          // only on newer firmware
+	PVChargingPower                string `ha:",unit=W,device_class=power,state_class=measurement,icon=mdi:solar-power"` // only on newer firmware
+	Checksum                       string `ha:"-"`
+}
+
+func SendQPIGS(port phocus_serial.Port, payload interface{}) (int, error) {
+	written, err := port.Write(port.Port, "QPIGS")
+	if err != nil {
+		return -1, err
+	} else {
+		fmt.Printf("Wrote QPIGS of %d bytes\n", written)
+		return written, nil
+	}
+}
+
+func ReceiveQPIGS(port phocus_serial.Port, timeout time.Duration) (string, error) {
+	response, err := port.Read(port.Port, timeout)
+	log.Printf("%s\n", response)
+	if err != nil || response == "" {
+		log.Printf("Failed to read from serial with: %v\n", err)
+		return "", err
+	} else {
+		return VerifyQPIGS(response)
+	}
+}
+
+func VerifyQPIGS(response string) (string, error) {
+	if phocus_crc.Verify(response) {
+		return response, nil
+	} else {
+		if len(response) < 3 {
+			return "", fmt.Errorf("response not long enough: %s", response)
+		}
+		actual := response[len(response)-3 : len(response)-1] // 2 bytes of crc
+		remainder := response[:len(response)-3]               // actual response
+		wanted := phocus_crc.Checksum(remainder)              // response calculated on response data
+		message := fmt.Sprintf("invalid response from QPIGS: CRC should have been %x but was %x", wanted, actual)
+		log.Println(message)
+		return "", errors.New(message)
+	}
+}
+
+// InterpretQPIGS parses a QPIGS response which has 17 fields on older
+// firmware and 21 on newer firmware that also reports the PV charging power
+func InterpretQPIGS(input string) (*QPIGSResponse, error) {
+	if input == "" {
+		return nil, errors.New("can't create a response from an empty string")
+	} else if len(input) < 4 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	buffer := strings.Split(strings.TrimPrefix(input[:len(input)-3], "("), " ")
+	checksum := input[len(input)-3 : len(input)-1]
+	log.Printf("Buffer: %v\n", buffer)
+	log.Printf("Checksum: %x\n", checksum)
+	if len(buffer) != 17 && len(buffer) != 21 {
+		return nil, fmt.Errorf("input for QPIGSResponse was %v but should have been 17 or 21", len(buffer))
+	}
+
+	deviceStatusBuffer := strings.Split(buffer[16], "")
+	wantedLength := 8
+	if len(deviceStatusBuffer) != wantedLength {
+		return nil, fmt.Errorf("device status buffer should have been %d but was %d", wantedLength, len(deviceStatusBuffer))
+	}
+	response := &QPIGSResponse{
+		ACInputVoltage:                 buffer[0],
+		ACInputFrequency:               buffer[1],
+		ACOutputVoltage:                buffer[2],
+		ACOutputFrequency:              buffer[3],
+		ACOutputApparentPower:          buffer[4],
+		ACOutputActivePower:            buffer[5],
+		PercentageOfNominalOutputPower: buffer[6],
+		BusVoltage:                     buffer[7],
+		BatteryVoltage:                 buffer[8],
+		BatteryChargingCurrent:         buffer[9],
+		BatteryStateOfCharge:           buffer[10],
+		HeatsinkTemperature:            buffer[11],
+		PVInputCurrent:                 buffer[12],
+		PVInputVoltage:                 buffer[13],
+		BatteryVoltageFromSCC:          buffer[14],
+		BatteryDischargeCurrent:        buffer[15],
+		DeviceStatus: DeviceStatus{
+			SBUPriorityVersion:                Statuses[deviceStatusBuffer[0]],
+			ConfigurationChanged:              Statuses[deviceStatusBuffer[1]],
+			SCCFirmwareUpdated:                Statuses[deviceStatusBuffer[2]],
+			LoadOn:                            Statuses[deviceStatusBuffer[3]],
+			BatteryVoltageSteadyWhileCharging: Statuses[deviceStatusBuffer[4]],
+			Charging:                          Statuses[deviceStatusBuffer[5]],
+			SCCCharging:                       Statuses[deviceStatusBuffer[6]],
+			ACCharging:                        Statuses[deviceStatusBuffer[7]],
+		},
+		Checksum: fmt.Sprintf("0x%x", checksum),
+	}
+	if len(buffer) == 21 {
+		extendedStatusBuffer := strings.Split(buffer[20], "")
+		wantedLength = 3
+		if len(extendedStatusBuffer) != wantedLength {
+			return nil, fmt.Errorf("extended device status buffer should have been %d but was %d", wantedLength, len(extendedStatusBuffer))
+		}
+		response.BatteryVoltageOffsetForFans = buffer[17]
+		response.EEPROMVersion = buffer[18]
+		response.PVChargingPower = buffer[19]
+		response.DeviceStatus.ChargingToFloat = Statuses[extendedStatusBuffer[0]]
+		response.DeviceStatus.SwitchedOn = Statuses[extendedStatusBuffer[1]]
+		response.DeviceStatus.DustproofInstalled = Statuses[extendedStatusBuffer[2]]
+	}
+	return response, nil
+}
+
+func EncodeQPIGS(response *QPIGSResponse) string {
+	jsonQPIGSResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	return string(jsonQPIGSResponse)
+}
+
+func PublishQPIGS(client phocus_mqtt.Client, response *QPIGSResponse) error {
+	jsonResponse := EncodeQPIGS(response)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic("stats/qpigs"), 0, false, jsonResponse, 10)
+	if err != nil {
+		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", "QPIGS", err, jsonResponse)
+	} else {
+		log.Printf("Sent to MQTT:\n%s\n", jsonResponse)
+	}
+	return err
+}
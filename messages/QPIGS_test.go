@@ -0,0 +1,146 @@
+package phocus_messages
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+	"go.bug.st/serial"
+)
+
+// fakeQPIGSPort records what was written and responds with response
+func fakeQPIGSPort(written *string, response string, err error) phocus_serial.Port {
+	return phocus_serial.Port{
+		Write: func(port serial.Port, input string) (int, error) {
+			*written = input
+			return len(input) + 3, nil
+		},
+		Read: func(port serial.Port, timeout time.Duration) (string, error) {
+			return response, err
+		},
+	}
+}
+
+func TestSendQPIGS(t *testing.T) {
+	var written string
+	n, err := SendQPIGS(fakeQPIGSPort(&written, "", nil), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "QPIGS", written)
+
+	n, err = SendQPIGS(phocus_serial.Port{Write: phocus_serial.Write}, nil)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, phocus_serial.ErrNilPortOnWrite, err)
+}
+
+func TestReceiveQPIGS(t *testing.T) {
+	var written string
+	response, err := ReceiveQPIGS(fakeQPIGSPort(&written, phocus_crc.Encode("(230.0 50.0"), nil), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, phocus_crc.Encode("(230.0 50.0"), response)
+
+	response, err = ReceiveQPIGS(fakeQPIGSPort(&written, "(230.0 50.0\x01\x02\r", nil), 0)
+	assert.EqualError(t, err, "invalid response from QPIGS: CRC should have been d98a but was 0102")
+	assert.Equal(t, "", response)
+
+	response, err = ReceiveQPIGS(fakeQPIGSPort(&written, "", phocus_serial.ErrReadNothing), 0)
+	assert.Equal(t, phocus_serial.ErrReadNothing, err)
+	assert.Equal(t, "", response)
+
+	response, err = ReceiveQPIGS(fakeQPIGSPort(&written, "\r", nil), 0)
+	assert.EqualError(t, err, "response not long enough: \r")
+	assert.Equal(t, "", response)
+}
+
+func TestInterpretQPIGS(t *testing.T) {
+	// newer firmware
+	response, err := InterpretQPIGS(phocus_crc.Encode("(000.0 00.0 230.0 49.9 0161 0119 003 460 57.50 012 100 0069 0014 103.8 57.45 00000 00110110 00 00 00856 010"))
+	assert.NoError(t, err)
+	assert.Equal(t, &QPIGSResponse{
+		ACInputVoltage:                 "000.0",
+		ACInputFrequency:               "00.0",
+		ACOutputVoltage:                "230.0",
+		ACOutputFrequency:              "49.9",
+		ACOutputApparentPower:          "0161",
+		ACOutputActivePower:            "0119",
+		PercentageOfNominalOutputPower: "003",
+		BusVoltage:                     "460",
+		BatteryVoltage:                 "57.50",
+		BatteryChargingCurrent:         "012",
+		BatteryStateOfCharge:           "100",
+		HeatsinkTemperature:            "0069",
+		PVInputCurrent:                 "0014",
+		PVInputVoltage:                 "103.8",
+		BatteryVoltageFromSCC:          "57.45",
+		BatteryDischargeCurrent:        "00000",
+		DeviceStatus: DeviceStatus{
+			SBUPriorityVersion:                "off",
+			ConfigurationChanged:              "off",
+			SCCFirmwareUpdated:                "on",
+			LoadOn:                            "on",
+			BatteryVoltageSteadyWhileCharging: "off",
+			Charging:                          "on",
+			SCCCharging:                       "on",
+			ACCharging:                        "off",
+			ChargingToFloat:                   "off",
+			SwitchedOn:                        "on",
+			DustproofInstalled:                "off",
+		},
+		BatteryVoltageOffsetForFans: "00",
+		EEPROMVersion:               "00",
+		PVChargingPower:             "00856",
+		Checksum:                    "0x248c",
+	}, response)
+
+	// older firmware
+	response, err = InterpretQPIGS(phocus_crc.Encode("(230.1 50.0 230.1 50.0 0345 0298 006 390 26.80 000 085 0037 0000 000.0 00.00 00012 00010000"))
+	assert.NoError(t, err)
+	assert.Equal(t, "390", response.BusVoltage)
+	assert.Equal(t, "0037", response.HeatsinkTemperature)
+	assert.Equal(t, Status("on"), response.DeviceStatus.LoadOn)
+	assert.Equal(t, Status(""), response.DeviceStatus.SwitchedOn)
+	assert.Equal(t, "", response.PVChargingPower)
+
+	// malformed
+	response, err = InterpretQPIGS("")
+	assert.EqualError(t, err, "can't create a response from an empty string")
+	assert.Nil(t, response)
+	response, err = InterpretQPIGS("(\r")
+	assert.EqualError(t, err, "response is malformed or shorter than expected")
+	assert.Nil(t, response)
+	response, err = InterpretQPIGS(phocus_crc.Encode("(230.1 50.0 230.1"))
+	assert.EqualError(t, err, "input for QPIGSResponse was 3 but should have been 17 or 21")
+	assert.Nil(t, response)
+	response, err = InterpretQPIGS(phocus_crc.Encode("(230.1 50.0 230.1 50.0 0345 0298 006 390 26.80 000 085 0037 0000 000.0 00.00 00012 0001"))
+	assert.EqualError(t, err, "device status buffer should have been 8 but was 4")
+	assert.Nil(t, response)
+	response, err = InterpretQPIGS(phocus_crc.Encode("(000.0 00.0 230.0 49.9 0161 0119 003 460 57.50 012 100 0069 0014 103.8 57.45 00000 00110110 00 00 00856 01"))
+	assert.EqualError(t, err, "extended device status buffer should have been 3 but was 2")
+	assert.Nil(t, response)
+}
+
+func TestEncodeAndPublishQPIGS(t *testing.T) {
+	response, err := InterpretQPIGS(phocus_crc.Encode("(230.1 50.0 230.1 50.0 0345 0298 006 390 26.80 000 085 0037 0000 000.0 00.00 00012 00010000"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ACInputVoltage":"230.1","ACInputFrequency":"50.0","ACOutputVoltage":"230.1","ACOutputFrequency":"50.0","ACOutputApparentPower":"0345","ACOutputActivePower":"0298","PercentageOfNominalOutputPower":"006","BusVoltage":"390","BatteryVoltage":"26.80","BatteryChargingCurrent":"000","BatteryStateOfCharge":"085","HeatsinkTemperature":"0037","PVInputCurrent":"0000","PVInputVoltage":"000.0","BatteryVoltageFromSCC":"00.00","BatteryDischargeCurrent":"00012","DeviceStatus":{"SBUPriorityVersion":"off","ConfigurationChanged":"off","SCCFirmwareUpdated":"off","LoadOn":"on","BatteryVoltageSteadyWhileCharging":"off","Charging":"off","SCCCharging":"off","ACCharging":"off","ChargingToFloat":"","SwitchedOn":"","DustproofInstalled":""},"BatteryVoltageOffsetForFans":"","EEPROMVersion":"","PVChargingPower":"","Checksum":"0xcba7"}`, EncodeQPIGS(response))
+
+	err = PublishQPIGS(nil, response)
+	assert.EqualError(t, err, "client not defined in send")
+}
+
+func TestInterpretWithQPIGS(t *testing.T) {
+	var written string
+	port := fakeQPIGSPort(&written, phocus_crc.Encode("(230.1 50.0 230.1 50.0 0345 0298 006 390 26.80 000 085 0037 0000 000.0 00.00 00012 00010000"), nil)
+	response, err := Interpret(nil, port, Message{uuid.New(), "QPIGS", ""}, 0)
+	assert.EqualError(t, err, "client not defined in send")
+	assert.Equal(t, "390", response.(*QPIGSResponse).BusVoltage)
+	assert.Equal(t, "QPIGS", written)
+
+	port = fakeQPIGSPort(&written, "", errors.New("read returned nothing"))
+	_, err = Interpret(nil, port, Message{uuid.New(), "QPIGS", ""}, 0)
+	assert.EqualError(t, err, "read returned nothing")
+}
@@ -0,0 +1,226 @@
+package phocus_messages
+
+import (
+	"encoding/json" // encoding to json for mqtt
+	"errors"        // creating custom err messages
+	"fmt"           // string formatting
+	"log"           // logging to std out
+	"strings"       // string manipulation
+	"time"          // timeouts
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"   // checksum calculations
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt" // comms with mqtt broker
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+type BatteryType string
+
+var BatteryTypes = map[string]BatteryType{
+	"0": "AGM",
+	"1": "Flooded",
+	"2": "User",
+	"3": "Pylontech",
+	"5": "Weco",
+	"6": "Soltaro",
+	"8": "Lithium",
+	"9": "Lithium (custom)",
+}
+
+// Options are the values that a BatteryType can have
+func (BatteryType) Options() []string { return enumerate(BatteryTypes) }
+
+type InputVoltageRange string
+
+var InputVoltageRanges = map[string]InputVoltageRange{
+	"0": "Appliance",
+	"1": "UPS",
+}
+
+// Options are the values that a InputVoltageRange can have
+func (InputVoltageRange) Options() []string { return enumerate(InputVoltageRanges) }
+
+type OutputSourcePriority string
+
+var OutputSourcePriorities = map[string]OutputSourcePriority{
+	"0": "Utility first",
+	"1": "Solar first",
+	"2": "SBU first",
+}
+
+// Options are the values that a OutputSourcePriority can have
+func (OutputSourcePriority) Options() []string { return enumerate(OutputSourcePriorities) }
+
+// ChargerSourcePriority is the charger source priority as reported by QPIRI
+// which uses different codes to the BatteryChargerSourcePriority of QPGSn
+type ChargerSourcePriority string
+
+var ChargerSourcePriorities = map[string]ChargerSourcePriority{
+	"0": "Utility first",
+	"1": "Solar first",
+	"2": "Solar and Utility",
+	"3": "Solar only",
+}
+
+// Options are the values that a ChargerSourcePriority can have
+func (ChargerSourcePriority) Options() []string { return enumerate(ChargerSourcePriorities) }
+
+type MachineType string
+
+var MachineTypes = map[string]MachineType{
+	"00": "Grid tie",
+	"01": "Off grid",
+	"10": "Hybrid",
+}
+
+// Options are the values that a MachineType can have
+func (MachineType) Options() []string { return enumerate(MachineTypes) }
+
+type Topology string
+
+var Topologies = map[string]Topology{
+	"0": "Transformerless",
+	"1": "Transformer",
+}
+
+// Options are the values that a Topology can have
+func (Topology) Options() []string { return enumerate(Topologies) }
+
+type QPIRIResponse struct {
+	// (BBB.B CC.C DDD.D EE.E FF.F HHHH IIII JJ.J KK.K JJ.J KK.K LL.L O PPP QQQ O P Q R SS T U VV.V W X<CRC><cr>
+	ACInputRatingVoltage        string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:lightning-bolt,category=diagnostic"`
+	ACInputRatingCurrent        string                `ha:",unit=A,device_class=current,icon=mdi:current-ac,category=diagnostic"`
+	ACOutputRatingVoltage       string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:lightning-bolt,category=diagnostic"`
+	ACOutputRatingFrequency     string                `ha:",unit=Hz,precision=2,device_class=frequency,icon=mdi:sine-wave,category=diagnostic"`
+	ACOutputRatingCurrent       string                `ha:",unit=A,device_class=current,icon=mdi:current-ac,category=diagnostic"`
+	ACOutputRatingApparentPower string                `ha:",unit=VA,device_class=apparent_power,icon=mdi:lightning-bolt,category=diagnostic"`
+	ACOutputRatingActivePower   string                `ha:",unit=W,device_class=power,icon=mdi:lightning-bolt,category=diagnostic"`
+	BatteryRatingVoltage        string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"`
+	BatteryRechargeVoltage      string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"`
+	BatteryUnderVoltage         string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"` // cut-off voltage
+	BatteryBulkVoltage          string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"`
+	BatteryFloatVoltage         string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"`
+	BatteryType                 BatteryType           `ha:",icon=mdi:battery,category=diagnostic"`
+	MaxACChargingCurrent        string                `ha:",unit=A,device_class=current,icon=mdi:current-dc,category=diagnostic"`
+	MaxChargingCurrent          string                `ha:",unit=A,device_class=current,icon=mdi:current-dc,category=diagnostic"`
+	InputVoltageRange           InputVoltageRange     `ha:",icon=mdi:transmission-tower,category=diagnostic"`
+	OutputSourcePriority        OutputSourcePriority  `ha:",icon=mdi:transmission-tower,category=diagnostic"`
+	ChargerSourcePriority       ChargerSourcePriority `ha:",icon=mdi:battery-charging,category=diagnostic"`
+	ParallelMaxNumber           string                `ha:",icon=mdi:counter,category=diagnostic"`
+	MachineType                 MachineType           `ha:",icon=mdi:information-outline,category=diagnostic"`
+	Topology                    Topology              `ha:",icon=mdi:information-outline,category=diagnostic"`
+	OutputMode                  ACOutputMode          `ha:",icon=mdi:power-socket,category=diagnostic"`
+	BatteryRedischargeVoltage   string                `ha:",unit=V,precision=1,device_class=voltage,icon=mdi:battery,category=diagnostic"`
+	PVOKConditionForParallel    Status                `ha:"pv_ok_condition_for_parallel,name=PV OK Condition For Parallel,icon=mdi:solar-power,category=diagnostic"`
+	PVPowerBalance              Status                `ha:",icon=mdi:solar-power,category=diagnostic"`
+	MaxChargingTimeAtCVStage    string                `ha:",name=Max Charging Time At CV Stage,unit=min,device_class=duration,icon=mdi:timer,category=diagnostic"` // only on newer firmware
+	MaxDischargingCurrent       string                `ha:",unit=A,device_class=current,icon=mdi:current-dc,category=diagnostic"`                                  // only on newer firmware
+	Checksum                    string                `ha:"-"`
+}
+
+func SendQPIRI(port phocus_serial.Port, payload interface{}) (int, error) {
+	written, err := port.Write(port.Port, "QPIRI")
+	if err != nil {
+		return -1, err
+	} else {
+		fmt.Printf("Wrote QPIRI of %d bytes\n", written)
+		return written, nil
+	}
+}
+
+func ReceiveQPIRI(port phocus_serial.Port, timeout time.Duration) (string, error) {
+	response, err := port.Read(port.Port, timeout)
+	log.Printf("%s\n", response)
+	if err != nil || response == "" {
+		log.Printf("Failed to read from serial with: %v\n", err)
+		return "", err
+	} else {
+		return VerifyQPIRI(response)
+	}
+}
+
+func VerifyQPIRI(response string) (string, error) {
+	if phocus_crc.Verify(response) {
+		return response, nil
+	} else {
+		if len(response) < 3 {
+			return "", fmt.Errorf("response not long enough: %s", response)
+		}
+		actual := response[len(response)-3 : len(response)-1] // 2 bytes of crc
+		remainder := response[:len(response)-3]               // actual response
+		wanted := phocus_crc.Checksum(remainder)              // response calculated on response data
+		message := fmt.Sprintf("invalid response from QPIRI: CRC should have been %x but was %x", wanted, actual)
+		log.Println(message)
+		return "", errors.New(message)
+	}
+}
+
+// InterpretQPIRI parses a QPIRI response which has at least 25 fields
+// with newer firmware adding the max charging time at the CV stage,
+// the operation logic and the max discharging current
+func InterpretQPIRI(input string) (*QPIRIResponse, error) {
+	if input == "" {
+		return nil, errors.New("can't create a response from an empty string")
+	} else if len(input) < 4 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	buffer := strings.Split(strings.TrimPrefix(input[:len(input)-3], "("), " ")
+	checksum := input[len(input)-3 : len(input)-1]
+	log.Printf("Buffer: %v\n", buffer)
+	log.Printf("Checksum: %x\n", checksum)
+	wantedLength := 25
+	if len(buffer) < wantedLength {
+		return nil, fmt.Errorf("input for QPIRIResponse was %v but should have been at least %v", len(buffer), wantedLength)
+	}
+	response := &QPIRIResponse{
+		ACInputRatingVoltage:        buffer[0],
+		ACInputRatingCurrent:        buffer[1],
+		ACOutputRatingVoltage:       buffer[2],
+		ACOutputRatingFrequency:     buffer[3],
+		ACOutputRatingCurrent:       buffer[4],
+		ACOutputRatingApparentPower: buffer[5],
+		ACOutputRatingActivePower:   buffer[6],
+		BatteryRatingVoltage:        buffer[7],
+		BatteryRechargeVoltage:      buffer[8],
+		BatteryUnderVoltage:         buffer[9],
+		BatteryBulkVoltage:          buffer[10],
+		BatteryFloatVoltage:         buffer[11],
+		BatteryType:                 BatteryTypes[buffer[12]],
+		MaxACChargingCurrent:        buffer[13],
+		MaxChargingCurrent:          buffer[14],
+		InputVoltageRange:           InputVoltageRanges[buffer[15]],
+		OutputSourcePriority:        OutputSourcePriorities[buffer[16]],
+		ChargerSourcePriority:       ChargerSourcePriorities[buffer[17]],
+		ParallelMaxNumber:           buffer[18],
+		MachineType:                 MachineTypes[buffer[19]],
+		Topology:                    Topologies[buffer[20]],
+		OutputMode:                  ACOutputModes[buffer[21]],
+		BatteryRedischargeVoltage:   buffer[22],
+		PVOKConditionForParallel:    Statuses[buffer[23]],
+		PVPowerBalance:              Statuses[buffer[24]],
+		Checksum:                    fmt.Sprintf("0x%x", checksum),
+	}
+	if len(buffer) > 25 {
+		response.MaxChargingTimeAtCVStage = buffer[25]
+	}
+	if len(buffer) > 27 {
+		response.MaxDischargingCurrent = buffer[27]
+	}
+	return response, nil
+}
+
+func EncodeQPIRI(response *QPIRIResponse) string {
+	jsonQPIRIResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	return string(jsonQPIRIResponse)
+}
+
+// PublishQPIRI sends the settings retained so that they are available as soon as something subscribes
+func PublishQPIRI(client phocus_mqtt.Client, response *QPIRIResponse) error {
+	jsonResponse := EncodeQPIRI(response)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic("stats/qpiri"), 0, true, jsonResponse, 10)
+	if err != nil {
+		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", "QPIRI", err, jsonResponse)
+	} else {
+		log.Printf("Sent to MQTT:\n%s\n", jsonResponse)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package phocus_messages
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+func TestSendAndReceiveQPIRI(t *testing.T) {
+	var written string
+	n, err := SendQPIRI(fakeQPIGSPort(&written, "", nil), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "QPIRI", written)
+
+	n, err = SendQPIRI(phocus_serial.Port{Write: phocus_serial.Write}, nil)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, phocus_serial.ErrNilPortOnWrite, err)
+
+	response, err := ReceiveQPIRI(fakeQPIGSPort(&written, phocus_crc.Encode("(230.0 21.7"), nil), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, phocus_crc.Encode("(230.0 21.7"), response)
+
+	response, err = ReceiveQPIRI(fakeQPIGSPort(&written, "(230.0 21.7\x01\x02\r", nil), 0)
+	assert.EqualError(t, err, "invalid response from QPIRI: CRC should have been cf70 but was 0102")
+	assert.Equal(t, "", response)
+
+	response, err = ReceiveQPIRI(fakeQPIGSPort(&written, "", phocus_serial.ErrReadNothing), 0)
+	assert.Equal(t, phocus_serial.ErrReadNothing, err)
+	assert.Equal(t, "", response)
+}
+
+func TestInterpretQPIRI(t *testing.T) {
+	response, err := InterpretQPIRI(phocus_crc.Encode("(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 0 54.0 0 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, &QPIRIResponse{
+		ACInputRatingVoltage:        "230.0",
+		ACInputRatingCurrent:        "21.7",
+		ACOutputRatingVoltage:       "230.0",
+		ACOutputRatingFrequency:     "50.0",
+		ACOutputRatingCurrent:       "21.7",
+		ACOutputRatingApparentPower: "5000",
+		ACOutputRatingActivePower:   "5000",
+		BatteryRatingVoltage:        "48.0",
+		BatteryRechargeVoltage:      "46.0",
+		BatteryUnderVoltage:         "42.0",
+		BatteryBulkVoltage:          "56.4",
+		BatteryFloatVoltage:         "54.0",
+		BatteryType:                 "User",
+		MaxACChargingCurrent:        "020",
+		MaxChargingCurrent:          "060",
+		InputVoltageRange:           "Appliance",
+		OutputSourcePriority:        "SBU first",
+		ChargerSourcePriority:       "Solar only",
+		ParallelMaxNumber:           "9",
+		MachineType:                 "Off grid",
+		Topology:                    "Transformerless",
+		OutputMode:                  "Single Any-Grid unit",
+		BatteryRedischargeVoltage:   "54.0",
+		PVOKConditionForParallel:    "off",
+		PVPowerBalance:              "on",
+		Checksum:                    "0x1cc5",
+	}, response)
+
+	// newer firmware
+	response, err = InterpretQPIRI(phocus_crc.Encode("(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 1 54.0 0 1 120 0 150"))
+	assert.NoError(t, err)
+	assert.Equal(t, ACOutputMode("Parallel output"), response.OutputMode)
+	assert.Equal(t, "120", response.MaxChargingTimeAtCVStage)
+	assert.Equal(t, "150", response.MaxDischargingCurrent)
+
+	response, err = InterpretQPIRI("")
+	assert.EqualError(t, err, "can't create a response from an empty string")
+	assert.Nil(t, response)
+	response, err = InterpretQPIRI("(\r")
+	assert.EqualError(t, err, "response is malformed or shorter than expected")
+	assert.Nil(t, response)
+	response, err = InterpretQPIRI(phocus_crc.Encode("(230.0 21.7 230.0 50.0"))
+	assert.EqualError(t, err, "input for QPIRIResponse was 4 but should have been at least 25")
+	assert.Nil(t, response)
+}
+
+func TestPublishQPIRI(t *testing.T) {
+	response, err := InterpretQPIRI(phocus_crc.Encode("(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 0 54.0 0 1"))
+	assert.NoError(t, err)
+	assert.Contains(t, EncodeQPIRI(response), `"BatteryType":"User","MaxACChargingCurrent":"020"`)
+	assert.EqualError(t, PublishQPIRI(nil, response), "client not defined in send")
+
+	var written string
+	port := fakeQPIGSPort(&written, phocus_crc.Encode("(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 0 54.0 0 1"), nil)
+	interpreted, err := Interpret(nil, port, Message{uuid.New(), "QPIRI", ""}, 0)
+	assert.EqualError(t, err, "client not defined in send")
+	assert.Equal(t, response, interpreted)
+}
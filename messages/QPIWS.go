@@ -0,0 +1,154 @@
+package phocus_messages
+
+import (
+	"encoding/json" // encoding to json for mqtt
+	"errors"        // creating custom err messages
+	"fmt"           // string formatting
+	"log"           // logging to std out
+	"strings"       // string manipulation
+	"time"          // timeouts
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"   // checksum calculations
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt" // comms with mqtt broker
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+type QPIWSResponse struct {
+	// (a0a1a2a3a4a5a6a7a8a9a10a11a12a13a14a15a16a17a18a19a20a21a22a23a24a25a26a27a28a29a30a31<CRC><cr>
+	InverterFault          bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a1
+	BusOver                bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a2
+	BusUnder               bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a3
+	BusSoftFail            bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a4
+	LineFail               bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a5
+	OPVShort               bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a6
+	InverterVoltageTooLow  bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a7
+	InverterVoltageTooHigh bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a8
+	OverTemperature        bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a9
+	FanLocked              bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a10
+	BatteryVoltageHigh     bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a11
+	BatteryLowAlarm        bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a12
+	BatteryUnderShutdown   bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a14
+	OverLoad               bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a16
+	EEPROMFault            bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a17
+	InverterOverCurrent    bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a18
+	InverterSoftFail       bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a19
+	SelfTestFail           bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a20
+	OPDCVoltageOver        bool   `ha:"op_dc_voltage_over,name=OP DC Voltage Over,device_class=problem,icon=mdi:alert"` // a21
+	BatteryOpen            bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a22
+	CurrentSensorFail      bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a23
+	BatteryShort           bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a24
+	PowerLimit             bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a25
+	PVVoltageHigh          bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a26
+	MPPTOverloadFault      bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a27
+	MPPTOverloadWarning    bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a28
+	BatteryTooLowToCharge  bool   `ha:",device_class=problem,icon=mdi:alert"`                                           // a29
+	Bits                   string `ha:"-"`
+	Checksum               string `ha:"-"`
+}
+
+func SendQPIWS(port phocus_serial.Port, payload interface{}) (int, error) {
+	written, err := port.Write(port.Port, "QPIWS")
+	if err != nil {
+		return -1, err
+	} else {
+		fmt.Printf("Wrote QPIWS of %d bytes\n", written)
+		return written, nil
+	}
+}
+
+func ReceiveQPIWS(port phocus_serial.Port, timeout time.Duration) (string, error) {
+	response, err := port.Read(port.Port, timeout)
+	log.Printf("%s\n", response)
+	if err != nil || response == "" {
+		log.Printf("Failed to read from serial with: %v\n", err)
+		return "", err
+	} else {
+		return VerifyQPIWS(response)
+	}
+}
+
+func VerifyQPIWS(response string) (string, error) {
+	if phocus_crc.Verify(response) {
+		return response, nil
+	} else {
+		if len(response) < 3 {
+			return "", fmt.Errorf("response not long enough: %s", response)
+		}
+		actual := response[len(response)-3 : len(response)-1] // 2 bytes of crc
+		remainder := response[:len(response)-3]               // actual response
+		wanted := phocus_crc.Checksum(remainder)              // response calculated on response data
+		message := fmt.Sprintf("invalid response from QPIWS: CRC should have been %x but was %x", wanted, actual)
+		log.Println(message)
+		return "", errors.New(message)
+	}
+}
+
+// InterpretQPIWS parses the warning status bits where a 1 means that the
+// warning is active. Some firmware sends more than 32 bits but only the
+// first 32 are documented so the rest are only kept in Bits
+func InterpretQPIWS(input string) (*QPIWSResponse, error) {
+	if input == "" {
+		return nil, errors.New("can't create a response from an empty string")
+	} else if len(input) < 4 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	bits := strings.TrimPrefix(input[:len(input)-3], "(")
+	checksum := input[len(input)-3 : len(input)-1]
+	log.Printf("Bits: %v\n", bits)
+	log.Printf("Checksum: %x\n", checksum)
+	wantedLength := 32
+	if len(bits) < wantedLength {
+		return nil, fmt.Errorf("input for QPIWSResponse was %v bits but should have been at least %v", len(bits), wantedLength)
+	}
+	if strings.Trim(bits, "01") != "" {
+		return nil, fmt.Errorf("input for QPIWSResponse should only be bits but was %s", bits)
+	}
+	active := func(bit int) bool { return bits[bit] == '1' }
+	return &QPIWSResponse{
+		InverterFault:          active(1),
+		BusOver:                active(2),
+		BusUnder:               active(3),
+		BusSoftFail:            active(4),
+		LineFail:               active(5),
+		OPVShort:               active(6),
+		InverterVoltageTooLow:  active(7),
+		InverterVoltageTooHigh: active(8),
+		OverTemperature:        active(9),
+		FanLocked:              active(10),
+		BatteryVoltageHigh:     active(11),
+		BatteryLowAlarm:        active(12),
+		BatteryUnderShutdown:   active(14),
+		OverLoad:               active(16),
+		EEPROMFault:            active(17),
+		InverterOverCurrent:    active(18),
+		InverterSoftFail:       active(19),
+		SelfTestFail:           active(20),
+		OPDCVoltageOver:        active(21),
+		BatteryOpen:            active(22),
+		CurrentSensorFail:      active(23),
+		BatteryShort:           active(24),
+		PowerLimit:             active(25),
+		PVVoltageHigh:          active(26),
+		MPPTOverloadFault:      active(27),
+		MPPTOverloadWarning:    active(28),
+		BatteryTooLowToCharge:  active(29),
+		Bits:                   bits,
+		Checksum:               fmt.Sprintf("0x%x", checksum),
+	}, nil
+}
+
+func EncodeQPIWS(response *QPIWSResponse) string {
+	jsonQPIWSResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	return string(jsonQPIWSResponse)
+}
+
+func PublishQPIWS(client phocus_mqtt.Client, response *QPIWSResponse) error {
+	jsonResponse := EncodeQPIWS(response)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic("stats/qpiws"), 0, false, jsonResponse, 10)
+	if err != nil {
+		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", "QPIWS", err, jsonResponse)
+	} else {
+		log.Printf("Sent to MQTT:\n%s\n", jsonResponse)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package phocus_messages
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+func TestSendAndReceiveQPIWS(t *testing.T) {
+	var written string
+	n, err := SendQPIWS(fakeQPIGSPort(&written, "", nil), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "QPIWS", written)
+
+	n, err = SendQPIWS(phocus_serial.Port{Write: phocus_serial.Write}, nil)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, phocus_serial.ErrNilPortOnWrite, err)
+
+	response, err := ReceiveQPIWS(fakeQPIGSPort(&written, phocus_crc.Encode("(00000100000000000000000000000000"), nil), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, phocus_crc.Encode("(00000100000000000000000000000000"), response)
+
+	response, err = ReceiveQPIWS(fakeQPIGSPort(&written, "(00000100000000000000000000000000\x01\x02\r", nil), 0)
+	assert.ErrorContains(t, err, "invalid response from QPIWS: CRC should have been")
+	assert.Equal(t, "", response)
+
+	response, err = ReceiveQPIWS(fakeQPIGSPort(&written, "", phocus_serial.ErrReadNothing), 0)
+	assert.Equal(t, phocus_serial.ErrReadNothing, err)
+	assert.Equal(t, "", response)
+}
+
+func TestInterpretQPIWS(t *testing.T) {
+	response, err := InterpretQPIWS(phocus_crc.Encode("(00000100000000000000000000000000"))
+	assert.NoError(t, err)
+	assert.Equal(t, &QPIWSResponse{LineFail: true, Bits: "00000100000000000000000000000000", Checksum: response.Checksum}, response)
+
+	// over temperature, fan locked, EEPROM fault and battery too low to charge with extra bits
+	response, err = InterpretQPIWS(phocus_crc.Encode("(000000000110000001000000000001000000"))
+	assert.NoError(t, err)
+	assert.Equal(t, &QPIWSResponse{
+		OverTemperature:       true,
+		FanLocked:             true,
+		EEPROMFault:           true,
+		BatteryTooLowToCharge: true,
+		Bits:                  "000000000110000001000000000001000000",
+		Checksum:              response.Checksum,
+	}, response)
+
+	response, err = InterpretQPIWS("")
+	assert.EqualError(t, err, "can't create a response from an empty string")
+	assert.Nil(t, response)
+	response, err = InterpretQPIWS("(\r")
+	assert.EqualError(t, err, "response is malformed or shorter than expected")
+	assert.Nil(t, response)
+	response, err = InterpretQPIWS(phocus_crc.Encode("(0000010000"))
+	assert.EqualError(t, err, "input for QPIWSResponse was 10 bits but should have been at least 32")
+	assert.Nil(t, response)
+	response, err = InterpretQPIWS(phocus_crc.Encode("(NAK00100000000000000000000000000"))
+	assert.EqualError(t, err, "input for QPIWSResponse should only be bits but was NAK00100000000000000000000000000")
+	assert.Nil(t, response)
+}
+
+func TestPublishQPIWS(t *testing.T) {
+	response, err := InterpretQPIWS(phocus_crc.Encode("(00000100000000000000000000000000"))
+	assert.NoError(t, err)
+	assert.Contains(t, EncodeQPIWS(response), `"BusSoftFail":false,"LineFail":true,"OPVShort":false`)
+	assert.EqualError(t, PublishQPIWS(nil, response), "client not defined in send")
+
+	var written string
+	port := fakeQPIGSPort(&written, phocus_crc.Encode("(00000100000000000000000000000000"), nil)
+	interpreted, err := Interpret(nil, port, Message{uuid.New(), "QPIWS", ""}, 0)
+	assert.EqualError(t, err, "client not defined in send")
+	assert.Equal(t, response, interpreted)
+}
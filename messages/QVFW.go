@@ -0,0 +1,87 @@
+package phocus_messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+// QVFWResponse is the version of the main CPU firmware of the connected inverter
+type QVFWResponse struct {
+	Version string `ha:"-"` // sent as the sw_version of the device of the inverter instead
+}
+
+func SendQVFW(port phocus_serial.Port, payload interface{}) (int, error) {
+	written, err := port.Write(port.Port, "QVFW")
+	if err != nil {
+		return -1, err
+	} else {
+		fmt.Printf("Wrote QVFW of %d bytes\n", written)
+		return written, nil
+	}
+}
+
+func ReceiveQVFW(port phocus_serial.Port, timeout time.Duration) (string, error) {
+	response, err := port.Read(port.Port, timeout)
+	log.Printf("%s\n", response)
+	if err != nil || response == "" {
+		log.Printf("Failed to read from serial with: %v\n", err)
+		return "", err
+	} else {
+		return VerifyQVFW(response)
+	}
+}
+
+func VerifyQVFW(response string) (string, error) {
+	if phocus_crc.Verify(response) {
+		log.Printf("Firmware version queried: %s\n", response)
+		return response, nil
+	} else {
+		if len(response) < 3 {
+			return "", fmt.Errorf("response not long enough: %s", response)
+		}
+		actual := response[len(response)-3 : len(response)-1] // 2 bytes of crc
+		remainder := response[:len(response)-3]               // actual response
+		wanted := phocus_crc.Checksum(remainder)              // response calculated on response data
+		message := fmt.Sprintf("invalid response from QVFW: CRC should have been %x but was %x", wanted, actual)
+		log.Println(message)
+		return "", errors.New(message)
+	}
+}
+
+// InterpretQVFW parses a response like (VERFW:00072.70
+func InterpretQVFW(response string) (*QVFWResponse, error) {
+	if response == "" {
+		return nil, errors.New("can't create a response from an empty string")
+	}
+	version, found := strings.CutPrefix(strings.TrimSuffix(response, "\r"), "(VERFW:")
+	if !found || len(version) < 3 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	return &QVFWResponse{
+		Version: version[:len(version)-2],
+	}, nil
+}
+
+func EncodeQVFW(response *QVFWResponse) string {
+	jsonQVFWResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	return string(jsonQVFWResponse)
+}
+
+func PublishQVFW(client phocus_mqtt.Client, response *QVFWResponse) error {
+	jsonResponse := EncodeQVFW(response)
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic("stats/qvfw"), 0, true, jsonResponse, 10)
+	if err != nil {
+		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", "QVFW", err, jsonResponse)
+	} else {
+		log.Printf("Sent to MQTT:\n%s\n", jsonResponse)
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package phocus_messages
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+)
+
+func TestQVFW(t *testing.T) {
+	input := phocus_crc.Encode("(VERFW:00072.70")
+
+	response, err := VerifyQVFW(input)
+	assert.NoError(t, err)
+	assert.Equal(t, input, response)
+
+	_, err = VerifyQVFW("(VERFW:00072.70\x00\x00\r")
+	assert.Error(t, err)
+
+	actual, err := InterpretQVFW(input)
+	assert.NoError(t, err)
+	assert.Equal(t, &QVFWResponse{"00072.70"}, actual)
+	assert.Equal(t, "{\"Version\":\"00072.70\"}", EncodeQVFW(actual))
+
+	actual, err = InterpretQVFW("")
+	assert.Equal(t, errors.New("can't create a response from an empty string"), err)
+	assert.Nil(t, actual)
+
+	actual, err = InterpretQVFW(phocus_crc.Encode("(NAK"))
+	assert.Equal(t, errors.New("response is malformed or shorter than expected"), err)
+	assert.Nil(t, actual)
+
+	assert.EqualError(t, PublishQVFW(nil, &QVFWResponse{"00072.70"}), "client not defined in send")
+}
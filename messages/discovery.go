@@ -0,0 +1,79 @@
+package phocus_messages
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+// Discover probes QPGS0, QPGS1, ... until a unit stops answering or reports no other units
+//
+// QPGS0 reporting no other units means it is a standalone unit, for any later index
+// it means the slot is empty. Units which report a serial number that has already been
+// seen are skipped so that the same physical unit isn't polled twice.
+//
+// Returns the responses of the units that were found in the order that they were probed
+func Discover(port phocus_serial.Port, readTimeout time.Duration, maxUnits int) ([]*QPGSnResponse, error) {
+	var found []*QPGSnResponse
+	seen := map[string]bool{}
+	for inverterNum := 0; inverterNum < maxUnits; inverterNum++ {
+		_, err := SendQPGSn(port, inverterNum)
+		if err != nil {
+			return found, err
+		}
+		response, err := ReceiveQPGSn(port, readTimeout, inverterNum)
+		if err != nil {
+			log.Printf("QPGS%d stopped answering during discovery: %v\n", inverterNum, err)
+			break
+		}
+		QPGSnResponse, err := InterpretQPGSn(response, inverterNum)
+		if err != nil {
+			log.Printf("QPGS%d gave an invalid response during discovery: %v\n", inverterNum, err)
+			break
+		}
+		if !QPGSnResponse.OtherUnits && inverterNum > 0 {
+			break
+		}
+		if !seen[QPGSnResponse.SerialNumber] {
+			seen[QPGSnResponse.SerialNumber] = true
+			found = append(found, QPGSnResponse)
+		}
+		if !QPGSnResponse.OtherUnits {
+			break
+		}
+	}
+	if len(found) == 0 {
+		return nil, errors.New("no inverters answered during discovery")
+	}
+	return found, nil
+}
+
+// InverterNumbers extracts the inverter numbers from a list of QPGSn responses
+func InverterNumbers(responses []*QPGSnResponse) []int {
+	inverters := make([]int, 0, len(responses))
+	for _, response := range responses {
+		inverters = append(inverters, response.InverterNumber)
+	}
+	return inverters
+}
+
+// DiffInverters compares two lists of inverter numbers
+//
+// Returns the inverters that are in current but not in previous and
+// those that are in previous but not in current
+func DiffInverters(previous []int, current []int) (added []int, removed []int) {
+	for _, inverterNum := range current {
+		if !slices.Contains(previous, inverterNum) {
+			added = append(added, inverterNum)
+		}
+	}
+	for _, inverterNum := range previous {
+		if !slices.Contains(current, inverterNum) {
+			removed = append(removed, inverterNum)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,109 @@
+package phocus_messages
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+	"go.bug.st/serial"
+)
+
+// fakeParallelPort answers QPGSn queries from a map of inverter number to
+// whether there are other units and the serial number of the unit
+func fakeParallelPort(units map[int]struct {
+	otherUnits   bool
+	serialNumber string
+}) phocus_serial.Port {
+	var lastCommand string
+	return phocus_serial.Port{
+		Write: func(port serial.Port, input string) (int, error) {
+			lastCommand = input
+			return len(input) + 3, nil
+		},
+		Read: func(port serial.Port, timeout time.Duration) (string, error) {
+			inverterNum, _ := ParseQPGSnCommand(lastCommand)
+			unit, ok := units[inverterNum]
+			if !ok {
+				return "", errors.New("read returned nothing")
+			}
+			otherUnits := "0"
+			if unit.otherUnits {
+				otherUnits = "1"
+			}
+			return phocus_crc.Encode(fmt.Sprintf("(%s %s B 00 237.0 50.01 000.0 00.00 0483 0387 009 51.1 000 069 020.4 000 00942 00792 007 00000010 1 1 060 080 10 00.0 006", otherUnits, unit.serialNumber)), nil
+		},
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	type unit = struct {
+		otherUnits   bool
+		serialNumber string
+	}
+
+	// standalone unit
+	port := fakeParallelPort(map[int]unit{0: {false, "92932004102443"}, 1: {false, "00000000000000"}})
+	responses, err := Discover(port, 0, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, InverterNumbers(responses))
+	assert.Equal(t, "92932004102443", responses[0].SerialNumber)
+
+	// parallel units where QPGS0 is the same unit as QPGS1 and QPGS4 stops answering
+	port = fakeParallelPort(map[int]unit{
+		0: {true, "92932004102443"},
+		1: {true, "92932004102443"},
+		2: {true, "92932004102453"},
+		3: {true, "92932004102463"},
+	})
+	responses, err = Discover(port, 0, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 3}, InverterNumbers(responses))
+
+	// parallel units with an empty slot after them
+	port = fakeParallelPort(map[int]unit{
+		0: {true, "92932004102443"},
+		1: {true, "92932004102453"},
+		2: {false, "00000000000000"},
+		3: {true, "92932004102463"},
+	})
+	responses, err = Discover(port, 0, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, InverterNumbers(responses))
+
+	// limited by the max number of units
+	responses, err = Discover(port, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, InverterNumbers(responses))
+
+	// nothing answering
+	port = fakeParallelPort(map[int]unit{})
+	responses, err = Discover(port, 0, 9)
+	assert.EqualError(t, err, "no inverters answered during discovery")
+	assert.Nil(t, responses)
+
+	// write failing
+	port.Write = func(port serial.Port, input string) (int, error) {
+		return 0, errors.New("port is nil on write")
+	}
+	responses, err = Discover(port, 0, 9)
+	assert.EqualError(t, err, "port is nil on write")
+	assert.Nil(t, responses)
+}
+
+func TestDiffInverters(t *testing.T) {
+	added, removed := DiffInverters([]int{1, 2}, []int{1, 2})
+	assert.Nil(t, added)
+	assert.Nil(t, removed)
+
+	added, removed = DiffInverters([]int{1, 2}, []int{0, 1, 3})
+	assert.Equal(t, []int{0, 3}, added)
+	assert.Equal(t, []int{2}, removed)
+
+	added, removed = DiffInverters(nil, []int{0})
+	assert.Equal(t, []int{0}, added)
+	assert.Nil(t, removed)
+}
@@ -0,0 +1,147 @@
+package phocus_messages
+
+import (
+	"encoding/json" // encoding to json for mqtt
+	"errors"        // creating custom err messages
+	"fmt"           // string formatting
+	"log"           // logging to std out
+	"strconv"       // parsing the counters
+	"strings"       // string manipulation
+	"time"          // dates and timeouts
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"   // checksum calculations
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt" // comms with mqtt broker
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+// EnergyPeriod is a query for the PV energy that the inverter has generated in a period
+type EnergyPeriod struct {
+	Command string // ie QEY
+	Layout  string // layout of the date sent after the command, ie 2006 for QEY2024, empty for QET
+	PerKWh  int    // how much of the counter makes a kWh, 1000 for the Wh of QED
+}
+
+// EnergyPeriods are the energy queries by command
+var EnergyPeriods = map[string]EnergyPeriod{
+	"QET": {Command: "QET", Layout: "", PerKWh: 1},
+	"QEY": {Command: "QEY", Layout: "2006", PerKWh: 1},
+	"QEM": {Command: "QEM", Layout: "200601", PerKWh: 1},
+	"QED": {Command: "QED", Layout: "20060102", PerKWh: 1000},
+}
+
+// EnergyResponse is the PV energy that the inverter has generated in total or in the period of the date
+type EnergyResponse struct {
+	// (NNNNNNNN<CRC><cr>
+	Date     string        `ha:"-"` // ie 20240131 for QED, empty for QET
+	PVEnergy KilowattHours `ha:"pv_energy,name=PV Energy,unit=kWh,precision=2,device_class=energy,state_class=total_increasing,icon=mdi:solar-power"`
+}
+
+// Date is the date of the period that contains the time, ie 202401 for QEM in January 2024
+func (period EnergyPeriod) Date(at time.Time) string {
+	return at.Format(period.Layout)
+}
+
+// Topic is the stats topic for the response with the date, which is the one the sensor
+// reads for the current period and one with the date after it for past periods, ie stats/qem/202401
+func (period EnergyPeriod) Topic(date string, at time.Time) string {
+	topic := fmt.Sprintf("stats/%s", strings.ToLower(period.Command))
+	if date == "" || date == period.Date(at) {
+		return topic
+	}
+	return fmt.Sprintf("%s/%s", topic, date)
+}
+
+// Validate checks that the date is for the period, an empty date is for the current period
+func (period EnergyPeriod) Validate(date string) error {
+	if date == "" {
+		return nil
+	}
+	if _, err := time.Parse(period.Layout, date); err != nil || len(date) != len(period.Layout) {
+		return fmt.Errorf("%s needs a date like %s but got %s", period.Command, period.Date(time.Now()), date)
+	}
+	return nil
+}
+
+// SendEnergy sends the query for the period with the date, which is today's if it is empty
+//
+// Returns the date that was sent
+func SendEnergy(port phocus_serial.Port, period EnergyPeriod, date string) (string, error) {
+	if err := period.Validate(date); err != nil {
+		return "", err
+	}
+	if date == "" {
+		date = period.Date(time.Now())
+	}
+	written, err := port.Write(port.Port, period.Command+date)
+	if err != nil {
+		return "", err
+	} else {
+		fmt.Printf("Wrote %s%s of %d bytes\n", period.Command, date, written)
+		return date, nil
+	}
+}
+
+func ReceiveEnergy(port phocus_serial.Port, timeout time.Duration, period EnergyPeriod) (string, error) {
+	response, err := port.Read(port.Port, timeout)
+	log.Printf("%s\n", response)
+	if err != nil || response == "" {
+		log.Printf("Failed to read from serial with: %v\n", err)
+		return "", err
+	} else {
+		return VerifyEnergy(response, period)
+	}
+}
+
+func VerifyEnergy(response string, period EnergyPeriod) (string, error) {
+	if phocus_crc.Verify(response) {
+		return response, nil
+	} else {
+		if len(response) < 3 {
+			return "", fmt.Errorf("response not long enough: %s", response)
+		}
+		actual := response[len(response)-3 : len(response)-1] // 2 bytes of crc
+		remainder := response[:len(response)-3]               // actual response
+		wanted := phocus_crc.Checksum(remainder)              // response calculated on response data
+		message := fmt.Sprintf("invalid response from %s: CRC should have been %x but was %x", period.Command, wanted, actual)
+		log.Println(message)
+		return "", errors.New(message)
+	}
+}
+
+// InterpretEnergy parses a response like (00012345 into kWh
+func InterpretEnergy(input string, period EnergyPeriod, date string) (*EnergyResponse, error) {
+	if input == "" {
+		return nil, errors.New("can't create a response from an empty string")
+	} else if len(input) < 4 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	counter := strings.TrimPrefix(input[:len(input)-3], "(")
+	energy, err := strconv.ParseUint(counter, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s should be a number but was %q", period.Command, counter)
+	}
+	return &EnergyResponse{
+		Date:     date,
+		PVEnergy: KilowattHours(float64(energy) / float64(period.PerKWh)),
+	}, nil
+}
+
+func EncodeEnergy(response *EnergyResponse) string {
+	jsonEnergyResponse, _ := json.Marshal(response) // err ignored because it can't fail with this input
+	return string(jsonEnergyResponse)
+}
+
+// PublishEnergy sends the response to the topic of its period, past periods aren't retained
+// so that they don't overwrite the total_increasing sensor of the current period
+func PublishEnergy(client phocus_mqtt.Client, response *EnergyResponse, period EnergyPeriod) error {
+	jsonResponse := EncodeEnergy(response)
+	topic := period.Topic(response.Date, time.Now())
+	current := topic == period.Topic("", time.Now())
+	err := phocus_mqtt.Send(client, phocus_mqtt.Topic(topic), 0, current, jsonResponse, 10)
+	if err != nil {
+		log.Printf("MQTT send of %s failed with: %v\ntype of thing sent was: %T", period.Command, err, jsonResponse)
+	} else {
+		log.Printf("Sent to MQTT:\n%s\n", jsonResponse)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package phocus_messages
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+	"go.bug.st/serial"
+)
+
+func TestEnergy(t *testing.T) {
+	at := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "", EnergyPeriods["QET"].Date(at))
+	assert.Equal(t, "2024", EnergyPeriods["QEY"].Date(at))
+	assert.Equal(t, "202401", EnergyPeriods["QEM"].Date(at))
+	assert.Equal(t, "20240131", EnergyPeriods["QED"].Date(at))
+
+	// only the current period is sent to the topic of the sensor
+	assert.Equal(t, "stats/qet", EnergyPeriods["QET"].Topic("", at))
+	assert.Equal(t, "stats/qem", EnergyPeriods["QEM"].Topic("", at))
+	assert.Equal(t, "stats/qem", EnergyPeriods["QEM"].Topic("202401", at))
+	assert.Equal(t, "stats/qey/2023", EnergyPeriods["QEY"].Topic("2023", at))
+	assert.Equal(t, "stats/qed/20240130", EnergyPeriods["QED"].Topic("20240130", at))
+
+	input := phocus_crc.Encode("(00012345")
+	response, err := VerifyEnergy(input, EnergyPeriods["QET"])
+	assert.NoError(t, err)
+	assert.Equal(t, input, response)
+
+	_, err = VerifyEnergy("(00012345\x00\x00\r", EnergyPeriods["QEY"])
+	assert.EqualError(t, err, "invalid response from QEY: CRC should have been eab2 but was 0000")
+
+	actual, err := InterpretEnergy(input, EnergyPeriods["QET"], "")
+	assert.NoError(t, err)
+	assert.Equal(t, &EnergyResponse{Date: "", PVEnergy: 12345}, actual)
+	assert.Equal(t, "{\"Date\":\"\",\"PVEnergy\":12345}", EncodeEnergy(actual))
+
+	// the day is counted in Wh
+	actual, err = InterpretEnergy(input, EnergyPeriods["QED"], "20240131")
+	assert.NoError(t, err)
+	assert.Equal(t, &EnergyResponse{Date: "20240131", PVEnergy: 12.345}, actual)
+
+	actual, err = InterpretEnergy("", EnergyPeriods["QET"], "")
+	assert.Equal(t, errors.New("can't create a response from an empty string"), err)
+	assert.Nil(t, actual)
+
+	// units without the counters refuse the query
+	actual, err = InterpretEnergy(phocus_crc.Encode("(NAK"), EnergyPeriods["QEM"], "202401")
+	assert.EqualError(t, err, "QEM should be a number but was \"NAK\"")
+	assert.Nil(t, actual)
+
+	assert.EqualError(t, PublishEnergy(nil, &EnergyResponse{PVEnergy: 1}, EnergyPeriods["QET"]), "client not defined in send")
+}
+
+func TestSendEnergy(t *testing.T) {
+	var written []string
+	port := phocus_serial.Port{
+		Write: func(port serial.Port, input string) (int, error) {
+			written = append(written, input)
+			return len(input) + 3, nil
+		},
+		Read: func(port serial.Port, timeout time.Duration) (string, error) {
+			return phocus_crc.Encode("(00000456"), nil
+		},
+	}
+
+	date, err := SendEnergy(port, EnergyPeriods["QEM"], "202401")
+	assert.NoError(t, err)
+	assert.Equal(t, "202401", date)
+
+	// the current period is queried without a date
+	date, err = SendEnergy(port, EnergyPeriods["QEY"], "")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Now().Format("2006"), date)
+
+	date, err = SendEnergy(port, EnergyPeriods["QET"], "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", date)
+	assert.Equal(t, []string{"QEM202401", "QEY" + time.Now().Format("2006"), "QET"}, written)
+
+	// dates that don't match the period aren't sent
+	_, err = SendEnergy(port, EnergyPeriods["QED"], "202401")
+	assert.EqualError(t, err, "QED needs a date like "+time.Now().Format("20060102")+" but got 202401")
+	_, err = SendEnergy(port, EnergyPeriods["QEM"], "202413")
+	assert.Error(t, err)
+	_, err = SendEnergy(port, EnergyPeriods["QET"], "2024")
+	assert.Error(t, err)
+	assert.Len(t, written, 3)
+
+	// through Interpret with the date as the payload
+	response, err := Interpret(nil, port, Message{uuid.New(), "QEM", "202312"}, 0)
+	assert.EqualError(t, err, "client not defined in send")
+	assert.Equal(t, &EnergyResponse{Date: "202312", PVEnergy: 456}, response)
+	assert.Equal(t, "QEM202312", written[3])
+}
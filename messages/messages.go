@@ -4,6 +4,7 @@
 package phocus_messages
 
 import (
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,71 +20,160 @@ type Message struct {
 }
 
 // Interpret converts the generic `phocus` message into a specific inverter message
+//
+// Returns the typed response (ie *QPGSnResponse, *QIDResponse or *QPIRIResponse) for queries
+// whose responses are kept, otherwise nil
 // TODO add even more generalisation and separated implementation details here
 func Interpret(
 	client phocus_mqtt.Client,
 	port phocus_serial.Port,
 	input Message,
 	readTimeout time.Duration,
-) (*QPGSnResponse, error) {
-	switch input.Command {
-	case "QPGS1":
+) (any, error) {
+	inverterNum, isQPGSn := ParseQPGSnCommand(input.Command)
+	setter, value, isSetter := ParseSetter(input.Command, input.Payload)
+	period, isEnergy := EnergyPeriods[input.Command]
+	switch {
+	case isQPGSn:
 		// send
-		_, err := SendQPGSn(port, 1)
+		_, err := SendQPGSn(port, inverterNum)
 		if err != nil {
 			return nil, err
 		}
 		// receive
-		response, err := ReceiveQPGSn(port, readTimeout, 1)
+		response, err := ReceiveQPGSn(port, readTimeout, inverterNum)
 		if err != nil {
 			return nil, err
 		} else {
 			// interpret/handle
-			QPGSnResponse, err := InterpretQPGSn(response, 1)
+			QPGSnResponse, err := InterpretQPGSn(response, inverterNum)
 			if err != nil {
 				return nil, err
 			}
 			// publish stuff here
-			return QPGSnResponse, PublishQPGSn(client, QPGSnResponse, 1)
+			return QPGSnResponse, PublishQPGSn(client, QPGSnResponse, inverterNum)
 		}
-	case "QPGS2":
+	case input.Command == "QID":
 		// send
-		_, err := SendQPGSn(port, 2)
+		_, err := SendQID(port, nil)
 		if err != nil {
 			return nil, err
 		}
 		// receive
-		response, err := ReceiveQPGSn(port, readTimeout, 2)
+		response, err := ReceiveQID(port, readTimeout)
 		if err != nil {
 			return nil, err
 		} else {
 			// interpret/handle
-			QPGSnResponse, err := InterpretQPGSn(response, 2)
+			QIDResponse, err := InterpretQID(response)
 			if err != nil {
 				return nil, err
 			}
 			// publish stuff here
-			return QPGSnResponse, PublishQPGSn(client, QPGSnResponse, 2)
+			return QIDResponse, PublishQID(client, QIDResponse)
 		}
-	case "QID":
+	case input.Command == "QVFW":
 		// send
-		_, err := SendQID(port, nil)
+		_, err := SendQVFW(port, nil)
 		if err != nil {
 			return nil, err
 		}
 		// receive
-		response, err := ReceiveQID(port, readTimeout)
+		response, err := ReceiveQVFW(port, readTimeout)
 		if err != nil {
 			return nil, err
 		} else {
 			// interpret/handle
-			QIDResponse, err := InterpretQID(response)
+			QVFWResponse, err := InterpretQVFW(response)
+			if err != nil {
+				return nil, err
+			}
+			// publish stuff here
+			return QVFWResponse, PublishQVFW(client, QVFWResponse)
+		}
+	case input.Command == "QPIGS":
+		// send
+		_, err := SendQPIGS(port, nil)
+		if err != nil {
+			return nil, err
+		}
+		// receive
+		response, err := ReceiveQPIGS(port, readTimeout)
+		if err != nil {
+			return nil, err
+		} else {
+			// interpret/handle
+			QPIGSResponse, err := InterpretQPIGS(response)
+			if err != nil {
+				return nil, err
+			}
+			// publish stuff here
+			return QPIGSResponse, PublishQPIGS(client, QPIGSResponse)
+		}
+	case input.Command == "QPIRI":
+		// send
+		_, err := SendQPIRI(port, nil)
+		if err != nil {
+			return nil, err
+		}
+		// receive
+		response, err := ReceiveQPIRI(port, readTimeout)
+		if err != nil {
+			return nil, err
+		} else {
+			// interpret/handle
+			QPIRIResponse, err := InterpretQPIRI(response)
 			if err != nil {
 				return nil, err
 			}
 			// publish stuff here
-			return nil, PublishQID(client, QIDResponse)
+			return QPIRIResponse, PublishQPIRI(client, QPIRIResponse)
 		}
+	case input.Command == "QPIWS":
+		// send
+		_, err := SendQPIWS(port, nil)
+		if err != nil {
+			return nil, err
+		}
+		// receive
+		response, err := ReceiveQPIWS(port, readTimeout)
+		if err != nil {
+			return nil, err
+		} else {
+			// interpret/handle
+			QPIWSResponse, err := InterpretQPIWS(response)
+			if err != nil {
+				return nil, err
+			}
+			// publish stuff here
+			return QPIWSResponse, PublishQPIWS(client, QPIWSResponse)
+		}
+	case isEnergy:
+		// send
+		date, err := SendEnergy(port, period, input.Payload)
+		if err != nil {
+			return nil, err
+		}
+		// receive
+		response, err := ReceiveEnergy(port, readTimeout, period)
+		if err != nil {
+			return nil, err
+		} else {
+			// interpret/handle
+			EnergyResponse, err := InterpretEnergy(response, period, date)
+			if err != nil {
+				return nil, err
+			}
+			// publish stuff here
+			return EnergyResponse, PublishEnergy(client, EnergyResponse, period)
+		}
+	case isSetter:
+		// validate, send, receive and check for ACK/NAK
+		setterResponse, err := Set(client, port, setter, value, readTimeout)
+		if setterResponse == nil {
+			return nil, err
+		}
+		return setterResponse, err
 	default:
 		// generic handling (not suitable for complicated queries)
 		// send
@@ -106,3 +196,17 @@ func Interpret(
 		}
 	}
 }
+
+// enumerate returns the values of the codes of an enumerated field ordered by their codes
+func enumerate[T ~string](codes map[string]T) []string {
+	keys := make([]string, 0, len(codes))
+	for key := range codes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, string(codes[key]))
+	}
+	return values
+}
@@ -58,6 +58,10 @@ func TestMesages(t *testing.T) {
 		assert.EqualError(t, err, "port is nil on write")
 		assert.Nil(t, qpgsnresponse)
 
+		qpgsnresponse, err = Interpret(client, port1, Message{uuid.New(), "QPGS3", ""}, 0*time.Second)
+		assert.EqualError(t, err, "port is nil on write")
+		assert.Nil(t, qpgsnresponse)
+
 		qpgsnresponse, err = Interpret(client, port1, Message{uuid.New(), "QID", ""}, 0*time.Second)
 		assert.EqualError(t, err, "port is nil on write")
 		assert.Nil(t, qpgsnresponse)
@@ -101,26 +105,27 @@ func TestMesages(t *testing.T) {
 		}
 		qpgsnresponse, err := Interpret(client, port1, Message{uuid.New(), "QPGS1", ""}, 0*time.Second)
 		assert.EqualError(t, err, "client not defined in send")
-		assert.Equal(t, QPGSnResponse{InverterNumber: 1,
+		assert.Equal(t, QPGSnResponse{Version: QPGSnVersion,
+			InverterNumber:                      1,
 			OtherUnits:                          true,
 			SerialNumber:                        "92932004102443",
 			OperationMode:                       "Off-grid",
 			FaultCode:                           "",
-			ACInputVoltage:                      "237.0",
-			ACInputFrequency:                    "50.01",
-			ACOutputVoltage:                     "000.0",
-			ACOutputFrequency:                   "00.00",
-			ACOutputApparentPower:               "0483",
-			ACOutputActivePower:                 "0387",
-			PercentageOfNominalOutputPower:      "009",
-			BatteryVoltage:                      "51.1",
-			BatteryChargingCurrent:              "000",
-			BatteryStateOfCharge:                "069",
-			PVInputVoltage:                      "020.4",
-			TotalChargingCurrent:                "000",
-			TotalACOutputApparentPower:          "00942",
-			TotalACOutputActivePower:            "00792",
-			TotalPercentageOfNominalOutputPower: "007",
+			ACInputVoltage:                      237.0,
+			ACInputFrequency:                    50.01,
+			ACOutputVoltage:                     0,
+			ACOutputFrequency:                   0,
+			ACOutputApparentPower:               483,
+			ACOutputActivePower:                 387,
+			PercentageOfNominalOutputPower:      9,
+			BatteryVoltage:                      51.1,
+			BatteryChargingCurrent:              0,
+			BatteryStateOfCharge:                69,
+			PVInputVoltage:                      20.4,
+			TotalChargingCurrent:                0,
+			TotalACOutputApparentPower:          942,
+			TotalACOutputActivePower:            792,
+			TotalPercentageOfNominalOutputPower: 7,
 			InverterStatus: InverterStatus{MPPT: "off",
 				ACCharging:    "off",
 				SolarCharging: "off",
@@ -130,13 +135,13 @@ func TestMesages(t *testing.T) {
 				Reserved:      "0"},
 			ACOutputMode:                 "Parallel output",
 			BatteryChargerSourcePriority: "Solar first",
-			MaxChargingCurrentSet:        "060",
-			MaxChargingCurrentPossible:   "080",
-			MaxACChargingCurrentSet:      "10",
-			PVInputCurrent:               "00.0",
-			BatteryDischargeCurrent:      "006",
+			MaxChargingCurrentSet:        60,
+			MaxChargingCurrentPossible:   80,
+			MaxACChargingCurrentSet:      10,
+			PVInputCurrent:               0,
+			BatteryDischargeCurrent:      6,
 			Checksum:                     "0xf22d"},
-			*qpgsnresponse,
+			*qpgsnresponse.(*QPGSnResponse),
 		)
 
 		port1.Read = func(port serial.Port, timeout time.Duration) (string, error) {
@@ -144,26 +149,27 @@ func TestMesages(t *testing.T) {
 		}
 		qpgsnresponse, err = Interpret(client, port1, Message{uuid.New(), "QPGS2", ""}, 0*time.Second)
 		assert.EqualError(t, err, "client not defined in send")
-		assert.Equal(t, QPGSnResponse{InverterNumber: 2,
+		assert.Equal(t, QPGSnResponse{Version: QPGSnVersion,
+			InverterNumber:                      2,
 			OtherUnits:                          true,
 			SerialNumber:                        "92932004102453",
 			OperationMode:                       "Off-grid",
 			FaultCode:                           "",
-			ACInputVoltage:                      "237.0",
-			ACInputFrequency:                    "50.01",
-			ACOutputVoltage:                     "000.0",
-			ACOutputFrequency:                   "00.00",
-			ACOutputApparentPower:               "0483",
-			ACOutputActivePower:                 "0387",
-			PercentageOfNominalOutputPower:      "009",
-			BatteryVoltage:                      "51.1",
-			BatteryChargingCurrent:              "000",
-			BatteryStateOfCharge:                "069",
-			PVInputVoltage:                      "020.4",
-			TotalChargingCurrent:                "000",
-			TotalACOutputApparentPower:          "00942",
-			TotalACOutputActivePower:            "00792",
-			TotalPercentageOfNominalOutputPower: "007",
+			ACInputVoltage:                      237.0,
+			ACInputFrequency:                    50.01,
+			ACOutputVoltage:                     0,
+			ACOutputFrequency:                   0,
+			ACOutputApparentPower:               483,
+			ACOutputActivePower:                 387,
+			PercentageOfNominalOutputPower:      9,
+			BatteryVoltage:                      51.1,
+			BatteryChargingCurrent:              0,
+			BatteryStateOfCharge:                69,
+			PVInputVoltage:                      20.4,
+			TotalChargingCurrent:                0,
+			TotalACOutputApparentPower:          942,
+			TotalACOutputActivePower:            792,
+			TotalPercentageOfNominalOutputPower: 7,
 			InverterStatus: InverterStatus{MPPT: "off",
 				ACCharging:    "off",
 				SolarCharging: "off",
@@ -173,13 +179,13 @@ func TestMesages(t *testing.T) {
 				Reserved:      "0"},
 			ACOutputMode:                 "Parallel output",
 			BatteryChargerSourcePriority: "Solar first",
-			MaxChargingCurrentSet:        "060",
-			MaxChargingCurrentPossible:   "080",
-			MaxACChargingCurrentSet:      "10",
-			PVInputCurrent:               "00.0",
-			BatteryDischargeCurrent:      "006",
+			MaxChargingCurrentSet:        60,
+			MaxChargingCurrentPossible:   80,
+			MaxACChargingCurrentSet:      10,
+			PVInputCurrent:               0,
+			BatteryDischargeCurrent:      6,
 			Checksum:                     "0x9f50"},
-			*qpgsnresponse,
+			*qpgsnresponse.(*QPGSnResponse),
 		)
 
 		port1.Read = func(port serial.Port, timeout time.Duration) (string, error) {
@@ -187,7 +193,7 @@ func TestMesages(t *testing.T) {
 		}
 		qpgsnresponse, err = Interpret(client, port1, Message{uuid.New(), "QID", ""}, 0*time.Second)
 		assert.EqualError(t, err, "client not defined in send")
-		assert.Nil(t, qpgsnresponse)
+		assert.Equal(t, &QIDResponse{SerialNumber: "92932004102453"}, qpgsnresponse)
 
 		port1.Read = func(port serial.Port, timeout time.Duration) (string, error) {
 			return "SOME_RESPONSE\xb2\xb2\r", nil
@@ -0,0 +1,269 @@
+package phocus_messages
+
+import (
+	"errors"  // creating custom err messages
+	"fmt"     // string formatting
+	"log"     // logging to std out
+	"sort"    // listing setters
+	"strconv" // parsing values
+	"strings" // string manipulation
+	"time"    // timeouts
+
+	phocus_mqtt "github.com/wolffshots/phocus/v2/mqtt" // comms with mqtt broker
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+// ErrInvalidSetting is returned when the value for a setter is out of range or not one of its options
+var ErrInvalidSetting = errors.New("invalid setting")
+
+// NAKError is returned when the inverter refuses a command with (NAK
+type NAKError struct {
+	Command string
+	Payload string
+}
+
+func (err *NAKError) Error() string {
+	return fmt.Sprintf("inverter refused %s%s with NAK", err.Command, err.Payload)
+}
+
+// Setter is a command which changes a setting on the inverter
+//
+// Enum settings have Options which map what is sent to what it means and
+// numeric settings are checked against Min and Max and sent using Format
+type Setter struct {
+	Command     string
+	Description string
+	Options     map[string]string `json:",omitempty"`
+	Min         float64           `json:",omitempty"`
+	Max         float64           `json:",omitempty"`
+	AllowZero   bool              `json:",omitempty"` // 0 is also allowed outside of Min and Max
+	Format      string            `json:"-"`
+	Parallel    bool              `json:",omitempty"` // payload is prefixed with the inverter number as "n:value"
+}
+
+// flags are the options that can be enabled with PE and disabled with PD
+var flags = map[string]string{
+	"a": "Buzzer",
+	"b": "Overload bypass",
+	"j": "Power saving",
+	"k": "LCD return to default",
+	"u": "Overload restart",
+	"v": "Over temperature restart",
+	"x": "Backlight",
+	"y": "Alarm on primary source interrupt",
+	"z": "Fault code record",
+}
+
+// Setters are the setter commands that are validated before being sent
+var Setters = map[string]Setter{
+	"POP": {
+		Command:     "POP",
+		Description: "Output source priority",
+		Options:     map[string]string{"00": "Utility first", "01": "Solar first", "02": "SBU first"},
+	},
+	"PCP": {
+		Command:     "PCP",
+		Description: "Charger source priority",
+		Options:     map[string]string{"00": "Utility first", "01": "Solar first", "02": "Solar and Utility", "03": "Solar only"},
+	},
+	"MCHGC": {
+		Command:     "MCHGC",
+		Description: "Max charging current in A",
+		Min:         10,
+		Max:         150,
+		Format:      "%03.0f",
+	},
+	"MNCHGC": {
+		Command:     "MNCHGC",
+		Description: "Max charging current in A of a unit in parallel",
+		Min:         10,
+		Max:         150,
+		Format:      "%03.0f",
+		Parallel:    true,
+	},
+	"MUCHGC": {
+		Command:     "MUCHGC",
+		Description: "Max AC charging current in A",
+		Min:         2,
+		Max:         120,
+		Format:      "%03.0f",
+	},
+	"PBCV": {
+		Command:     "PBCV",
+		Description: "Voltage to go back to the grid at in V",
+		Min:         44,
+		Max:         51,
+		Format:      "%04.1f",
+	},
+	"PBDV": {
+		Command:     "PBDV",
+		Description: "Voltage to go back to discharging at in V, 0 for when the battery is full",
+		Min:         48,
+		Max:         58,
+		AllowZero:   true,
+		Format:      "%04.1f",
+	},
+	"PBT": {
+		Command:     "PBT",
+		Description: "Battery type",
+		Options:     map[string]string{"00": "AGM", "01": "Flooded", "02": "User"},
+	},
+	"PBFT": {
+		Command:     "PBFT",
+		Description: "Battery float voltage in V",
+		Min:         48,
+		Max:         58.4,
+		Format:      "%04.1f",
+	},
+	"PCVV": {
+		Command:     "PCVV",
+		Description: "Battery bulk (constant voltage) voltage in V",
+		Min:         48,
+		Max:         58.4,
+		Format:      "%04.1f",
+	},
+	"PE": {
+		Command:     "PE",
+		Description: "Enable a flag",
+		Options:     flags,
+	},
+	"PD": {
+		Command:     "PD",
+		Description: "Disable a flag",
+		Options:     flags,
+	},
+}
+
+// ListSetters returns the Setters sorted by command
+func ListSetters() []Setter {
+	setters := make([]Setter, 0, len(Setters))
+	for _, setter := range Setters {
+		setters = append(setters, setter)
+	}
+	sort.Slice(setters, func(i, j int) bool { return setters[i].Command < setters[j].Command })
+	return setters
+}
+
+// ParseSetter finds the Setter for a message, either with the value in the
+// payload (POP and 01) or already appended to the command (POP01)
+//
+// Returns false if the command isn't a known setter, including commands that
+// start like one but don't end with a value that fits it (PEaxPDbjk)
+func ParseSetter(command string, payload string) (Setter, string, bool) {
+	if setter, found := Setters[command]; found {
+		return setter, payload, true
+	}
+	if payload != "" {
+		return Setter{}, "", false
+	}
+	for prefix, setter := range Setters {
+		if value, found := strings.CutPrefix(command, prefix); found && setter.fits(value) {
+			return setter, value, true
+		}
+	}
+	return Setter{}, "", false
+}
+
+// fits checks if a value appended to the command looks like one for the setter,
+// either one of its options or a number, without checking that it is in range
+func (setter Setter) fits(value string) bool {
+	if setter.Parallel {
+		unit, rest, found := strings.Cut(value, ":")
+		if !found || len(unit) != 1 || unit[0] < '0' || unit[0] > '9' {
+			return false
+		}
+		value = rest
+	}
+	if setter.Options != nil {
+		_, found := setter.Options[value]
+		return found
+	}
+	return value != "" && strings.Trim(value, "0123456789.") == ""
+}
+
+// Validate checks a value for the setter and returns the payload to send with the command
+//
+// Enum values can be given as the option (01) or its meaning (Solar first)
+func (setter Setter) Validate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	unit := ""
+	if setter.Parallel {
+		var found bool
+		unit, value, found = strings.Cut(value, ":")
+		if !found || len(unit) != 1 || unit[0] < '0' || unit[0] > '9' {
+			return "", fmt.Errorf("%w: %s needs the inverter number and value as n:value but got %q", ErrInvalidSetting, setter.Command, unit+value)
+		}
+	}
+	if setter.Options != nil {
+		if _, found := setter.Options[value]; found {
+			return unit + value, nil
+		}
+		for option, meaning := range setter.Options {
+			if strings.EqualFold(meaning, value) {
+				return unit + option, nil
+			}
+		}
+		return "", fmt.Errorf("%w: %q is not an option for %s", ErrInvalidSetting, value, setter.Command)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not a number for %s", ErrInvalidSetting, value, setter.Command)
+	}
+	if !(setter.AllowZero && number == 0) && (number < setter.Min || number > setter.Max) {
+		return "", fmt.Errorf("%w: %s should be between %v and %v but was %v", ErrInvalidSetting, setter.Command, setter.Min, setter.Max, number)
+	}
+	return unit + fmt.Sprintf(setter.Format, number), nil
+}
+
+// SetterResponse is the outcome of a setter command
+type SetterResponse struct {
+	Command string
+	Payload string
+	Result  string
+}
+
+// InterpretSetter checks whether the inverter acknowledged a setter command
+//
+// Returns a *NAKError if it was refused
+func InterpretSetter(response string, command string, payload string) (*SetterResponse, error) {
+	if len(response) < 4 {
+		return nil, errors.New("response is malformed or shorter than expected")
+	}
+	result := strings.TrimPrefix(response[:len(response)-3], "(")
+	switch result {
+	case "ACK":
+		return &SetterResponse{Command: command, Payload: payload, Result: result}, nil
+	case "NAK":
+		return &SetterResponse{Command: command, Payload: payload, Result: result}, &NAKError{Command: command, Payload: payload}
+	default:
+		return nil, fmt.Errorf("unexpected response to %s%s: %s", command, payload, result)
+	}
+}
+
+// Set validates, sends and checks the response of a setter command
+//
+// The result is published in the same place as generic responses
+func Set(client phocus_mqtt.Client, port phocus_serial.Port, setter Setter, value string, readTimeout time.Duration) (*SetterResponse, error) {
+	payload, err := setter.Validate(value)
+	if err != nil {
+		return nil, err
+	}
+	_, err = SendGeneric(port, setter.Command, payload)
+	if err != nil {
+		return nil, err
+	}
+	response, err := ReceiveGeneric(port, setter.Command, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	setterResponse, err := InterpretSetter(response, setter.Command, payload)
+	if setterResponse == nil {
+		return nil, err
+	}
+	log.Printf("%s%s was answered with %s\n", setter.Command, payload, setterResponse.Result)
+	pubErr := PublishGeneric(client, &GenericResponse{Result: setterResponse.Result}, setter.Command)
+	if err != nil {
+		return setterResponse, err
+	}
+	return setterResponse, pubErr
+}
@@ -0,0 +1,131 @@
+package phocus_messages
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+)
+
+func TestParseSetter(t *testing.T) {
+	setter, value, found := ParseSetter("POP", "01")
+	assert.True(t, found)
+	assert.Equal(t, "POP", setter.Command)
+	assert.Equal(t, "01", value)
+
+	setter, value, found = ParseSetter("MUCHGC030", "")
+	assert.True(t, found)
+	assert.Equal(t, "MUCHGC", setter.Command)
+	assert.Equal(t, "030", value)
+
+	_, _, found = ParseSetter("QPIGS", "")
+	assert.False(t, found)
+	_, _, found = ParseSetter("POP", "")
+	assert.True(t, found)
+	_, _, found = ParseSetter("POP01", "01")
+	assert.False(t, found)
+
+	// values that are out of range still fit so that they are rejected instead of sent
+	setter, value, found = ParseSetter("PBCV70.0", "")
+	assert.True(t, found)
+	assert.Equal(t, "PBCV", setter.Command)
+	assert.Equal(t, "70.0", value)
+	setter, value, found = ParseSetter("MNCHGC1:060", "")
+	assert.True(t, found)
+	assert.Equal(t, "MNCHGC", setter.Command)
+	assert.Equal(t, "1:060", value)
+
+	// but commands that only start like a setter are generic commands
+	for _, command := range []string{"PEaxPDbjk", "PDbjk", "POP1", "PBCVx", "MNCHGC060", "PCVVInf"} {
+		_, _, found = ParseSetter(command, "")
+		assert.False(t, found, command)
+	}
+}
+
+func TestValidateSetter(t *testing.T) {
+	tests := []struct {
+		command string
+		value   string
+		want    string
+		invalid bool
+	}{
+		{"POP", "01", "01", false},
+		{"POP", "sbu first", "02", false},
+		{"POP", "03", "", true},
+		{"PCP", "Solar only", "03", false},
+		{"PBT", "00", "00", false},
+		{"PBT", "Lithium", "", true},
+		{"MCHGC", "60", "060", false},
+		{"MCHGC", "5", "", true},
+		{"MCHGC", "sixty", "", true},
+		{"MNCHGC", "1:60", "1060", false},
+		{"MNCHGC", "60", "", true},
+		{"MNCHGC", "a:60", "", true},
+		{"MUCHGC", "2", "002", false},
+		{"MUCHGC", "121", "", true},
+		{"PBCV", "46", "46.0", false},
+		{"PBCV", "52", "", true},
+		{"PBDV", "0", "00.0", false},
+		{"PBDV", "54.5", "54.5", false},
+		{"PBDV", "40", "", true},
+		{"PBFT", "54", "54.0", false},
+		{"PCVV", "58.4", "58.4", false},
+		{"PCVV", "58.5", "", true},
+		{"PE", "buzzer", "a", false},
+		{"PD", "x", "x", false},
+		{"PD", "c", "", true},
+	}
+	for _, test := range tests {
+		payload, err := Setters[test.command].Validate(test.value)
+		if test.invalid {
+			assert.ErrorIs(t, err, ErrInvalidSetting, "%s %s", test.command, test.value)
+		} else {
+			assert.NoError(t, err, "%s %s", test.command, test.value)
+		}
+		assert.Equal(t, test.want, payload, "%s %s", test.command, test.value)
+	}
+}
+
+func TestInterpretSetter(t *testing.T) {
+	response, err := InterpretSetter(phocus_crc.Encode("(ACK"), "POP", "01")
+	assert.NoError(t, err)
+	assert.Equal(t, &SetterResponse{Command: "POP", Payload: "01", Result: "ACK"}, response)
+
+	response, err = InterpretSetter(phocus_crc.Encode("(NAK"), "POP", "01")
+	var nak *NAKError
+	assert.True(t, errors.As(err, &nak))
+	assert.Equal(t, "inverter refused POP01 with NAK", err.Error())
+	assert.Equal(t, "NAK", response.Result)
+
+	response, err = InterpretSetter(phocus_crc.Encode("(BUSY"), "POP", "01")
+	assert.Nil(t, response)
+	assert.EqualError(t, err, "unexpected response to POP01: BUSY")
+
+	response, err = InterpretSetter("(", "POP", "01")
+	assert.Nil(t, response)
+	assert.EqualError(t, err, "response is malformed or shorter than expected")
+}
+
+func TestSet(t *testing.T) {
+	var written string
+	response, err := Interpret(nil, fakeQPIGSPort(&written, phocus_crc.Encode("(ACK"), nil), Message{ID: uuid.New(), Command: "PBFT", Payload: "54"}, time.Second)
+	assert.EqualError(t, err, "client not defined in send")
+	assert.Equal(t, "PBFT54.0", written)
+	assert.Equal(t, &SetterResponse{Command: "PBFT", Payload: "54.0", Result: "ACK"}, response)
+
+	written = ""
+	response, err = Interpret(nil, fakeQPIGSPort(&written, phocus_crc.Encode("(NAK"), nil), Message{ID: uuid.New(), Command: "POP02", Payload: ""}, time.Second)
+	var nak *NAKError
+	assert.True(t, errors.As(err, &nak))
+	assert.Equal(t, "POP02", written)
+	assert.Equal(t, "NAK", response.(*SetterResponse).Result)
+
+	written = ""
+	response, err = Interpret(nil, fakeQPIGSPort(&written, phocus_crc.Encode("(ACK"), nil), Message{ID: uuid.New(), Command: "PBFT", Payload: "80"}, time.Second)
+	assert.ErrorIs(t, err, ErrInvalidSetting)
+	assert.Equal(t, "", written) // nothing is sent if the value is invalid
+	assert.True(t, response == nil)
+}
@@ -0,0 +1,56 @@
+package phocus_messages
+
+import (
+	"errors"  // joining the errors of the fields
+	"fmt"     // error formatting
+	"strconv" // parsing numbers
+)
+
+// Volts is a voltage in V
+type Volts float64
+
+// Hertz is a frequency in Hz
+type Hertz float64
+
+// Amps is a current in A
+type Amps float64
+
+// Watts is an active power in W
+type Watts int
+
+// VoltAmps is an apparent power in VA
+type VoltAmps int
+
+// KilowattHours is an energy in kWh
+type KilowattHours float64
+
+// Percent is a percentage of something, ie the nominal output power or the battery capacity
+type Percent int
+
+// numbers parses the numeric fields of a response and collects an error for each malformed one
+type numbers struct {
+	errs []error
+}
+
+// float parses a field like 020.4
+func (parsed *numbers) float(name string, value string) float64 {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		parsed.errs = append(parsed.errs, fmt.Errorf("%s should be a number but was %q", name, value))
+	}
+	return number
+}
+
+// int parses a field like 00942
+func (parsed *numbers) int(name string, value string) int {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		parsed.errs = append(parsed.errs, fmt.Errorf("%s should be a whole number but was %q", name, value))
+	}
+	return number
+}
+
+// err is nil if every field was a number or else the errors of the malformed ones
+func (parsed *numbers) err() error {
+	return errors.Join(parsed.errs...)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"  // string formatting
 	"log"  // logging to stdout
 	"os"   // verbose logging
+	"sync" // guarding subscriptions
 	"time" // current time and timeouts
 
 	mqtt "github.com/eclipse/paho.mqtt.golang" // mqtt client
@@ -14,24 +15,62 @@ import (
 
 type Client mqtt.Client
 
-var CreateClient = func(hostname string, port int, retries int, clientId string) (mqtt.Client, error) {
-	var client mqtt.Client
-	// start mqtt setup
-	mqtt.ERROR = log.New(os.Stdout, "[ERROR] ", 0)
-	mqtt.CRITICAL = log.New(os.Stdout, "[CRIT] ", 0)
-	mqtt.WARN = log.New(os.Stdout, "[WARN]  ", 0)
-	mqtt.DEBUG = log.New(os.Stdout, "[DEBUG] ", 0)
-	var mqttBroker = hostname
-	var mqttPort = port
-	var err error
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Subscription is a topic to listen to and the handler for the payloads received on it
+type Subscription struct {
+	Topic   string
+	Handler func(client Client, payload string)
+}
+
+// subscriptions are subscribed to on every (re)connect since the session isn't kept by the broker
+var (
+	subscriptions      []Subscription
+	subscriptionsMutex sync.Mutex
+)
+
+// clientOptions are the options for connecting to the broker with the
+// offline availability set as the will
+func clientOptions(hostname string, port int, clientId string, security Security) (*mqtt.ClientOptions, error) {
+	broker, encrypted, err := brokerURL(hostname, port)
+	if err != nil {
+		return nil, err
+	}
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", mqttBroker, mqttPort))
+	opts.AddBroker(broker)
 	opts.SetClientID(clientId)
+	opts.SetUsername(security.Username)
+	opts.SetPassword(security.Password)
+	if encrypted {
+		config, err := tlsConfig(security.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(config)
+	}
 	opts.SetDefaultPublishHandler(messagePublishedHandler)
 	opts.OnConnect = connectionHandler
 	opts.OnConnectionLost = connectionLostHandler
+	opts.SetWill(AvailabilityTopic(), Offline, 0, true)
 	opts.AutoReconnect = true
 	opts.SetPingTimeout(5 * time.Second)
+	return opts, nil
+}
+
+var CreateClient = func(hostname string, port int, retries int, clientId string, security Security) (mqtt.Client, error) {
+	var client mqtt.Client
+	// start mqtt setup
+	mqtt.ERROR = log.New(os.Stdout, "[ERROR] ", 0)
+	mqtt.CRITICAL = log.New(os.Stdout, "[CRIT] ", 0)
+	mqtt.WARN = log.New(os.Stdout, "[WARN]  ", 0)
+	mqtt.DEBUG = log.New(os.Stdout, "[DEBUG] ", 0)
+	opts, err := clientOptions(hostname, port, clientId, security)
+	if err != nil {
+		return nil, err
+	}
 	for i := 0; i < retries; i++ {
 		client = mqtt.NewClient(opts)
 		token := client.Connect()
@@ -48,16 +87,21 @@ var CreateClient = func(hostname string, port int, retries int, clientId string)
 	return client, err
 }
 
-// Setup sets the logging and opens a connection to the broker
-func Setup(hostname string, port int, retries int, clientId string) (mqtt.Client, error) {
-	client, err := CreateClient(hostname, port, retries, clientId)
+// Setup sets the logging and opens a connection to the broker, authenticating
+// with security, which then subscribes to the topics of the subscriptions
+func Setup(hostname string, port int, retries int, clientId string, security Security, newSubscriptions ...Subscription) (mqtt.Client, error) {
+	subscriptionsMutex.Lock()
+	subscriptions = newSubscriptions
+	subscriptionsMutex.Unlock()
+
+	client, err := CreateClient(hostname, port, retries, clientId, security)
 
 	if err != nil {
 		return nil, err // i explicitly make client nil
 	}
 
 	// time needs to be formatted as iso8601 and rfc3339 is the closest to that
-	err = Send(client, "phocus/stats/start_time", 0, false, time.Now().Format(time.RFC3339), 10)
+	err = Send(client, Topic("stats/start_time"), 0, false, time.Now().Format(time.RFC3339), 10)
 	if err != nil {
 		log.Printf("Failed to send initial setup stats to mqtt with err: %v", err)
 	}
@@ -81,9 +125,27 @@ func Send(client mqtt.Client, topic string, qos byte, retained bool, payload int
 	return err
 }
 
+// subscribe subscribes to the topics of all of the subscriptions
+func subscribe(client mqtt.Client) error {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	for _, subscription := range subscriptions {
+		handler := subscription.Handler
+		token := client.Subscribe(subscription.Topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+			handler(client, string(msg.Payload()))
+		})
+		token.WaitTimeout(10 * time.Second)
+		if err := token.Error(); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", subscription.Topic, err)
+		}
+		log.Printf("Subscribed to %s\n", subscription.Topic)
+	}
+	return nil
+}
+
 // Error publishes a caught error to the error stat
 func Error(client mqtt.Client, qos byte, retained bool, payload error, timeout time.Duration) error {
-	err := Send(client, "phocus/stats/error", qos, retained, fmt.Sprint(payload), timeout)
+	err := Send(client, Topic("stats/error"), qos, retained, fmt.Sprint(payload), timeout)
 	return err
 }
 
@@ -106,6 +168,14 @@ var connectionHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 		log.Println("Client is nil in connectionHandler")
 	} else {
 		log.Println("Connected")
+		err := Send(client, AvailabilityTopic(), 0, true, Online, 10)
+		if err != nil {
+			log.Printf("Failed to send availability: %v\n", err)
+		}
+		err = subscribe(client)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 }
 
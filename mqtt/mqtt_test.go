@@ -26,6 +26,7 @@ func TestSetup(t *testing.T) {
 		1883,
 		5,
 		"test_client_name",
+		Security{},
 	)
 	assert.Equal(t, errors.New("no servers defined to connect to"), err)
 	assert.Equal(t, nil, client)
@@ -45,6 +46,7 @@ func TestSetup(t *testing.T) {
 		1883,
 		5,
 		"test_client_name",
+		Security{},
 	)
 	assert.Equal(t, errors.New("network Error : dial tcp 127.0.0.1:1883: connect: connection refused"), err)
 	assert.Equal(t, nil, client)
@@ -59,7 +61,10 @@ func TestSetup(t *testing.T) {
 
 	buf.Reset()
 
-	CreateClient = func(hostname string, port, retries int, clientId string) (mqtt.Client, error) {
+	defer func(createClient func(string, int, int, string, Security) (mqtt.Client, error)) {
+		CreateClient = createClient
+	}(CreateClient)
+	CreateClient = func(hostname string, port, retries int, clientId string, security Security) (mqtt.Client, error) {
 		return nil, nil
 	}
 
@@ -68,6 +73,7 @@ func TestSetup(t *testing.T) {
 		1883,
 		5,
 		"test_client_name",
+		Security{},
 	)
 	assert.Equal(t, errors.New("client not defined in send"), err)
 	assert.Equal(t, nil, client)
@@ -188,7 +194,9 @@ func TestConnectionHandler(t *testing.T) {
 
 	connectionHandler(client)
 	assert.True(t, len(buf.String()) > 20)
-	assert.Equal(t, "Connected\n", buf.String()[20:])
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "Connected", lines[0][20:])
+	assert.Equal(t, "Failed to send availability: client not connected in send", lines[1][20:])
 }
 
 func TestConnectionLostHandler(t *testing.T) {
@@ -216,3 +224,48 @@ func TestConnectionLostHandler(t *testing.T) {
 	assert.True(t, len(buf.String()) > 20)
 	assert.Equal(t, "Connection lost: some error\n", buf.String()[20:])
 }
+
+func TestSubscribe(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(os.Stderr)
+	}()
+	defer func() { subscriptions = nil }()
+
+	subscriptions = []Subscription{{Topic: "phocus/command/test", Handler: func(client Client, payload string) {}}}
+
+	opts := mqtt.NewClientOptions()
+	client := mqtt.NewClient(opts)
+	err := subscribe(client)
+	assert.EqualError(t, err, "failed to subscribe to phocus/command/test: not Connected")
+
+	connectionHandler(client)
+	assert.Equal(t, "Connected\n", strings.Split(buf.String(), "\n")[0][20:]+"\n")
+	assert.Contains(t, buf.String(), "failed to subscribe to phocus/command/test: not Connected")
+
+	subscriptions = nil
+	assert.NoError(t, subscribe(client))
+}
+
+func TestClientOptions(t *testing.T) {
+	opts, err := clientOptions("127.0.0.1", 1883, "test_client_name", Security{Username: "phocus", Password: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:1883", opts.Servers[0].String())
+	assert.Equal(t, "test_client_name", opts.ClientID)
+	assert.True(t, opts.WillEnabled)
+	assert.Equal(t, "phocus/availability", opts.WillTopic)
+	assert.Equal(t, []byte(Offline), opts.WillPayload)
+	assert.True(t, opts.WillRetained)
+	assert.Equal(t, "phocus", opts.Username)
+	assert.Equal(t, "secret", opts.Password)
+	assert.Nil(t, opts.TLSConfig)
+
+	opts, err = clientOptions("wss://127.0.0.1/mqtt", 8884, "test_client_name", Security{TLS: TLS{InsecureSkipVerify: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, "wss://127.0.0.1:8884/mqtt", opts.Servers[0].String())
+	assert.True(t, opts.TLSConfig.InsecureSkipVerify)
+
+	_, err = clientOptions("ssl://127.0.0.1", 8883, "test_client_name", Security{TLS: TLS{CertFile: "client.crt"}})
+	assert.EqualError(t, err, "both a client certificate and key are needed")
+}
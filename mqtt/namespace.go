@@ -0,0 +1,79 @@
+package phocus_mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Namespace is where the topics of this instance of phocus are so
+// that several instances can share a broker without colliding
+type Namespace struct {
+	BaseTopic       string // prefix of the state and command topics, defaults to phocus
+	DiscoveryPrefix string // prefix Home Assistant listens on for discovery, defaults to homeassistant
+	InstanceID      string // added to the topics, unique ids and device identifiers when set
+}
+
+// namespace is the Namespace used by all of the topics
+var namespace = Namespace{BaseTopic: "phocus", DiscoveryPrefix: "homeassistant"}
+
+// SetNamespace changes where all of the topics are, filling in the defaults for what isn't set
+func SetNamespace(newNamespace Namespace) error {
+	if newNamespace.BaseTopic == "" {
+		newNamespace.BaseTopic = "phocus"
+	}
+	if newNamespace.DiscoveryPrefix == "" {
+		newNamespace.DiscoveryPrefix = "homeassistant"
+	}
+	newNamespace.BaseTopic = strings.Trim(newNamespace.BaseTopic, "/")
+	newNamespace.DiscoveryPrefix = strings.Trim(newNamespace.DiscoveryPrefix, "/")
+	for _, character := range newNamespace.InstanceID {
+		if !(character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' || character >= '0' && character <= '9' || character == '_' || character == '-') {
+			return fmt.Errorf("instance id %q should only have letters, numbers, _ and -", newNamespace.InstanceID)
+		}
+	}
+	if strings.ContainsAny(newNamespace.BaseTopic+newNamespace.DiscoveryPrefix, "+#") {
+		return fmt.Errorf("topics can't have wildcards but were %s and %s", newNamespace.BaseTopic, newNamespace.DiscoveryPrefix)
+	}
+	namespace = newNamespace
+	return nil
+}
+
+// Topic is a topic under the base topic and instance, ie stats/qpgs1
+// becomes phocus/stats/qpgs1 or phocus/site_a/stats/qpgs1
+func Topic(suffix string) string {
+	if namespace.InstanceID == "" {
+		return fmt.Sprintf("%s/%s", namespace.BaseTopic, suffix)
+	}
+	return fmt.Sprintf("%s/%s/%s", namespace.BaseTopic, namespace.InstanceID, suffix)
+}
+
+// NodeID identifies this instance in discovery topics, unique ids and devices
+func NodeID() string {
+	if namespace.InstanceID == "" {
+		return "phocus"
+	}
+	return fmt.Sprintf("phocus_%s", namespace.InstanceID)
+}
+
+// UniqueID is the unique id of an entity of this instance, ie phocus_site_a_qid_serial
+func UniqueID(objectID string) string {
+	return fmt.Sprintf("%s_%s", NodeID(), objectID)
+}
+
+// DiscoveryTopic is where the config of an entity is sent for Home Assistant
+// where entity is the component and object id, ie sensor/qid_serial
+func DiscoveryTopic(entity string) string {
+	component, objectID, _ := strings.Cut(entity, "/")
+	return fmt.Sprintf("%s/%s/%s/%s/config", namespace.DiscoveryPrefix, component, NodeID(), objectID)
+}
+
+// StatusTopic is where Home Assistant sends online when it starts
+func StatusTopic() string {
+	return fmt.Sprintf("%s/status", namespace.DiscoveryPrefix)
+}
+
+// AvailabilityTopic is where phocus sends Online once connected and
+// where the broker sends Offline for it if the connection is lost
+func AvailabilityTopic() string {
+	return Topic("availability")
+}
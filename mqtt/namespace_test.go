@@ -0,0 +1,31 @@
+package phocus_mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	defer func() { _ = SetNamespace(Namespace{}) }()
+
+	assert.NoError(t, SetNamespace(Namespace{}))
+	assert.Equal(t, "phocus/stats/qpgs1", Topic("stats/qpgs1"))
+	assert.Equal(t, "phocus/availability", AvailabilityTopic())
+	assert.Equal(t, "phocus", NodeID())
+	assert.Equal(t, "phocus_qid_serial", UniqueID("qid_serial"))
+	assert.Equal(t, "homeassistant/sensor/phocus/qid_serial/config", DiscoveryTopic("sensor/qid_serial"))
+	assert.Equal(t, "homeassistant/status", StatusTopic())
+
+	assert.NoError(t, SetNamespace(Namespace{BaseTopic: "solar/", DiscoveryPrefix: "ha", InstanceID: "site_a"}))
+	assert.Equal(t, "solar/site_a/stats/qpgs1", Topic("stats/qpgs1"))
+	assert.Equal(t, "solar/site_a/availability", AvailabilityTopic())
+	assert.Equal(t, "phocus_site_a", NodeID())
+	assert.Equal(t, "phocus_site_a_qid_serial", UniqueID("qid_serial"))
+	assert.Equal(t, "ha/binary_sensor/phocus_site_a/qpiws_bus_over/config", DiscoveryTopic("binary_sensor/qpiws_bus_over"))
+	assert.Equal(t, "ha/status", StatusTopic())
+
+	assert.EqualError(t, SetNamespace(Namespace{InstanceID: "site a"}), "instance id \"site a\" should only have letters, numbers, _ and -")
+	assert.EqualError(t, SetNamespace(Namespace{BaseTopic: "phocus/#"}), "topics can't have wildcards but were phocus/# and homeassistant")
+	assert.Equal(t, "solar/site_a/stats/qpgs1", Topic("stats/qpgs1")) // unchanged by the invalid namespaces
+}
@@ -0,0 +1,89 @@
+package phocus_mqtt
+
+import (
+	"crypto/tls"  // encrypted connections
+	"crypto/x509" // ca bundles
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TLS is where to find the certificates for connecting to the broker over ssl:// or wss://
+type TLS struct {
+	CAFile             string // bundle to verify the broker with instead of the system roots
+	CertFile           string // client certificate for brokers that require one
+	KeyFile            string // key of the client certificate
+	InsecureSkipVerify bool   // don't verify the broker at all, only for testing in a lab
+}
+
+// Security is how to authenticate with the broker
+type Security struct {
+	Username string
+	Password string
+	TLS      TLS
+}
+
+// schemes are the schemes that the broker can be connected to with and whether they are encrypted
+var schemes = map[string]bool{
+	"tcp":   false,
+	"mqtt":  false,
+	"ws":    false,
+	"ssl":   true,
+	"tls":   true,
+	"mqtts": true,
+	"wss":   true,
+}
+
+// brokerURL creates the URL of the broker from a hostname which can
+// start with a scheme (ie ssl://broker.local), defaulting to tcp://
+//
+// Returns whether the scheme is encrypted
+func brokerURL(hostname string, port int) (string, bool, error) {
+	if !strings.Contains(hostname, "://") {
+		return fmt.Sprintf("tcp://%s:%d", hostname, port), false, nil
+	}
+	broker, err := url.Parse(hostname)
+	if err != nil {
+		return "", false, err
+	}
+	encrypted, known := schemes[broker.Scheme]
+	if !known {
+		return "", false, fmt.Errorf("unknown scheme %s for the mqtt broker", broker.Scheme)
+	}
+	if broker.Port() == "" {
+		broker.Host = net.JoinHostPort(broker.Hostname(), strconv.Itoa(port))
+	}
+	return broker.String(), encrypted, nil
+}
+
+// tlsConfig loads the certificates for an encrypted connection
+func tlsConfig(settings TLS) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	if settings.CAFile != "" {
+		bundle, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.CAFile)
+		}
+	}
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return nil, errors.New("both a client certificate and key are needed")
+	}
+	if settings.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
@@ -0,0 +1,197 @@
+package phocus_mqtt
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerURL(t *testing.T) {
+	tests := []struct {
+		hostname  string
+		want      string
+		encrypted bool
+		err       string
+	}{
+		{"192.168.1.1", "tcp://192.168.1.1:1883", false, ""},
+		{"tcp://192.168.1.1", "tcp://192.168.1.1:1883", false, ""},
+		{"ssl://broker.local", "ssl://broker.local:1883", true, ""},
+		{"ssl://broker.local:8883", "ssl://broker.local:8883", true, ""},
+		{"ws://broker.local/mqtt", "ws://broker.local:1883/mqtt", false, ""},
+		{"wss://broker.local:443/mqtt", "wss://broker.local:443/mqtt", true, ""},
+		{"http://broker.local", "", false, "unknown scheme http for the mqtt broker"},
+	}
+	for _, test := range tests {
+		broker, encrypted, err := brokerURL(test.hostname, 1883)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.want, broker, test.hostname)
+		assert.Equal(t, test.encrypted, encrypted, test.hostname)
+	}
+}
+
+// certificate creates a certificate signed by parent (or self signed if parent
+// is nil) and writes it and its key to dir as name.crt and name.key
+func certificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	parsed, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return parsed, key
+}
+
+// readString reads a length prefixed string from an MQTT packet
+func readString(body []byte) (string, []byte) {
+	length := binary.BigEndian.Uint16(body)
+	return string(body[2 : 2+length]), body[2+length:]
+}
+
+// fakeBroker accepts connections on listener and answers CONNECT with
+// accepted only if the username and password match, then discards the rest
+func fakeBroker(listener net.Listener, username string, password string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			packetType, err := reader.ReadByte()
+			if err != nil || packetType>>4 != 1 {
+				return
+			}
+			length, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return
+			}
+			_, rest := readString(body) // protocol name
+			flags := rest[1]
+			rest = rest[4:]            // level, flags and keep alive
+			_, rest = readString(rest) // client id
+			if flags&0x04 != 0 {
+				_, rest = readString(rest) // will topic
+				_, rest = readString(rest) // will message
+			}
+			var gotUsername, gotPassword string
+			if flags&0x80 != 0 {
+				gotUsername, rest = readString(rest)
+			}
+			if flags&0x40 != 0 {
+				gotPassword, _ = readString(rest)
+			}
+			returnCode := byte(0)
+			if gotUsername != username || gotPassword != password {
+				returnCode = 5 // not authorised
+			}
+			_, err = conn.Write([]byte{0x20, 0x02, 0x00, returnCode})
+			if err != nil || returnCode != 0 {
+				return
+			}
+			_, _ = io.Copy(io.Discard, reader)
+		}(conn)
+	}
+}
+
+func TestCreateClientWithTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := certificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	certificate(t, dir, "broker", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	certificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	brokerCertificate, err := tls.LoadX509KeyPair(filepath.Join(dir, "broker.crt"), filepath.Join(dir, "broker.key"))
+	assert.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{brokerCertificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	assert.NoError(t, err)
+	defer listener.Close()
+	go fakeBroker(listener, "phocus", "secret")
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	security := Security{
+		Username: "phocus",
+		Password: "secret",
+		TLS: TLS{
+			CAFile:   filepath.Join(dir, "ca.crt"),
+			CertFile: filepath.Join(dir, "client.crt"),
+			KeyFile:  filepath.Join(dir, "client.key"),
+		},
+	}
+	client, err := CreateClient("ssl://127.0.0.1", port, 1, "test_client_name", security)
+	assert.NoError(t, err)
+	assert.True(t, client.IsConnected())
+	client.Disconnect(0)
+
+	security.Password = "wrong"
+	client, err = CreateClient("ssl://127.0.0.1", port, 1, "test_client_name", security)
+	assert.EqualError(t, err, "not Authorized")
+	assert.False(t, client.IsConnected())
+
+	// the broker isn't trusted without the ca
+	security.Password = "secret"
+	security.TLS.CAFile = ""
+	_, err = CreateClient("ssl://127.0.0.1", port, 1, "test_client_name", security)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "certificate"), err.Error())
+
+	security.TLS.InsecureSkipVerify = true
+	client, err = CreateClient("ssl://127.0.0.1", port, 1, "test_client_name", security)
+	assert.NoError(t, err)
+	client.Disconnect(0)
+
+	security.TLS.CAFile = filepath.Join(dir, "client.key")
+	_, err = CreateClient("ssl://127.0.0.1", port, 1, "test_client_name", security)
+	assert.EqualError(t, err, "no certificates found in "+filepath.Join(dir, "client.key"))
+}
@@ -0,0 +1,253 @@
+package phocus_sensors
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wolffshots/ha_types/units"
+	messages "github.com/wolffshots/phocus/v2/messages"
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"
+)
+
+// Control is a select, number or switch in Home Assistant which changes a
+// setting on the inverter by queuing a setter command
+type Control struct {
+	Component string                                        // "select", "number" or "switch"
+	Key       string                                        // "output_source_priority" used in the topics and unique id
+	Name      string                                        // "Output Source Priority"
+	Icon      string                                        // "mdi:transmission-tower"
+	Command   string                                        // setter command, or the command to switch on for a switch
+	Off       string                                        // setter command to switch off for a switch
+	Payload   string                                        // value sent with the command of a switch, ie the flag
+	Step      float64                                       // step of a number
+	Unit      units.Unit                                    // unit of a number
+	Setting   func(settings *messages.QPIRIResponse) string // current state from QPIRI if it is reported there
+}
+
+// controls are the controls which are registered along with the sensors
+var controls = []Control{
+	{
+		Component: "select",
+		Key:       "output_source_priority",
+		Name:      "Output Source Priority",
+		Icon:      "mdi:transmission-tower",
+		Command:   "POP",
+		Setting: func(settings *messages.QPIRIResponse) string {
+			return string(settings.OutputSourcePriority)
+		},
+	},
+	{
+		Component: "select",
+		Key:       "charger_source_priority",
+		Name:      "Charger Source Priority",
+		Icon:      "mdi:battery-charging",
+		Command:   "PCP",
+		Setting: func(settings *messages.QPIRIResponse) string {
+			return string(settings.ChargerSourcePriority)
+		},
+	},
+	{
+		Component: "number",
+		Key:       "max_charging_current",
+		Name:      "Max Charging Current",
+		Icon:      "mdi:current-dc",
+		Command:   "MCHGC",
+		Step:      10,
+		Unit:      units.Current,
+		Setting: func(settings *messages.QPIRIResponse) string {
+			state, _ := number(settings.MaxChargingCurrent)
+			return state
+		},
+	},
+	{
+		Component: "switch",
+		Key:       "buzzer",
+		Name:      "Buzzer",
+		Icon:      "mdi:volume-high",
+		Command:   "PE",
+		Off:       "PD",
+		Payload:   "a",
+	},
+}
+
+// number formats a numeric payload like 060 as Home Assistant expects it
+func number(payload string) (string, bool) {
+	value, err := strconv.ParseFloat(payload, 64)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64), true
+}
+
+// ConfigTopic is where the config of the control is sent for discovery
+func (control Control) ConfigTopic() string {
+	return mqtt.DiscoveryTopic(control.Component + "/" + control.Key)
+}
+
+// CommandTopic is where Home Assistant sends new values for the control
+func (control Control) CommandTopic() string {
+	return mqtt.Topic("command/" + control.Key)
+}
+
+// StateTopic is where the current value of the control is sent
+func (control Control) StateTopic() string {
+	return mqtt.Topic("state/" + control.Key)
+}
+
+// Options are the options of a select in the order of the setter's options
+func (control Control) Options() []string {
+	setter := messages.Setters[control.Command]
+	keys := make([]string, 0, len(setter.Options))
+	for key := range setter.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	options := make([]string, 0, len(keys))
+	for _, key := range keys {
+		options = append(options, setter.Options[key])
+	}
+	return options
+}
+
+// FormatControl creates the config of a control for Home Assistant under the device
+func FormatControl(control Control, device Device) string {
+	log.Printf("Registering %s\n", control.Name)
+
+	payload := newPayload(control.Key, control.Name, control.Icon, device)
+	payload.CommandTopic = control.CommandTopic()
+	payload.StateTopic = control.StateTopic()
+	payload.EntityCategory = "config"
+	switch control.Component {
+	case "select":
+		payload.Options = control.Options()
+	case "number":
+		setter := messages.Setters[control.Command]
+		step := control.Step
+		payload.Min, payload.Max, payload.Step = &setter.Min, &setter.Max, &step
+		payload.Mode = "box"
+		payload.UnitOfMeasurement = string(control.Unit)
+	}
+	return payload.String()
+}
+
+// Message converts a value received on the command topic into the message to queue
+func (control Control) Message(payload string) (messages.Message, error) {
+	if control.Component != "switch" {
+		return messages.Message{ID: uuid.New(), Command: control.Command, Payload: payload}, nil
+	}
+	switch payload {
+	case "ON":
+		return messages.Message{ID: uuid.New(), Command: control.Command, Payload: control.Payload}, nil
+	case "OFF":
+		return messages.Message{ID: uuid.New(), Command: control.Off, Payload: control.Payload}, nil
+	default:
+		return messages.Message{}, fmt.Errorf("%w: %q is not ON or OFF for %s", messages.ErrInvalidSetting, payload, control.Name)
+	}
+}
+
+// State converts an acknowledged setter back to the state of the control
+//
+// Returns false if the setter doesn't change this control
+func (control Control) State(response *messages.SetterResponse) (string, bool) {
+	switch control.Component {
+	case "switch":
+		if response.Payload != control.Payload {
+			return "", false
+		} else if response.Command == control.Command {
+			return "ON", true
+		} else if response.Command == control.Off {
+			return "OFF", true
+		}
+		return "", false
+	case "select":
+		if response.Command != control.Command {
+			return "", false
+		}
+		state, found := messages.Setters[control.Command].Options[response.Payload]
+		return state, found
+	default:
+		if response.Command != control.Command {
+			return "", false
+		}
+		return number(response.Payload)
+	}
+}
+
+// RegisterControls adds the controls to Home Assistant MQTT
+// once the serial number of the connected inverter is known
+func RegisterControls(client mqtt.Client) error {
+	device, known := ConnectedDevice()
+	if !known {
+		log.Println("Not registering controls until the serial number is known")
+		return nil
+	}
+	log.Println("Registering controls")
+	for _, control := range controls {
+		err := mqtt.Send(client, control.ConfigTopic(), 0, true, FormatControl(control, device), 10)
+		if err != nil {
+			log.Printf("Failed to send control config to MQTT with err: %v", err)
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// Subscriptions listen on the command topics of the controls and pass
+// the messages that the values are converted to on to enqueue
+func Subscriptions(enqueue func(message messages.Message) error) []mqtt.Subscription {
+	subscriptions := make([]mqtt.Subscription, 0, len(controls))
+	for _, control := range controls {
+		subscriptions = append(subscriptions, mqtt.Subscription{
+			Topic: control.CommandTopic(),
+			Handler: func(client mqtt.Client, payload string) {
+				log.Printf("Received %s for %s\n", payload, control.Name)
+				message, err := control.Message(payload)
+				if err == nil {
+					err = enqueue(message)
+				}
+				if err != nil {
+					log.Printf("Failed to queue %s for %s: %v\n", payload, control.Name, err)
+					pubErr := mqtt.Error(client, 0, true, err, 10)
+					if pubErr != nil {
+						log.Printf("Failed to post previous error (%v) to mqtt: %v\n", err, pubErr)
+					}
+				}
+			},
+		})
+	}
+	return subscriptions
+}
+
+// EchoState sends the new state of the controls changed by an acknowledged setter
+func EchoState(client mqtt.Client, response *messages.SetterResponse) error {
+	for _, control := range controls {
+		if state, changed := control.State(response); changed {
+			err := mqtt.Send(client, control.StateTopic(), 0, true, state, 10)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PublishControlStates sends the states of the controls that QPIRI reports
+func PublishControlStates(client mqtt.Client, settings *messages.QPIRIResponse) error {
+	for _, control := range controls {
+		if control.Setting == nil {
+			continue
+		}
+		if state := control.Setting(settings); state != "" {
+			err := mqtt.Send(client, control.StateTopic(), 0, true, state, 10)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package phocus_sensors
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+func TestFormatControl(t *testing.T) {
+	for _, control := range controls {
+		var definition map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(FormatControl(control, Bridge("v0.0.0"))), &definition), control.Key)
+		assert.Equal(t, "phocus_"+control.Key, definition["unique_id"])
+		assert.Equal(t, "phocus/command/"+control.Key, definition["command_topic"])
+		assert.Equal(t, "phocus/state/"+control.Key, definition["state_topic"])
+		assert.Equal(t, "phocus/availability", definition["availability"].([]any)[0].(map[string]any)["topic"])
+		assert.Equal(t, "config", definition["entity_category"])
+	}
+
+	assert.JSONEq(t,
+		`{"unique_id":"phocus_output_source_priority","name":"Output Source Priority","command_topic":"phocus/command/output_source_priority","state_topic":"phocus/state/output_source_priority","availability":[{"topic":"phocus/availability","payload_available":"online","payload_not_available":"offline"}],"icon":"mdi:transmission-tower","device":{"name":"phocus","identifiers":["phocus"],"model":"phocus","manufacturer":"phocus","sw_version":"v0.0.0"},"origin":{"name":"phocus","sw_version":"development","support_url":"https://github.com/wolffshots/phocus"},"entity_category":"config","options":["Utility first","Solar first","SBU first"]}`,
+		FormatControl(controls[0], Bridge("v0.0.0")),
+	)
+
+	assert.Equal(t, "homeassistant/select/phocus/output_source_priority/config", controls[0].ConfigTopic())
+	assert.Contains(t, FormatControl(controls[2], Bridge("v0.0.0")), "\"unit_of_measurement\":\"A\"")
+	assert.Contains(t, FormatControl(controls[2], Bridge("v0.0.0")), "\"min\":10,\"max\":150,\"step\":10,\"mode\":\"box\"")
+}
+
+func TestControlMessage(t *testing.T) {
+	message, err := controls[1].Message("Solar only")
+	assert.NoError(t, err)
+	assert.Equal(t, "PCP", message.Command)
+	assert.Equal(t, "Solar only", message.Payload)
+
+	message, err = controls[3].Message("ON")
+	assert.NoError(t, err)
+	assert.Equal(t, "PE", message.Command)
+	assert.Equal(t, "a", message.Payload)
+
+	message, err = controls[3].Message("OFF")
+	assert.NoError(t, err)
+	assert.Equal(t, "PD", message.Command)
+
+	_, err = controls[3].Message("maybe")
+	assert.ErrorIs(t, err, messages.ErrInvalidSetting)
+}
+
+func TestControlState(t *testing.T) {
+	state, changed := controls[0].State(&messages.SetterResponse{Command: "POP", Payload: "02", Result: "ACK"})
+	assert.True(t, changed)
+	assert.Equal(t, "SBU first", state)
+
+	_, changed = controls[0].State(&messages.SetterResponse{Command: "PCP", Payload: "02", Result: "ACK"})
+	assert.False(t, changed)
+
+	state, changed = controls[2].State(&messages.SetterResponse{Command: "MCHGC", Payload: "060", Result: "ACK"})
+	assert.True(t, changed)
+	assert.Equal(t, "60", state)
+
+	state, changed = controls[3].State(&messages.SetterResponse{Command: "PD", Payload: "a", Result: "ACK"})
+	assert.True(t, changed)
+	assert.Equal(t, "OFF", state)
+
+	_, changed = controls[3].State(&messages.SetterResponse{Command: "PD", Payload: "x", Result: "ACK"})
+	assert.False(t, changed)
+
+	settings := &messages.QPIRIResponse{OutputSourcePriority: "Solar first", MaxChargingCurrent: "060"}
+	assert.Equal(t, "Solar first", controls[0].Setting(settings))
+	assert.Equal(t, "60", controls[2].Setting(settings))
+}
+
+func TestSubscriptions(t *testing.T) {
+	var queued []messages.Message
+	subscriptions := Subscriptions(func(message messages.Message) error {
+		queued = append(queued, message)
+		return nil
+	})
+	assert.Equal(t, len(controls), len(subscriptions))
+	assert.Equal(t, "phocus/command/max_charging_current", subscriptions[2].Topic)
+
+	subscriptions[2].Handler(nil, "60")
+	subscriptions[3].Handler(nil, "maybe") // invalid so it is only reported as an error
+	assert.Equal(t, 1, len(queued))
+	assert.Equal(t, "MCHGC", queued[0].Command)
+	assert.Equal(t, "60", queued[0].Payload)
+
+	subscriptions = Subscriptions(func(message messages.Message) error { return errors.New("queue full") })
+	subscriptions[0].Handler(nil, "Solar first")
+
+	assert.EqualError(t, EchoState(nil, &messages.SetterResponse{Command: "POP", Payload: "01", Result: "ACK"}), "client not defined in send")
+	assert.NoError(t, EchoState(nil, &messages.SetterResponse{Command: "QPIRI", Payload: "", Result: "ACK"}))
+	assert.EqualError(t, PublishControlStates(nil, &messages.QPIRIResponse{OutputSourcePriority: "Solar first"}), "client not defined in send")
+	assert.NoError(t, RegisterControls(nil)) // not sent until the connected inverter is known
+	UpdateDevices(&messages.QIDResponse{SerialNumber: "92932004102443"})
+	defer resetInventory()
+	assert.EqualError(t, RegisterControls(nil), "client not defined in send")
+}
@@ -0,0 +1,125 @@
+package phocus_sensors
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	messages "github.com/wolffshots/phocus/v2/messages"
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"
+)
+
+// Device is the shape of the device that entities are grouped under in Home Assistant
+type Device struct {
+	Name         string   `json:"name"`                 // "Inverter 92932004102443"
+	Identifiers  []string `json:"identifiers"`          // ["92932004102443"]
+	Model        string   `json:"model,omitempty"`      // "Off grid 5000W"
+	Manufacturer string   `json:"manufacturer"`         // "Phocos"
+	SWVersion    string   `json:"sw_version,omitempty"` // "00072.70"
+	ViaDevice    string   `json:"via_device,omitempty"` // "phocus", the identifier of the device it is connected through
+}
+
+// Bridge is the device for phocus itself which holds the entities that
+// aren't about a specific inverter and which the inverters are connected through
+func Bridge(version string) Device {
+	return Device{
+		Name:         mqtt.NodeID(),
+		Identifiers:  []string{mqtt.NodeID()},
+		Model:        "phocus",
+		Manufacturer: "phocus",
+		SWVersion:    version,
+	}
+}
+
+// inventory is what is known about the inverters from their responses
+var inventory = struct {
+	sync.Mutex
+	serials   map[int]string // serial numbers of the parallel inverters by QPGSn number
+	connected string         // serial number of the inverter that phocus is connected to from QID
+	model     string         // model from QPIRI, which is the same for all paralleled units
+	firmware  string         // firmware of the connected inverter from QVFW
+}{serials: map[int]string{}}
+
+// UpdateDevices records the serial number, model or firmware in a response
+//
+// Returns true if something changed so that the devices should be registered again
+func UpdateDevices(response any) bool {
+	inventory.Lock()
+	defer inventory.Unlock()
+	switch response := response.(type) {
+	case *messages.QIDResponse:
+		if response == nil || !validSerial(response.SerialNumber) || inventory.connected == response.SerialNumber {
+			return false
+		}
+		inventory.connected = response.SerialNumber
+	case *messages.QPGSnResponse:
+		if response == nil || !validSerial(response.SerialNumber) || inventory.serials[response.InverterNumber] == response.SerialNumber {
+			return false
+		}
+		inventory.serials[response.InverterNumber] = response.SerialNumber
+	case *messages.QPIRIResponse:
+		if response == nil {
+			return false
+		}
+		model := strings.TrimSpace(fmt.Sprintf("%s %sW", response.MachineType, strings.TrimLeft(response.ACOutputRatingActivePower, "0")))
+		if inventory.model == model {
+			return false
+		}
+		inventory.model = model
+	case *messages.QVFWResponse:
+		if response == nil || inventory.firmware == response.Version {
+			return false
+		}
+		inventory.firmware = response.Version
+	default:
+		return false
+	}
+	return true
+}
+
+// validSerial is false for the empty and zeroed serial numbers of missing units
+func validSerial(serial string) bool {
+	return strings.Trim(serial, "0 ") != ""
+}
+
+// inverter is the device of the inverter with the serial number
+//
+// Expects the inventory to be locked
+func inverter(serial string) Device {
+	device := Device{
+		Name:         fmt.Sprintf("Inverter %s", serial),
+		Identifiers:  []string{serial},
+		Model:        inventory.model,
+		Manufacturer: "Phocos",
+		ViaDevice:    mqtt.NodeID(),
+	}
+	if serial == inventory.connected {
+		device.SWVersion = inventory.firmware
+	}
+	return device
+}
+
+// InverterDevice is the device of the parallel inverter polled with QPGSn
+//
+// Returns false if its serial number isn't known yet
+func InverterDevice(inverterNum int) (Device, bool) {
+	inventory.Lock()
+	defer inventory.Unlock()
+	serial, found := inventory.serials[inverterNum]
+	if !found {
+		return Device{}, false
+	}
+	return inverter(serial), true
+}
+
+// ConnectedDevice is the device of the inverter that phocus is connected to
+//
+// Returns false if its serial number isn't known yet
+func ConnectedDevice() (Device, bool) {
+	inventory.Lock()
+	defer inventory.Unlock()
+	if inventory.connected == "" {
+		return Device{}, false
+	}
+	return inverter(inventory.connected), true
+}
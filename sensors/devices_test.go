@@ -0,0 +1,124 @@
+package phocus_sensors
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	messages "github.com/wolffshots/phocus/v2/messages"
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"
+)
+
+// resetInventory forgets all of the inverters between tests
+func resetInventory() {
+	inventory.Lock()
+	defer inventory.Unlock()
+	inventory.serials = map[int]string{}
+	inventory.connected = ""
+	inventory.model = ""
+	inventory.firmware = ""
+}
+
+func TestBridge(t *testing.T) {
+	bridge, err := json.Marshal(Bridge("v0.0.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"name\":\"phocus\",\"identifiers\":[\"phocus\"],\"model\":\"phocus\",\"manufacturer\":\"phocus\",\"sw_version\":\"v0.0.0\"}", string(bridge))
+
+	assert.NoError(t, mqtt.SetNamespace(mqtt.Namespace{InstanceID: "site_a"}))
+	defer func() { _ = mqtt.SetNamespace(mqtt.Namespace{}) }()
+	assert.Equal(t, []string{"phocus_site_a"}, Bridge("v0.0.0").Identifiers)
+}
+
+func TestUpdateDevices(t *testing.T) {
+	defer resetInventory()
+
+	_, known := ConnectedDevice()
+	assert.False(t, known)
+	_, known = InverterDevice(1)
+	assert.False(t, known)
+
+	assert.True(t, UpdateDevices(&messages.QIDResponse{SerialNumber: "92932004102443"}))
+	assert.False(t, UpdateDevices(&messages.QIDResponse{SerialNumber: "92932004102443"}))
+	assert.True(t, UpdateDevices(&messages.QPGSnResponse{InverterNumber: 1, SerialNumber: "92932004102443"}))
+	assert.True(t, UpdateDevices(&messages.QPGSnResponse{InverterNumber: 2, SerialNumber: "92932004102453"}))
+	// missing units report a zeroed serial number
+	assert.False(t, UpdateDevices(&messages.QPGSnResponse{InverterNumber: 3, SerialNumber: "00000000000000"}))
+	// the model is left out until QPIRI is known
+	unknownModel, known := InverterDevice(2)
+	assert.True(t, known)
+	jsonUnknownModel, err := json.Marshal(unknownModel)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"name\":\"Inverter 92932004102453\",\"identifiers\":[\"92932004102453\"],\"manufacturer\":\"Phocos\",\"via_device\":\"phocus\"}", string(jsonUnknownModel))
+	assert.True(t, UpdateDevices(&messages.QPIRIResponse{MachineType: "Off grid", ACOutputRatingActivePower: "5000"}))
+	assert.False(t, UpdateDevices(&messages.QPIRIResponse{MachineType: "Off grid", ACOutputRatingActivePower: "5000"}))
+	assert.True(t, UpdateDevices(&messages.QVFWResponse{Version: "00072.70"}))
+	assert.False(t, UpdateDevices(&messages.SetterResponse{Command: "POP", Payload: "01", Result: "ACK"}))
+	assert.False(t, UpdateDevices(nil))
+
+	connected, known := ConnectedDevice()
+	assert.True(t, known)
+	assert.Equal(t, Device{
+		Name:         "Inverter 92932004102443",
+		Identifiers:  []string{"92932004102443"},
+		Model:        "Off grid 5000W",
+		Manufacturer: "Phocos",
+		SWVersion:    "00072.70",
+		ViaDevice:    "phocus",
+	}, connected)
+
+	first, known := InverterDevice(1)
+	assert.True(t, known)
+	assert.Equal(t, connected, first)
+
+	// only the firmware of the connected inverter is known
+	second, known := InverterDevice(2)
+	assert.True(t, known)
+	jsonSecond, err := json.Marshal(second)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"name\":\"Inverter 92932004102453\",\"identifiers\":[\"92932004102453\"],\"model\":\"Off grid 5000W\",\"manufacturer\":\"Phocos\",\"via_device\":\"phocus\"}", string(jsonSecond))
+
+	_, known = InverterDevice(3)
+	assert.False(t, known)
+
+	var definition map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(Format(InverterSensors(2)[0], second)), &definition))
+	assert.Equal(t, "phocus", definition["device"].(map[string]any)["via_device"])
+}
+
+func TestRegisterKnownInverters(t *testing.T) {
+	defer resetInventory()
+	client := &recordingClient{}
+
+	assert.NoError(t, Register(client, "v0.0.0", []int{1, 2}))
+	assert.Len(t, client.topics, len(sensors))
+
+	UpdateDevices(&messages.QPGSnResponse{InverterNumber: 2, SerialNumber: "92932004102453"})
+	client.topics = nil
+	assert.NoError(t, Register(client, "v0.0.0", []int{1, 2}))
+	assert.Len(t, client.topics, len(sensors)+len(InverterSensors(2))+len(retiredInverterKeys))
+	assert.Contains(t, client.topics, "homeassistant/sensor/phocus/qpgs2_serial/config")
+	assert.NotContains(t, client.topics, "homeassistant/sensor/phocus/qpgs1_serial/config")
+}
+
+// recordingClient is a connected client which records the topics that are sent to
+type recordingClient struct {
+	paho.Client
+	topics []string
+}
+
+func (client *recordingClient) IsConnected() bool { return true }
+
+func (client *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	client.topics = append(client.topics, topic)
+	return sentToken{}
+}
+
+// sentToken is a token for a publish which has already completed
+type sentToken struct{}
+
+func (sentToken) Wait() bool                     { return true }
+func (sentToken) WaitTimeout(time.Duration) bool { return true }
+func (sentToken) Done() <-chan struct{}          { done := make(chan struct{}); close(done); return done }
+func (sentToken) Error() error                   { return nil }
@@ -0,0 +1,82 @@
+package phocus_sensors
+
+import (
+	"encoding/json"
+
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"
+)
+
+// Availability is where Home Assistant finds out whether phocus is online
+type Availability struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+}
+
+// Origin tells Home Assistant what sent the config
+type Origin struct {
+	Name       string `json:"name"`
+	SWVersion  string `json:"sw_version,omitempty"`
+	SupportURL string `json:"support_url,omitempty"`
+}
+
+// Payload is the config of an entity for Home Assistant MQTT discovery
+//
+// Only the fields that apply to the component are set, the rest are left out
+type Payload struct {
+	UniqueID                  string         `json:"unique_id"`
+	Name                      string         `json:"name"`
+	StateTopic                string         `json:"state_topic,omitempty"`
+	CommandTopic              string         `json:"command_topic,omitempty"`
+	JSONAttributesTopic       string         `json:"json_attributes_topic,omitempty"`
+	Availability              []Availability `json:"availability"`
+	Icon                      string         `json:"icon,omitempty"`
+	Device                    Device         `json:"device"`
+	Origin                    Origin         `json:"origin"`
+	EntityCategory            string         `json:"entity_category,omitempty"`
+	UnitOfMeasurement         string         `json:"unit_of_measurement,omitempty"`
+	StateClass                string         `json:"state_class,omitempty"`
+	DeviceClass               string         `json:"device_class,omitempty"`
+	SuggestedDisplayPrecision *int           `json:"suggested_display_precision,omitempty"`
+	ExpireAfter               int            `json:"expire_after,omitempty"`
+	ValueTemplate             string         `json:"value_template,omitempty"`
+	Options                   []string       `json:"options,omitempty"`
+	Min                       *float64       `json:"min,omitempty"`
+	Max                       *float64       `json:"max,omitempty"`
+	Step                      *float64       `json:"step,omitempty"`
+	Mode                      string         `json:"mode,omitempty"`
+}
+
+// version of phocus which is sent as the origin of the configs
+var version = "development"
+
+// SetVersion sets the version of phocus which is sent as the origin of the configs
+func SetVersion(newVersion string) {
+	version = newVersion
+}
+
+// newPayload creates a payload with what is common to all of the entities
+func newPayload(uniqueID string, name string, icon string, device Device) Payload {
+	return Payload{
+		UniqueID: mqtt.UniqueID(uniqueID),
+		Name:     name,
+		Availability: []Availability{{
+			Topic:               mqtt.AvailabilityTopic(),
+			PayloadAvailable:    mqtt.Online,
+			PayloadNotAvailable: mqtt.Offline,
+		}},
+		Icon:   icon,
+		Device: device,
+		Origin: Origin{
+			Name:       "phocus",
+			SWVersion:  version,
+			SupportURL: "https://github.com/wolffshots/phocus",
+		},
+	}
+}
+
+// String marshals the payload, which can't fail since it only has strings, numbers and slices
+func (payload Payload) String() string {
+	jsonPayload, _ := json.Marshal(payload) // err ignored because it can't fail with this input
+	return string(jsonPayload)
+}
@@ -0,0 +1,123 @@
+package phocus_sensors
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allowedKeys are the keys that Home Assistant accepts in the config of each component
+var allowedKeys = map[string][]string{
+	"sensor": {
+		"unique_id", "name", "state_topic", "json_attributes_topic", "availability", "icon", "device", "origin",
+		"entity_category", "unit_of_measurement", "state_class", "device_class", "suggested_display_precision",
+		"expire_after", "value_template", "options",
+	},
+	"binary_sensor": {
+		"unique_id", "name", "state_topic", "json_attributes_topic", "availability", "icon", "device", "origin",
+		"entity_category", "device_class", "expire_after", "value_template",
+	},
+	"select": {
+		"unique_id", "name", "state_topic", "command_topic", "availability", "icon", "device", "origin",
+		"entity_category", "options",
+	},
+	"number": {
+		"unique_id", "name", "state_topic", "command_topic", "availability", "icon", "device", "origin",
+		"entity_category", "unit_of_measurement", "min", "max", "step", "mode",
+	},
+	"switch": {
+		"unique_id", "name", "state_topic", "command_topic", "availability", "icon", "device", "origin",
+		"entity_category",
+	},
+}
+
+// checkPayload checks that a config is valid JSON that Home Assistant would accept for the component
+func checkPayload(t *testing.T, component string, payload string) {
+	var config map[string]any
+	if !assert.NoError(t, json.Unmarshal([]byte(payload), &config), payload) {
+		return
+	}
+	for key := range config {
+		assert.Contains(t, allowedKeys[component], key, "%s isn't allowed for a %s in %s", key, component, payload)
+	}
+	assert.NotEmpty(t, config["unique_id"])
+	assert.NotEmpty(t, config["state_topic"])
+	assert.NotEmpty(t, config["device"].(map[string]any)["identifiers"])
+	assert.Equal(t, "phocus", config["origin"].(map[string]any)["name"])
+	availability := config["availability"].([]any)[0].(map[string]any)
+	assert.Equal(t, "phocus/availability", availability["topic"])
+	assert.Equal(t, "online", availability["payload_available"])
+	assert.Equal(t, "offline", availability["payload_not_available"])
+
+	switch component {
+	case "sensor", "binary_sensor":
+		// sensors can only be diagnostic, config is for controls
+		if category, found := config["entity_category"]; found {
+			assert.Equal(t, "diagnostic", category, payload)
+		}
+		if config["device_class"] == "enum" {
+			assert.NotEmpty(t, config["options"], payload)
+			assert.NotContains(t, config, "state_class", payload)
+			assert.NotContains(t, config, "unit_of_measurement", payload)
+		}
+		if _, found := config["suggested_display_precision"]; found {
+			assert.Contains(t, config, "unit_of_measurement", payload)
+		}
+	default:
+		assert.NotEmpty(t, config["command_topic"])
+		assert.Equal(t, "config", config["entity_category"])
+	}
+	if component == "select" {
+		assert.NotEmpty(t, config["options"])
+	}
+}
+
+func TestPayloadsForHomeAssistant(t *testing.T) {
+	device := Bridge("v0.0.0")
+	all := append(append(append(append(Sensors([]int{1}), qpigsSensors...), qpiwsSensors...), qpiriSensors...), pvEnergySensors...)
+	for _, sensor := range all {
+		component, _, _ := strings.Cut(sensor.SensorTopic, "/")
+		checkPayload(t, component, Format(sensor, device))
+	}
+	for _, control := range controls {
+		checkPayload(t, control.Component, FormatControl(control, device))
+	}
+}
+
+func TestPayloadEscaping(t *testing.T) {
+	sensor := Sensor{
+		SensorTopic:   "sensor/quoted",
+		UniqueId:      "quoted",
+		Name:          `The "quoted" \ name`,
+		ValueTemplate: `{{ value_json["Result"] | replace("\\", "/") }}`,
+		StateTopic:    "stats/quoted",
+		Icon:          "mdi:format-quote-close",
+	}
+	var config map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(Format(sensor, Bridge(`v"1\`))), &config))
+	assert.Equal(t, sensor.Name, config["name"])
+	assert.Equal(t, sensor.ValueTemplate, config["value_template"])
+	assert.Equal(t, `v"1\`, config["device"].(map[string]any)["sw_version"])
+}
+
+func TestPayloadOrigin(t *testing.T) {
+	SetVersion("v1.2.3")
+	defer SetVersion("development")
+
+	var config map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(Format(sensors[0], Bridge("v1.2.3"))), &config))
+	assert.Equal(t, map[string]any{
+		"name":        "phocus",
+		"sw_version":  "v1.2.3",
+		"support_url": "https://github.com/wolffshots/phocus",
+	}, config["origin"])
+	assert.Equal(t, "diagnostic", config["entity_category"])
+
+	assert.NoError(t, json.Unmarshal([]byte(Format(sensors[4], Bridge("v1.2.3"))), &config))
+	assert.Equal(t, "phocus/stats/generic", config["json_attributes_topic"])
+
+	precision := 1
+	assert.Contains(t, Format(Sensor{UniqueId: "expiring", ExpireAfter: 300, Precision: &precision, Unit: "V"}, Bridge("v1.2.3")), "\"suggested_display_precision\":1,\"expire_after\":300")
+}
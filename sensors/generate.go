@@ -0,0 +1,133 @@
+package phocus_sensors
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/wolffshots/ha_types/device_classes"
+	"github.com/wolffshots/ha_types/state_classes"
+	"github.com/wolffshots/ha_types/units"
+)
+
+// Enum is the device class for sensors whose state is one of a set of options
+const Enum device_classes.DeviceClass = "enum"
+
+// enumerated is a type of field which can only be one of a set of options
+type enumerated interface {
+	Options() []string
+}
+
+// Generate creates the sensors for the fields of a response from their ha tags
+//
+// The tag is the key used in the topic and unique id followed by the
+// metadata, ie `ha:"output_load,name=Output Load,unit=%,state_class=measurement,icon=mdi:gauge"`,
+// where the key and name default to ones made from the name of the field
+// so that overriding the name doesn't change the unique id.
+// The metadata can also have the entity category (category=diagnostic),
+// the suggested display precision (precision=1) and how many seconds
+// until the state expires if it isn't updated (expire_after=300).
+// Fields tagged with `ha:"-"` are skipped and structs are generated for
+// their own fields. Bools become binary sensors and fields whose type has
+// Options become enum sensors.
+//
+// query is the lower case name of the query (ie qpgs1) which prefixes the
+// unique ids and whose stats topic is the state topic, the names are
+// prefixed with it in upper case
+func Generate(query string, response any) []Sensor {
+	return GenerateNamed(query, strings.ToUpper(query), response)
+}
+
+// GenerateNamed is Generate with the names prefixed with prefix instead, ie Inverter 1
+// for the energy counters of energy1
+func GenerateNamed(query string, prefix string, response any) []Sensor {
+	return generate(query, prefix, reflect.TypeOf(response), "value_json")
+}
+
+// generate creates the sensors for the fields of structure where path is how they are reached in the value template
+func generate(query string, prefix string, structure reflect.Type, path string) []Sensor {
+	var generated []Sensor
+	for i := 0; i < structure.NumField(); i++ {
+		field := structure.Field(i)
+		tag, tagged := field.Tag.Lookup("ha")
+		if tag == "-" {
+			continue
+		}
+		fieldPath := fmt.Sprintf("%s.%s", path, field.Name)
+		if field.Type.Kind() == reflect.Struct && !tagged {
+			generated = append(generated, generate(query, prefix, field.Type, fieldPath)...)
+			continue
+		}
+		generated = append(generated, fieldSensor(query, prefix, field, tag, fieldPath))
+	}
+	return generated
+}
+
+// fieldSensor creates the sensor for a field from its tag
+func fieldSensor(query string, prefix string, field reflect.StructField, tag string, path string) Sensor {
+	key, metadata, _ := strings.Cut(tag, ",")
+	name := words(field.Name)
+	sensor := Sensor{
+		SensorTopic:   "sensor",
+		ValueTemplate: fmt.Sprintf("{{ %s }}", path),
+		StateTopic:    fmt.Sprintf("stats/%s", query),
+	}
+	for _, option := range strings.Split(metadata, ",") {
+		option, value, _ := strings.Cut(option, "=")
+		switch option {
+		case "name":
+			name = value
+		case "unit":
+			sensor.Unit = units.Unit(value)
+		case "device_class":
+			sensor.DeviceClass = device_classes.DeviceClass(value)
+		case "state_class":
+			sensor.StateClass = state_classes.StateClass(value)
+		case "icon":
+			sensor.Icon = value
+		case "category":
+			sensor.Category = value
+		case "precision":
+			precision, err := strconv.Atoi(value)
+			if err == nil {
+				sensor.Precision = &precision
+			}
+		case "expire_after":
+			sensor.ExpireAfter, _ = strconv.Atoi(value)
+		}
+	}
+	if key == "" {
+		key = strings.ToLower(strings.ReplaceAll(words(field.Name), " ", "_"))
+	}
+	if field.Type.Kind() == reflect.Bool {
+		sensor.SensorTopic = "binary_sensor"
+		sensor.ValueTemplate = fmt.Sprintf("{{ 'ON' if %s else 'OFF' }}", path)
+	}
+	if options, ok := reflect.Zero(field.Type).Interface().(enumerated); ok {
+		sensor.DeviceClass = Enum
+		sensor.Options = options.Options()
+	}
+	sensor.UniqueId = fmt.Sprintf("%s_%s", query, key)
+	sensor.SensorTopic = fmt.Sprintf("%s/%s", sensor.SensorTopic, sensor.UniqueId)
+	sensor.Name = fmt.Sprintf("%s %s", prefix, name)
+	return sensor
+}
+
+// words splits the name of a field into words, keeping acronyms together, ie ACInputVoltage becomes AC Input Voltage
+func words(fieldName string) string {
+	runes := []rune(fieldName)
+	var split strings.Builder
+	for i, current := range runes {
+		if i > 0 && unicode.IsUpper(current) {
+			previous := runes[i-1]
+			acronymEnds := unicode.IsUpper(previous) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || acronymEnds {
+				split.WriteRune(' ')
+			}
+		}
+		split.WriteRune(current)
+	}
+	return split.String()
+}
@@ -0,0 +1,132 @@
+package phocus_sensors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wolffshots/ha_types/device_classes"
+	"github.com/wolffshots/ha_types/state_classes"
+	"github.com/wolffshots/ha_types/units"
+	messages "github.com/wolffshots/phocus/v2/messages"
+)
+
+func TestWords(t *testing.T) {
+	assert.Equal(t, "AC Input Voltage", words("ACInputVoltage"))
+	assert.Equal(t, "Battery State Of Charge", words("BatteryStateOfCharge"))
+	assert.Equal(t, "EEPROM Fault", words("EEPROMFault"))
+	assert.Equal(t, "Max AC Charging Current Set", words("MaxACChargingCurrentSet"))
+	assert.Equal(t, "MPPT", words("MPPT"))
+}
+
+// untagged returns the fields of structure (and the structs in it) which don't have an ha tag
+func untagged(structure reflect.Type) []string {
+	var missing []string
+	for i := 0; i < structure.NumField(); i++ {
+		field := structure.Field(i)
+		if _, tagged := field.Tag.Lookup("ha"); tagged {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			missing = append(missing, untagged(field.Type)...)
+		} else {
+			missing = append(missing, structure.Name()+"."+field.Name)
+		}
+	}
+	return missing
+}
+
+func TestGenerate(t *testing.T) {
+	// every field has to say whether and how it is exposed so that the sensors can't drift from the responses
+	for _, response := range []any{
+		messages.QIDResponse{},
+		messages.QVFWResponse{},
+		messages.QPGSnResponse{},
+		messages.QPIGSResponse{},
+		messages.QPIWSResponse{},
+		messages.QPIRIResponse{},
+		messages.EnergyResponse{},
+	} {
+		assert.Empty(t, untagged(reflect.TypeOf(response)))
+	}
+
+	energyPrecision := 2
+	generated := map[string]Sensor{}
+	for _, sensor := range append(append(append(append(InverterSensors(1), qpigsSensors...), qpiwsSensors...), qpiriSensors...), pvEnergySensors...) {
+		_, duplicate := generated[sensor.UniqueId]
+		assert.False(t, duplicate, "duplicate unique id %s", sensor.UniqueId)
+		generated[sensor.UniqueId] = sensor
+	}
+
+	// the unique ids of the sensors from before they were generated are kept
+	for _, uniqueId := range []string{
+		"qpgs1_serial", "qpgs1_battery_voltage", "qpgs1_battery_state_of_charge", "qpgs1_operation_mode",
+		"qpgs1_ac_output_active_power", "qpgs1_ac_output_apparent_power", "qpgs1_pv_input_voltage",
+		"qpgs1_pv_input_current", "qpgs1_battery_discharge_current", "qpgs1_battery_charge_current",
+		"qpgs1_ac_input_mode", "qpgs1_total_ac_output_active_power", "qpgs1_total_ac_output_apparent_power",
+		"qpgs1_ac_input_voltage", "qpgs1_ac_input_frequency",
+		"qpigs_output_load", "qpigs_bus_voltage", "qpigs_heatsink_temperature", "qpigs_pv_charging_power",
+		"qpigs_load_on", "qpigs_scc_charging", "qpigs_ac_charging",
+		"qpiws_inverter_fault", "qpiws_op_dc_voltage_over", "qpiws_eeprom_fault", "qpiws_battery_too_low_to_charge",
+	} {
+		assert.Contains(t, generated, uniqueId)
+	}
+
+	// fields which were missing are now there and the checksum isn't
+	assert.Contains(t, generated, "qpgs1_ac_output_voltage")
+	assert.Contains(t, generated, "qpgs1_ac_output_frequency")
+	assert.NotContains(t, generated, "qpgs1_checksum")
+	assert.Equal(t, Sensor{
+		SensorTopic:   "sensor/qpgs1_max_charging_current_set",
+		UniqueId:      "qpgs1_max_charging_current_set",
+		Unit:          units.Current,
+		StateClass:    state_classes.None,
+		DeviceClass:   device_classes.Current,
+		Name:          "QPGS1 Max Charging Current Set",
+		ValueTemplate: "{{ value_json.MaxChargingCurrentSet }}",
+		StateTopic:    "stats/qpgs1",
+		Icon:          "mdi:current-dc",
+	}, generated["qpgs1_max_charging_current_set"])
+
+	// enums have their options
+	assert.Equal(t, Enum, generated["qpgs1_operation_mode"].DeviceClass)
+	assert.Equal(t, messages.OperationMode("").Options(), generated["qpgs1_operation_mode"].Options)
+	assert.Contains(t, Format(generated["qpiri_output_source_priority"], Bridge("v0.0.0")), "\"options\":[\"Utility first\",\"Solar first\",\"SBU first\"]")
+
+	// nested fields are reached through their struct
+	assert.Equal(t, "{{ value_json.InverterStatus.ACInput }}", generated["qpgs1_ac_input_mode"].ValueTemplate)
+	assert.Equal(t, "QPGS1 AC Input Mode", generated["qpgs1_ac_input_mode"].Name)
+
+	// bools are binary sensors
+	assert.Equal(t, "binary_sensor/qpiws_op_dc_voltage_over", generated["qpiws_op_dc_voltage_over"].SensorTopic)
+	assert.Equal(t, "{{ 'ON' if value_json.OPDCVoltageOver else 'OFF' }}", generated["qpiws_op_dc_voltage_over"].ValueTemplate)
+	assert.Equal(t, "QPIWS OP DC Voltage Over", generated["qpiws_op_dc_voltage_over"].Name)
+
+	// the energy counters of each inverter plug into the energy dashboard
+	assert.Equal(t, Sensor{
+		SensorTopic:   "sensor/energy1_pv_energy",
+		UniqueId:      "energy1_pv_energy",
+		Unit:          "kWh",
+		StateClass:    state_classes.TotalIncreasing,
+		DeviceClass:   device_classes.Energy,
+		Name:          "Inverter 1 PV Energy",
+		ValueTemplate: "{{ value_json.PVEnergy }}",
+		StateTopic:    "stats/energy1",
+		Icon:          "mdi:solar-power",
+		Precision:     &energyPrecision,
+	}, generated["energy1_pv_energy"])
+	for _, key := range []string{"ac_output_energy", "battery_charge_energy", "battery_discharge_energy", "grid_import_energy"} {
+		assert.Equal(t, state_classes.StateClass(state_classes.TotalIncreasing), generated["energy1_"+key].StateClass)
+		assert.Equal(t, device_classes.DeviceClass(device_classes.Energy), generated["energy1_"+key].DeviceClass)
+	}
+
+	// as are the counters from the inverter itself
+	for _, query := range []string{"qet", "qey", "qem", "qed"} {
+		assert.Equal(t, "stats/"+query, generated[query+"_pv_energy"].StateTopic)
+		assert.Equal(t, state_classes.StateClass(state_classes.TotalIncreasing), generated[query+"_pv_energy"].StateClass)
+		assert.Equal(t, device_classes.DeviceClass(device_classes.Energy), generated[query+"_pv_energy"].DeviceClass)
+	}
+
+	assert.Equal(t, "qid_serial", Generate("qid", messages.QIDResponse{})[0].UniqueId)
+	assert.Empty(t, Generate("qvfw", messages.QVFWResponse{}))
+}
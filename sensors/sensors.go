@@ -10,479 +10,270 @@ import (
 	"github.com/wolffshots/ha_types/device_classes"
 	"github.com/wolffshots/ha_types/state_classes"
 	"github.com/wolffshots/ha_types/units"
+	energy "github.com/wolffshots/phocus/v2/energy"
+	messages "github.com/wolffshots/phocus/v2/messages"
 	mqtt "github.com/wolffshots/phocus/v2/mqtt"
 )
 
 // Sensor is the shape of the sensor for the MQTT Home Assistant integration
 type Sensor struct {
-	SensorTopic   string                     // "homeassistant/sensor/phocus/start_time/config" must end in /config
-	UniqueId      string                     // "unique_id": "phocus_qpgs1_ac_output_apparent_power",
+	SensorTopic   string                     // "sensor/start_time" the component (can be a binary_sensor) and object id under the discovery prefix
+	UniqueId      string                     // "unique_id": "qpgs1_ac_output_apparent_power", prefixed with the node id
 	Unit          units.Unit                 // "unit_of_measurement": "VA",
 	StateClass    state_classes.StateClass   // "state_class": "measurement",
 	DeviceClass   device_classes.DeviceClass // "device_class": "apparent_power",
 	Name          string                     // "name": "QPGS1 AC Output Apparent Power",
 	ValueTemplate string                     // "value_template": "{{ value_json.ACOutputApparentPower }}",
-	StateTopic    string                     // "state_topic": "phocus/stats/qpgs1",
+	StateTopic    string                     // "state_topic": "stats/qpgs1", under the base topic
 	Icon          string                     // "icon": "mdi:battery",
+	Options       []string                   // "options": ["Grid", "Off-grid"], for the enum device class
+	Category      string                     // "entity_category": "diagnostic",
+	Precision     *int                       // "suggested_display_precision": 1,
+	ExpireAfter   int                        // "expire_after": 300, seconds until the state is unavailable if it isn't updated
+	Attributes    string                     // "json_attributes_topic": "stats/generic", under the base topic
 }
 
-// TODO refactor to take a number in for how many inverters
-var sensors = []Sensor{
+// sensors are the sensors which are only registered once
+var sensors = append([]Sensor{
 	{
-		SensorTopic:   "homeassistant/sensor/phocus/version/config",
-		UniqueId:      "phocus_version",
+		SensorTopic:   "sensor/version",
+		UniqueId:      "version",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.None,
 		Name:          "Phocus Version",
 		ValueTemplate: "",
-		StateTopic:    "phocus/stats/version",
+		StateTopic:    "stats/version",
 		Icon:          "mdi:source-branch",
+		Category:      "diagnostic",
 	},
 	{
-		SensorTopic:   "homeassistant/sensor/phocus/start_time/config",
-		UniqueId:      "phocus_start_time",
+		SensorTopic:   "sensor/start_time",
+		UniqueId:      "start_time",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.Timestamp,
 		Name:          "Start Time",
 		ValueTemplate: "",
-		StateTopic:    "phocus/stats/start_time",
+		StateTopic:    "stats/start_time",
 		Icon:          "mdi:clock",
+		Category:      "diagnostic",
 	},
 	{
-		SensorTopic:   "homeassistant/sensor/phocus/error/config",
-		UniqueId:      "phocus_last_error",
+		SensorTopic:   "sensor/error",
+		UniqueId:      "last_error",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.None,
 		Name:          "Last Reported Error",
 		ValueTemplate: "",
-		StateTopic:    "phocus/stats/error",
+		StateTopic:    "stats/error",
 		Icon:          "mdi:hammer-wrench",
+		Category:      "diagnostic",
 	},
 	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_serial/config",
-		UniqueId:      "phocus_qpgs1_serial",
+		SensorTopic:   "sensor/serial_state",
+		UniqueId:      "serial_state",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.None,
-		Name:          "QPGS1 Serial",
-		ValueTemplate: "{{ value_json.SerialNumber }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:update",
+		Name:          "Serial State",
+		ValueTemplate: "{{ value_json.State }}",
+		StateTopic:    "stats/serial",
+		Icon:          "mdi:serial-port",
+		Category:      "diagnostic",
+		Attributes:    "stats/serial",
 	},
 	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_serial/config",
-		UniqueId:      "phocus_qpgs2_serial",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS2 Serial",
-		ValueTemplate: "{{ value_json.SerialNumber }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:update",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_battery_voltage/config",
-		UniqueId:      "phocus_qpgs1_battery_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS1 Battery Voltage",
-		ValueTemplate: "{{ value_json.BatteryVoltage }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:battery",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_battery_voltage/config",
-		UniqueId:      "phocus_qpgs2_battery_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS2 Battery Voltage",
-		ValueTemplate: "{{ value_json.BatteryVoltage }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:battery",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_battery_state_of_charge/config",
-		UniqueId:      "phocus_qpgs1_battery_state_of_charge",
-		Unit:          units.Battery,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Battery,
-		Name:          "QPGS1 Battery SoC",
-		ValueTemplate: "{{ value_json.BatteryStateOfCharge }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:battery",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_battery_state_of_charge/config",
-		UniqueId:      "phocus_qpgs2_battery_state_of_charge",
-		Unit:          units.Battery,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Battery,
-		Name:          "QPGS2 Battery SoC",
-		ValueTemplate: "{{ value_json.BatteryStateOfCharge }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:battery",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_operation_mode/config",
-		UniqueId:      "phocus_qpgs1_operation_mode",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS1 Operation Mode",
-		ValueTemplate: "{{ value_json.OperationMode }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:meter-electric",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_operation_mode/config",
-		UniqueId:      "phocus_qpgs2_operation_mode",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS2 Operation Mode",
-		ValueTemplate: "{{ value_json.OperationMode }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:meter-electric",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_ac_output_active_power/config",
-		UniqueId:      "phocus_qpgs1_ac_output_active_power",
-		Unit:          units.Power,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Power,
-		Name:          "QPGS1 AC Output Active Power",
-		ValueTemplate: "{{ value_json.ACOutputActivePower }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_output_active_power/config",
-		UniqueId:      "phocus_qpgs2_ac_output_active_power",
-		Unit:          units.Power,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Power,
-		Name:          "QPGS2 AC Output Active Power",
-		ValueTemplate: "{{ value_json.ACOutputActivePower }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_ac_output_apparent_power/config",
-		UniqueId:      "phocus_qpgs1_ac_output_apparent_power",
-		Unit:          units.ApparentPower,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.ApparentPower,
-		Name:          "QPGS1 AC Output Apparent Power",
-		ValueTemplate: "{{ value_json.ACOutputApparentPower }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_output_apparent_power/config",
-		UniqueId:      "phocus_qpgs2_ac_output_apparent_power",
-		Unit:          units.ApparentPower,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.ApparentPower,
-		Name:          "QPGS2 AC Output Apparent Power",
-		ValueTemplate: "{{ value_json.ACOutputApparentPower }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_pv_input_voltage/config",
-		UniqueId:      "phocus_qpgs1_pv_input_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS1 PV Input Voltage",
-		ValueTemplate: "{{ value_json.PVInputVoltage }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_pv_input_voltage/config",
-		UniqueId:      "phocus_qpgs2_pv_input_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS2 PV Input Voltage",
-		ValueTemplate: "{{ value_json.PVInputVoltage }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_pv_input_current/config",
-		UniqueId:      "phocus_qpgs1_pv_input_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS1 PV Input Current",
-		ValueTemplate: "{{ value_json.PVInputCurrent }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_pv_input_current/config",
-		UniqueId:      "phocus_qpgs2_pv_input_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS2 PV Input Current",
-		ValueTemplate: "{{ value_json.PVInputCurrent }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_battery_discharge_current/config",
-		UniqueId:      "phocus_qpgs1_battery_discharge_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS1 Battery Discharge Current",
-		ValueTemplate: "{{ value_json.BatteryDischargeCurrent }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_battery_discharge_current/config",
-		UniqueId:      "phocus_qpgs2_battery_discharge_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS2 Battery Discharge Current",
-		ValueTemplate: "{{ value_json.BatteryDischargeCurrent }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_battery_charge_current/config",
-		UniqueId:      "phocus_qpgs1_battery_charge_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS1 Battery Charge Current",
-		ValueTemplate: "{{ value_json.BatteryChargingCurrent }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_battery_charge_current/config",
-		UniqueId:      "phocus_qpgs2_battery_charge_current",
-		Unit:          units.Current,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Current,
-		Name:          "QPGS2 Battery Charge Current",
-		ValueTemplate: "{{ value_json.BatteryChargingCurrent }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:current-dc",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_ac_input_mode/config",
-		UniqueId:      "phocus_qpgs1_ac_input_mode",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS1 AC Input Mode",
-		ValueTemplate: "{{ value_json.InverterStatus.ACInput }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:meter-electric",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_input_mode/config",
-		UniqueId:      "phocus_qpgs2_ac_input_mode",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS2 AC Input Mode",
-		ValueTemplate: "{{ value_json.InverterStatus.ACInput }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:meter-electric",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_total_ac_output_active_power/config",
-		UniqueId:      "phocus_qpgs1_total_ac_output_active_power",
-		Unit:          units.Power,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Power,
-		Name:          "QPGS1 Total AC Output Active Power",
-		ValueTemplate: "{{ value_json.TotalACOutputActivePower }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_total_ac_output_active_power/config",
-		UniqueId:      "phocus_qpgs2_total_ac_output_active_power",
-		Unit:          units.Power,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Power,
-		Name:          "QPGS2 Total AC Output Active Power",
-		ValueTemplate: "{{ value_json.TotalACOutputActivePower }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_total_ac_output_apparent_power/config",
-		UniqueId:      "phocus_qpgs1_total_ac_output_apparent_power",
-		Unit:          units.ApparentPower,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.ApparentPower,
-		Name:          "QPGS1 Total AC Output Apparent Power",
-		ValueTemplate: "{{ value_json.TotalACOutputApparentPower }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_total_ac_output_apparent_power/config",
-		UniqueId:      "phocus_qpgs2_total_ac_output_apparent_power",
-		Unit:          units.ApparentPower,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.ApparentPower,
-		Name:          "QPGS2 Total AC Output Apparent Power",
-		ValueTemplate: "{{ value_json.TotalACOutputApparentPower }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_ac_input_voltage/config",
-		UniqueId:      "phocus_qpgs1_ac_input_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS1 AC Input Voltage",
-		ValueTemplate: "{{ value_json.ACInputVoltage }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_input_voltage/config",
-		UniqueId:      "phocus_qpgs2_ac_input_voltage",
-		Unit:          units.Voltage,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Voltage,
-		Name:          "QPGS2 AC Input Voltage",
-		ValueTemplate: "{{ value_json.ACInputVoltage }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:lightning-bolt",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_ac_input_frequency/config",
-		UniqueId:      "phocus_qpgs1_ac_input_frequency",
-		Unit:          units.Frequency,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Frequency,
-		Name:          "QPGS1 AC Input Frequency",
-		ValueTemplate: "{{ value_json.ACInputFrequency }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:sine-wave",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_input_frequency/config",
-		UniqueId:      "phocus_qpgs2_ac_input_frequency",
-		Unit:          units.Frequency,
-		StateClass:    state_classes.Measurement,
-		DeviceClass:   device_classes.Frequency,
-		Name:          "QPGS2 AC Input Frequency",
-		ValueTemplate: "{{ value_json.ACInputFrequency }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:sine-wave",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs1_checksum/config",
-		UniqueId:      "phocus_qpgs1_checksum",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS1 Checksum",
-		ValueTemplate: "{{ value_json.Checksum }}",
-		StateTopic:    "phocus/stats/qpgs1",
-		Icon:          "mdi:check",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_checksum/config",
-		UniqueId:      "phocus_qpgs2_checksum",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QPGS2 Checksum",
-		ValueTemplate: "{{ value_json.Checksum }}",
-		StateTopic:    "phocus/stats/qpgs2",
-		Icon:          "mdi:check",
-	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/generic_response/config",
-		UniqueId:      "phocus_generic_response",
+		SensorTopic:   "sensor/generic_response",
+		UniqueId:      "generic_response",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.None,
 		Name:          "Generic Response",
 		ValueTemplate: "{{ value_json.Result }}",
-		StateTopic:    "phocus/stats/generic",
+		StateTopic:    "stats/generic",
 		Icon:          "fab:readme",
+		Category:      "diagnostic",
+		Attributes:    "stats/generic",
 	},
-	{
-		SensorTopic:   "homeassistant/sensor/phocus/qid_serial/config",
-		UniqueId:      "phocus_qid_serial",
-		Unit:          units.None,
-		StateClass:    state_classes.None,
-		DeviceClass:   device_classes.None,
-		Name:          "QID Serial",
-		ValueTemplate: "{{ value_json.SerialNumber }}",
-		StateTopic:    "phocus/stats/qid",
-		Icon:          "mdi:update",
-	},
-}
+}, Generate("qid", messages.QIDResponse{})...)
 
-func Format(sensor Sensor, version string) string {
-	log.Printf("Registering %s\n", sensor.Name)
+// units which aren't in ha_types
+const (
+	Celsius units.Unit = "°C"
+	Percent units.Unit = "%"
+)
+
+// Problem is the device class for binary sensors where on means that there is a problem
+const Problem device_classes.DeviceClass = "problem"
+
+// qpigsSensors are the sensors for QPIGS which are registered when it is polled
+var qpigsSensors = Generate("qpigs", messages.QPIGSResponse{})
+
+// qpiwsSensors are the binary sensors for the QPIWS warnings which are registered when it is polled
+var qpiwsSensors = Generate("qpiws", messages.QPIWSResponse{})
 
-	sensorDefinition := fmt.Sprintf(
-		"{\""+
-			"unique_id\":\"%s\",\""+
-			"name\":\"%s\",\""+
-			"state_topic\":\"%s\",\""+
-			"icon\":\"%s\",\""+
-			"device\":{\"name\":\"phocus\",\""+
-			"identifiers\":[\"phocus\"],\""+
-			"model\":\"phocus\",\""+
-			"manufacturer\":\"phocus\",\""+
-			"sw_version\":\"%s\"},\""+
-			"force_update\":false",
-		sensor.UniqueId,
-		sensor.Name,
-		sensor.StateTopic,
-		sensor.Icon,
-		version,
+// qpiriSensors are the sensors for the settings from QPIRI which are registered when it is polled
+var qpiriSensors = Generate("qpiri", messages.QPIRIResponse{})
+
+// pvEnergySensors are the PV energy counters of the inverter from QET, QEY, QEM and QED which are registered when they are polled
+var pvEnergySensors = append(append(append(
+	Generate("qet", messages.EnergyResponse{}),
+	Generate("qey", messages.EnergyResponse{})...),
+	Generate("qem", messages.EnergyResponse{})...),
+	Generate("qed", messages.EnergyResponse{})...)
+
+// retiredInverterKeys are the keys of QPGSn sensors which were removed and whose
+// configs are cleared so that Home Assistant forgets them
+var retiredInverterKeys = []string{"checksum"}
+
+// InverterSensors are the sensors for an inverter polled with QPGSn and its energy counters
+func InverterSensors(inverterNum int) []Sensor {
+	return append(
+		Generate(fmt.Sprintf("qpgs%d", inverterNum), messages.QPGSnResponse{}),
+		GenerateNamed(fmt.Sprintf("energy%d", inverterNum), fmt.Sprintf("Inverter %d", inverterNum), energy.Counters{})...,
 	)
-	if sensor.Unit != "" {
-		sensorDefinition += fmt.Sprintf(", \"unit_of_measurement\":\"%s\"", sensor.Unit)
-	}
-	if sensor.StateClass != "" {
-		sensorDefinition += fmt.Sprintf(", \"state_class\":\"%s\"", sensor.StateClass)
-	}
-	if sensor.DeviceClass != "" {
-		sensorDefinition += fmt.Sprintf(", \"device_class\":\"%s\"", sensor.DeviceClass)
+}
+
+// Sensors returns all of the sensors to register for the given inverters
+func Sensors(inverters []int) []Sensor {
+	allSensors := append([]Sensor{}, sensors...)
+	for _, inverterNum := range inverters {
+		allSensors = append(allSensors, InverterSensors(inverterNum)...)
 	}
-	if sensor.ValueTemplate != "" {
-		sensorDefinition += fmt.Sprintf(", \"value_template\":\"%s\"", sensor.ValueTemplate)
+	return allSensors
+}
+
+// Format creates the config of a sensor for Home Assistant under the device
+func Format(sensor Sensor, device Device) string {
+	log.Printf("Registering %s\n", sensor.Name)
+
+	payload := newPayload(sensor.UniqueId, sensor.Name, sensor.Icon, device)
+	payload.StateTopic = mqtt.Topic(sensor.StateTopic)
+	if sensor.Attributes != "" {
+		payload.JSONAttributesTopic = mqtt.Topic(sensor.Attributes)
 	}
-	sensorDefinition += "}"
-	return sensorDefinition
+	payload.EntityCategory = sensor.Category
+	payload.UnitOfMeasurement = string(sensor.Unit)
+	payload.StateClass = string(sensor.StateClass)
+	payload.DeviceClass = string(sensor.DeviceClass)
+	payload.SuggestedDisplayPrecision = sensor.Precision
+	payload.ExpireAfter = sensor.ExpireAfter
+	payload.ValueTemplate = sensor.ValueTemplate
+	payload.Options = sensor.Options
+	return payload.String()
 }
 
 // Register adds some sensors to Home Assistant MQTT
 // version is the current version of the system, added in 1.1.1
-func Register(client mqtt.Client, version string) error {
+// inverters are the inverter numbers to add QPGSn sensors for
+//
+// The QPGSn sensors of an inverter are only added once its serial number
+// is known since they are grouped under a device for it
+func Register(client mqtt.Client, version string, inverters []int) error {
 	log.Println("Registering sensors")
+	err := register(client, Bridge(version), sensors)
+	if err != nil {
+		return err
+	}
+	for _, inverterNum := range inverters {
+		device, known := InverterDevice(inverterNum)
+		if !known {
+			log.Printf("Not registering QPGS%d sensors until its serial number is known\n", inverterNum)
+			continue
+		}
+		err = register(client, device, InverterSensors(inverterNum))
+		if err != nil {
+			return err
+		}
+		for _, key := range retiredInverterKeys {
+			err = mqtt.Send(client, mqtt.DiscoveryTopic(fmt.Sprintf("sensor/qpgs%d_%s", inverterNum, key)), 0, true, "", 10)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RegisterQPIGS adds the QPIGS sensors to Home Assistant MQTT
+// once the serial number of the connected inverter is known
+func RegisterQPIGS(client mqtt.Client) error {
+	device, known := ConnectedDevice()
+	if !known {
+		log.Println("Not registering QPIGS sensors until the serial number is known")
+		return nil
+	}
+	log.Println("Registering QPIGS sensors")
+	return register(client, device, qpigsSensors)
+}
+
+// RegisterQPIWS adds the QPIWS warning binary sensors to Home Assistant MQTT
+// once the serial number of the connected inverter is known
+func RegisterQPIWS(client mqtt.Client) error {
+	device, known := ConnectedDevice()
+	if !known {
+		log.Println("Not registering QPIWS binary sensors until the serial number is known")
+		return nil
+	}
+	log.Println("Registering QPIWS binary sensors")
+	return register(client, device, qpiwsSensors)
+}
+
+// RegisterQPIRI adds the sensors for the settings to Home Assistant MQTT
+// once the serial number of the connected inverter is known
+func RegisterQPIRI(client mqtt.Client) error {
+	device, known := ConnectedDevice()
+	if !known {
+		log.Println("Not registering QPIRI sensors until the serial number is known")
+		return nil
+	}
+	log.Println("Registering QPIRI sensors")
+	return register(client, device, qpiriSensors)
+}
+
+// RegisterPVEnergy adds the PV energy counters of the inverter to Home Assistant MQTT
+// once the serial number of the connected inverter is known
+func RegisterPVEnergy(client mqtt.Client) error {
+	device, known := ConnectedDevice()
+	if !known {
+		log.Println("Not registering PV energy sensors until the serial number is known")
+		return nil
+	}
+	log.Println("Registering PV energy sensors")
+	return register(client, device, pvEnergySensors)
+}
+
+// Rediscovery listens for Home Assistant starting and calls register so
+// that it gets the configs again, since it forgets entities whose
+// retained configs were lost or never reached it
+func Rediscovery(register func(client mqtt.Client) error) mqtt.Subscription {
+	return mqtt.Subscription{
+		Topic: mqtt.StatusTopic(),
+		Handler: func(client mqtt.Client, payload string) {
+			if payload != mqtt.Online {
+				return
+			}
+			log.Println("Home Assistant came online so registering again")
+			// registering is slow so it mustn't block the handling of other messages
+			go func() {
+				err := register(client)
+				if err != nil {
+					log.Printf("Failed to register again with err: %v", err)
+				}
+			}()
+		},
+	}
+}
+
+// register sends the config of each sensor to Home Assistant MQTT under the device
+func register(client mqtt.Client, device Device, sensors []Sensor) error {
 	for _, sensor := range sensors {
 
-		sensorDefinition := Format(sensor, version)
+		sensorDefinition := Format(sensor, device)
 
-		err := mqtt.Send(client, sensor.SensorTopic, 0, true, sensorDefinition, 10)
+		err := mqtt.Send(client, mqtt.DiscoveryTopic(sensor.SensorTopic), 0, true, sensorDefinition, 10)
 		if err != nil {
 			log.Printf("Failed to send initial setup stats to MQTT with err: %v", err)
 			return err
@@ -491,3 +282,19 @@ func Register(client mqtt.Client, version string) error {
 	}
 	return nil
 }
+
+// Unregister removes the QPGSn sensors for the given inverters from Home Assistant MQTT
+// by clearing their retained configs
+func Unregister(client mqtt.Client, inverters []int) error {
+	for _, inverterNum := range inverters {
+		log.Printf("Unregistering sensors for QPGS%d\n", inverterNum)
+		for _, inverterSensor := range InverterSensors(inverterNum) {
+			err := mqtt.Send(client, mqtt.DiscoveryTopic(inverterSensor.SensorTopic), 0, true, "", 10)
+			if err != nil {
+				log.Printf("Failed to remove sensor from MQTT with err: %v", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,45 +1,187 @@
 package phocus_sensors
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/wolffshots/ha_types/device_classes"
 	"github.com/wolffshots/ha_types/state_classes"
 	"github.com/wolffshots/ha_types/units"
+	messages "github.com/wolffshots/phocus/v2/messages"
+	mqtt "github.com/wolffshots/phocus/v2/mqtt"
 )
 
 func TestFormat(t *testing.T) {
 	sensor := Sensor{
-		SensorTopic:   "homeassistant/sensor/phocus/qid_serial/config",
-		UniqueId:      "phocus_qid_serial",
+		SensorTopic:   "sensor/qid_serial",
+		UniqueId:      "qid_serial",
 		Unit:          units.None,
 		StateClass:    state_classes.None,
 		DeviceClass:   device_classes.None,
 		Name:          "QID Serial",
 		ValueTemplate: "{{ value_json.SerialNumber }}",
-		StateTopic:    "phocus/stats/qid",
+		StateTopic:    "stats/qid",
 		Icon:          "mdi:update",
 	}
 
-	sensorDefinition := Format(sensor, "v0.0.0")
+	sensorDefinition := Format(sensor, Bridge("v0.0.0"))
 
-	assert.Equal(t, "{\"unique_id\":\"phocus_qid_serial\",\"name\":\"QID Serial\",\"state_topic\":\"phocus/stats/qid\",\"icon\":\"mdi:update\",\"device\":{\"name\":\"phocus\",\"identifiers\":[\"phocus\"],\"model\":\"phocus\",\"manufacturer\":\"phocus\",\"sw_version\":\"v0.0.0\"},\"force_update\":false, \"value_template\":\"{{ value_json.SerialNumber }}\"}", sensorDefinition)
+	assert.JSONEq(t, `{"unique_id":"phocus_qid_serial","name":"QID Serial","state_topic":"phocus/stats/qid","availability":[{"topic":"phocus/availability","payload_available":"online","payload_not_available":"offline"}],"icon":"mdi:update","device":{"name":"phocus","identifiers":["phocus"],"model":"phocus","manufacturer":"phocus","sw_version":"v0.0.0"},"origin":{"name":"phocus","sw_version":"development","support_url":"https://github.com/wolffshots/phocus"},"value_template":"{{ value_json.SerialNumber }}"}`, sensorDefinition)
 
 	sensor = Sensor{
-		SensorTopic:   "homeassistant/sensor/phocus/qpgs2_ac_input_frequency/config",
-		UniqueId:      "phocus_qpgs2_ac_input_frequency",
+		SensorTopic:   "sensor/qpgs2_ac_input_frequency",
+		UniqueId:      "qpgs2_ac_input_frequency",
 		Unit:          units.Frequency,
 		StateClass:    state_classes.Measurement,
 		DeviceClass:   device_classes.Frequency,
 		Name:          "QPGS2 AC Input Frequency",
 		ValueTemplate: "{{ value_json.ACInputFrequency }}",
-		StateTopic:    "phocus/stats/qpgs2",
+		StateTopic:    "stats/qpgs2",
 		Icon:          "mdi:sine-wave",
 	}
 
-	sensorDefinition = Format(sensor, "v0.0.0")
+	sensorDefinition = Format(sensor, Bridge("v0.0.0"))
 
-	assert.Equal(t, "{\"unique_id\":\"phocus_qpgs2_ac_input_frequency\",\"name\":\"QPGS2 AC Input Frequency\",\"state_topic\":\"phocus/stats/qpgs2\",\"icon\":\"mdi:sine-wave\",\"device\":{\"name\":\"phocus\",\"identifiers\":[\"phocus\"],\"model\":\"phocus\",\"manufacturer\":\"phocus\",\"sw_version\":\"v0.0.0\"},\"force_update\":false, \"unit_of_measurement\":\"Hz\", \"state_class\":\"measurement\", \"device_class\":\"frequency\", \"value_template\":\"{{ value_json.ACInputFrequency }}\"}", sensorDefinition)
+	assert.JSONEq(t, `{"unique_id":"phocus_qpgs2_ac_input_frequency","name":"QPGS2 AC Input Frequency","state_topic":"phocus/stats/qpgs2","availability":[{"topic":"phocus/availability","payload_available":"online","payload_not_available":"offline"}],"icon":"mdi:sine-wave","device":{"name":"phocus","identifiers":["phocus"],"model":"phocus","manufacturer":"phocus","sw_version":"v0.0.0"},"origin":{"name":"phocus","sw_version":"development","support_url":"https://github.com/wolffshots/phocus"},"unit_of_measurement":"Hz","state_class":"measurement","device_class":"frequency","value_template":"{{ value_json.ACInputFrequency }}"}`, sensorDefinition)
 
 }
+
+func TestSensors(t *testing.T) {
+	// no inverters only gives the common sensors
+	assert.Equal(t, len(sensors), len(Sensors([]int{})))
+
+	allSensors := Sensors([]int{1, 3})
+	assert.Equal(t, len(sensors)+len(InverterSensors(1))+len(InverterSensors(3)), len(allSensors))
+
+	frequencyPrecision := 2
+	want := Sensor{
+		SensorTopic:   "sensor/qpgs3_ac_input_frequency",
+		UniqueId:      "qpgs3_ac_input_frequency",
+		Unit:          units.Frequency,
+		StateClass:    state_classes.Measurement,
+		DeviceClass:   device_classes.Frequency,
+		Name:          "QPGS3 AC Input Frequency",
+		ValueTemplate: "{{ value_json.ACInputFrequency }}",
+		StateTopic:    "stats/qpgs3",
+		Icon:          "mdi:sine-wave",
+		Precision:     &frequencyPrecision,
+	}
+	assert.Contains(t, allSensors, want)
+
+	uniqueIds := map[string]bool{}
+	for _, sensor := range allSensors {
+		assert.False(t, uniqueIds[sensor.UniqueId], "duplicate unique id %s", sensor.UniqueId)
+		uniqueIds[sensor.UniqueId] = true
+	}
+}
+
+func TestRegisterAndUnregister(t *testing.T) {
+	// without a client both should fail on the first send
+	err := Register(nil, "v0.0.0", []int{1})
+	assert.EqualError(t, err, "client not defined in send")
+
+	err = Unregister(nil, []int{1})
+	assert.EqualError(t, err, "client not defined in send")
+
+	// nothing to remove so nothing gets sent
+	err = Unregister(nil, []int{})
+	assert.NoError(t, err)
+}
+
+func TestQPIGSSensors(t *testing.T) {
+	uniqueIds := map[string]bool{}
+	for _, sensor := range append(Sensors([]int{1}), qpigsSensors...) {
+		assert.False(t, uniqueIds[sensor.UniqueId], "duplicate unique id %s", sensor.UniqueId)
+		uniqueIds[sensor.UniqueId] = true
+	}
+	assert.Contains(t, qpigsSensors, Sensor{
+		SensorTopic:   "sensor/qpigs_heatsink_temperature",
+		UniqueId:      "qpigs_heatsink_temperature",
+		Unit:          "°C",
+		StateClass:    state_classes.Measurement,
+		DeviceClass:   device_classes.Temperature,
+		Name:          "QPIGS Heatsink Temperature",
+		ValueTemplate: "{{ value_json.HeatsinkTemperature }}",
+		StateTopic:    "stats/qpigs",
+		Icon:          "mdi:thermometer",
+	})
+
+	// nothing is sent until the connected inverter is known
+	err := RegisterQPIGS(nil)
+	assert.NoError(t, err)
+
+	assert.True(t, UpdateDevices(&messages.QIDResponse{SerialNumber: "92932004102443"}))
+	defer resetInventory()
+	err = RegisterQPIGS(nil)
+	assert.EqualError(t, err, "client not defined in send")
+}
+
+func TestQPIWSSensors(t *testing.T) {
+	// every warning has a binary sensor
+	warnings := reflect.TypeOf(messages.QPIWSResponse{})
+	templates := map[string]bool{}
+	for _, sensor := range qpiwsSensors {
+		assert.True(t, strings.HasPrefix(sensor.SensorTopic, "binary_sensor/qpiws_"), sensor.SensorTopic)
+		assert.Equal(t, Problem, sensor.DeviceClass)
+		templates[sensor.ValueTemplate] = true
+	}
+	for i := 0; i < warnings.NumField(); i++ {
+		if warnings.Field(i).Type.Kind() == reflect.Bool {
+			template := fmt.Sprintf("{{ 'ON' if value_json.%s else 'OFF' }}", warnings.Field(i).Name)
+			assert.True(t, templates[template], "missing binary sensor for %s", warnings.Field(i).Name)
+		}
+	}
+
+	assert.JSONEq(t, `{"unique_id":"phocus_qpiws_line_fail","name":"QPIWS Line Fail","state_topic":"phocus/stats/qpiws","availability":[{"topic":"phocus/availability","payload_available":"online","payload_not_available":"offline"}],"icon":"mdi:alert","device":{"name":"phocus","identifiers":["phocus"],"model":"phocus","manufacturer":"phocus","sw_version":"v0.0.0"},"origin":{"name":"phocus","sw_version":"development","support_url":"https://github.com/wolffshots/phocus"},"device_class":"problem","value_template":"{{ 'ON' if value_json.LineFail else 'OFF' }}"}`, Format(qpiwsSensors[4], Bridge("v0.0.0")))
+
+	// nothing is sent until the connected inverter is known
+	err := RegisterQPIWS(nil)
+	assert.NoError(t, err)
+
+	assert.True(t, UpdateDevices(&messages.QIDResponse{SerialNumber: "92932004102443"}))
+	defer resetInventory()
+	err = RegisterQPIWS(nil)
+	assert.EqualError(t, err, "client not defined in send")
+}
+
+func TestRediscovery(t *testing.T) {
+	registered := make(chan bool, 1)
+	subscription := Rediscovery(func(client mqtt.Client) error {
+		registered <- true
+		return errors.New("client not defined in send")
+	})
+	assert.Equal(t, "homeassistant/status", subscription.Topic)
+
+	subscription.Handler(nil, "offline")
+	select {
+	case <-registered:
+		t.Error("shouldn't register when Home Assistant goes offline")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	subscription.Handler(nil, "online")
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Error("should register when Home Assistant comes online")
+	}
+}
+
+func TestFormatWithInstance(t *testing.T) {
+	assert.NoError(t, mqtt.SetNamespace(mqtt.Namespace{InstanceID: "site_a"}))
+	defer func() { _ = mqtt.SetNamespace(mqtt.Namespace{}) }()
+
+	sensorDefinition := Format(InverterSensors(1)[0], Bridge("v0.0.0"))
+	assert.Contains(t, sensorDefinition, "\"unique_id\":\"phocus_site_a_qpgs1_serial\"")
+	assert.Contains(t, sensorDefinition, "\"state_topic\":\"phocus/site_a/stats/qpgs1\"")
+	assert.Contains(t, sensorDefinition, "\"topic\":\"phocus/site_a/availability\"")
+	assert.Contains(t, sensorDefinition, "\"identifiers\":[\"phocus_site_a\"]")
+
+	assert.Equal(t, "homeassistant/select/phocus_site_a/output_source_priority/config", controls[0].ConfigTopic())
+	assert.Equal(t, "phocus/site_a/command/output_source_priority", controls[0].CommandTopic())
+}
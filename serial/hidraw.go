@@ -0,0 +1,144 @@
+package phocus_serial
+
+import (
+	"bytes"   // finding the terminator
+	"errors"  // matching timeouts
+	"fmt"     // formatting
+	"log"     // logging
+	"os"      // device nodes
+	"strings" // matching device paths
+	"time"    // timeouts
+
+	crc "github.com/wolffshots/phocus/v2/crc" // checksum generation
+	"go.bug.st/serial"                        // rs232 serial
+)
+
+// HIDReportSize is the size of the reports that USB HID inverters send and receive
+const HIDReportSize = 8
+
+// IsHID reports whether a port path is a USB HID device node rather than a serial port
+func IsHID(portPath string) bool {
+	return strings.HasPrefix(portPath, "hidraw://") || strings.HasPrefix(portPath, "/dev/hidraw")
+}
+
+// HIDPort is a serial.Port for inverters which only speak the protocol over
+// a USB HID endpoint (/dev/hidraw*) where everything is sent in 8 byte reports
+type HIDPort struct {
+	input       *os.File // reports from the device
+	output      *os.File // reports to the device
+	readTimeout time.Duration
+}
+
+// OpenHID opens a hidraw device node, optionally prefixed with hidraw://
+func OpenHID(portPath string) (*HIDPort, error) {
+	device, err := os.OpenFile(strings.TrimPrefix(portPath, "hidraw://"), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &HIDPort{input: device, output: device, readTimeout: serial.NoTimeout}, nil
+}
+
+// Write writes p to the device as is, use WriteHID to split a message into reports
+func (port *HIDPort) Write(p []byte) (int, error) {
+	return port.output.Write(p)
+}
+
+// Read reads a report from the device, returning nothing if the read times out
+func (port *HIDPort) Read(p []byte) (int, error) {
+	deadline := time.Time{}
+	if port.readTimeout != serial.NoTimeout {
+		deadline = time.Now().Add(port.readTimeout)
+	}
+	if err := port.input.SetReadDeadline(deadline); err != nil && !errors.Is(err, os.ErrNoDeadline) {
+		return 0, err
+	}
+	n, err := port.input.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (port *HIDPort) SetReadTimeout(timeout time.Duration) error {
+	port.readTimeout = timeout
+	return nil
+}
+
+func (port *HIDPort) Close() error {
+	err := port.input.Close()
+	if port.output != port.input {
+		err = errors.Join(err, port.output.Close())
+	}
+	return err
+}
+
+// the rest of serial.Port doesn't apply to HID devices
+func (port *HIDPort) SetMode(mode *serial.Mode) error { return nil }
+func (port *HIDPort) ResetInputBuffer() error         { return nil }
+func (port *HIDPort) ResetOutputBuffer() error        { return nil }
+func (port *HIDPort) SetDTR(dtr bool) error           { return nil }
+func (port *HIDPort) SetRTS(rts bool) error           { return nil }
+func (port *HIDPort) Break(duration time.Duration) error {
+	return nil
+}
+func (port *HIDPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+// WriteHID writes a string to a USB HID inverter with the CRC added like Write
+// but split into 8 byte reports with the last one padded with zeroes
+var WriteHID = func(port serial.Port, input string) (int, error) {
+	message := []byte(crc.Encode(input))
+	if port == nil {
+		return 0, ErrNilPortOnWrite
+	}
+	written := 0
+	for start := 0; start < len(message); start += HIDReportSize {
+		report := make([]byte, HIDReportSize)
+		copy(report, message[start:])
+		if _, err := port.Write(report); err != nil {
+			return -1, err
+		}
+		written += min(HIDReportSize, len(message)-start)
+	}
+	return written, nil
+}
+
+// ReadHID reads reports from a USB HID inverter and reassembles them
+// into a response up to and including the carriage return after a matching
+// CRC, dropping the zero padding of the last report. Times out each report after timeout.
+//
+// Returns the read string and the error
+var ReadHID = func(port serial.Port, timeout time.Duration) (string, error) {
+	log.Printf("Starting HID read\n")
+	if port == nil {
+		return "", ErrNilPortOnRead
+	}
+	port.SetReadTimeout(timeout)
+	report := make([]byte, 64)
+	var response []byte
+	for {
+		n, err := port.Read(report)
+		if err != nil {
+			log.Printf("Err reading from HID device: %v", err)
+			return string(response), err
+		} else if n == 0 {
+			if end := bytes.LastIndexByte(response, '\r'); end >= 0 {
+				// nothing came after the carriage return so it was the end of a frame with a bad CRC
+				return string(response[:end+1]), nil
+			}
+			return string(response), ErrReadNothing
+		}
+		start := len(response)
+		response = append(response, report[:n]...)
+		// a byte of the CRC can be a carriage return too so it's only the end if the CRC matches
+		for end := start; end < len(response); end++ {
+			if response[end] == '\r' && crc.Verify(string(response[:end+1])) {
+				return string(response[:end+1]), nil
+			}
+		}
+		if len(response) > 1024 {
+			return string(response), fmt.Errorf("no carriage return in %d bytes from HID device", len(response))
+		}
+	}
+}
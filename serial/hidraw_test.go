@@ -0,0 +1,123 @@
+//go:build linux || darwin
+
+package phocus_serial
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	crc "github.com/wolffshots/phocus/v2/crc"
+)
+
+// fakeHIDDevice returns a HIDPort along with the other ends of its pipes
+func fakeHIDDevice(t *testing.T) (port *HIDPort, fromHost *os.File, toHost *os.File) {
+	input, toHost, err := os.Pipe()
+	assert.NoError(t, err)
+	fromHost, output, err := os.Pipe()
+	assert.NoError(t, err)
+	t.Cleanup(func() { fromHost.Close(); toHost.Close() })
+	return &HIDPort{input: input, output: output}, fromHost, toHost
+}
+
+func TestIsHID(t *testing.T) {
+	assert.True(t, IsHID("/dev/hidraw0"))
+	assert.True(t, IsHID("hidraw:///dev/hidraw3"))
+	assert.False(t, IsHID("/dev/ttyUSB0"))
+	assert.False(t, IsHID("tcp://192.168.1.50:8888"))
+}
+
+func TestWriteHID(t *testing.T) {
+	port, fromHost, _ := fakeHIDDevice(t)
+	defer port.Close()
+
+	n, err := WriteHID(port, "QPGS1")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	n, err = WriteHID(port, "POP02")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	n, err = WriteHID(port, "MUCHGC0020")
+	assert.NoError(t, err)
+	assert.Equal(t, 13, n)
+
+	reports := make([]byte, 64)
+	n, _ = fromHost.Read(reports)
+	assert.Equal(t, 32, n)
+	assert.Equal(t, crc.Encode("QPGS1"), string(reports[:8]))
+	assert.Equal(t, crc.Encode("POP02"), string(reports[8:16]))
+	assert.Equal(t, crc.Encode("MUCHGC0020")+"\x00\x00\x00", string(reports[16:32]))
+
+	_, err = WriteHID(nil, "QPGS1")
+	assert.Equal(t, ErrNilPortOnWrite, err)
+	port.output.Close()
+	n, err = WriteHID(port, "QPGS1")
+	assert.Error(t, err)
+	assert.Equal(t, -1, n)
+}
+
+func TestReadHID(t *testing.T) {
+	port, _, toHost := fakeHIDDevice(t)
+	defer port.Close()
+
+	// reply arrives in padded 8 byte reports
+	send := func(reply []byte) {
+		for start := 0; start < len(reply); start += HIDReportSize {
+			report := make([]byte, HIDReportSize)
+			copy(report, reply[start:])
+			toHost.Write(report)
+			time.Sleep(time.Millisecond)
+		}
+	}
+	reply := []byte(crc.Encode("(92932004102443"))
+	go send(reply)
+	response, err := ReadHID(port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, string(reply), response)
+
+	// including a reply whose CRC has a carriage return in the first report
+	reply = []byte(crc.Encode("(00138"))
+	assert.Equal(t, "(00138\x0d\x45\r", string(reply))
+	go send(reply)
+	response, err = ReadHID(port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, string(reply), response)
+
+	// a reply with a bad CRC ends at the carriage return once nothing else arrives
+	go send([]byte("(00138\xff\xff\r"))
+	response, err = ReadHID(port, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "(00138\xff\xff\r", response)
+
+	// device stops part way through a reply
+	toHost.Write([]byte("(9293200"))
+	response, err = ReadHID(port, 10*time.Millisecond)
+	assert.Equal(t, ErrReadNothing, err)
+	assert.Equal(t, "(9293200", response)
+
+	_, err = ReadHID(nil, time.Second)
+	assert.Equal(t, ErrNilPortOnRead, err)
+}
+
+func TestSetupHID(t *testing.T) {
+	// a fifo loops what is written straight back like an echoing device
+	fifo := filepath.Join(t.TempDir(), "hidraw0")
+	assert.NoError(t, syscall.Mkfifo(fifo, 0600))
+
+	port, err := Setup("hidraw://"+fifo, 2400, 1)
+	assert.NoError(t, err)
+	defer port.Port.Close()
+	assert.IsType(t, &HIDPort{}, port.Port)
+
+	_, err = port.Write(port.Port, "QID")
+	assert.NoError(t, err)
+	response, err := port.Read(port.Port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, crc.Encode("QID"), response)
+
+	_, err = Setup("/dev/hidraw_missing", 2400, 1)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,352 @@
+package phocus_serial
+
+import (
+	"bytes"           // escaping telnet data
+	"encoding/binary" // rfc2217 baud rate
+	"errors"          // creating custom err messages
+	"fmt"             // formatting
+	"io"              // matching closed connections
+	"net"             // network connections
+	"strings"         // splitting the scheme from the path
+	"sync"            // guarding writes
+	"syscall"         // matching reset connections
+	"time"            // timeouts
+
+	"go.bug.st/serial" // rs232 serial
+)
+
+// ErrConnectionClosed is returned when a network serial bridge drops the connection
+var ErrConnectionClosed = errors.New("serial connection closed")
+
+// DialTimeout is how long to wait for a network serial bridge to accept a connection
+var DialTimeout = 5 * time.Second
+
+// Open opens the port at portPath which is either the path of a local
+// device, a USB HID device (see IsHID) or a network serial bridge as
+// tcp://host:port for a raw socket or rfc2217://host:port for a bridge
+// speaking telnet with RFC 2217
+func Open(portPath string, mode *serial.Mode) (serial.Port, error) {
+	if IsHID(portPath) {
+		port, err := OpenHID(portPath)
+		if err != nil {
+			return nil, err // avoid handing back a typed nil
+		}
+		return port, nil
+	}
+	scheme, address, found := strings.Cut(portPath, "://")
+	if !found {
+		return serial.Open(portPath, mode)
+	}
+	var port *NetworkPort
+	var err error
+	switch scheme {
+	case "tcp":
+		port, err = DialTCP(address)
+	case "rfc2217":
+		port, err = DialRFC2217(address, mode)
+	default:
+		return nil, fmt.Errorf("unsupported serial transport %q in %s", scheme, portPath)
+	}
+	if err != nil {
+		return nil, err // avoid handing back a typed nil
+	}
+	return port, nil
+}
+
+// NetworkPort is a serial.Port on the other side of a network serial bridge
+type NetworkPort struct {
+	conn        net.Conn
+	readTimeout time.Duration
+	telnet      *telnet // nil for raw sockets
+	writeMutex  sync.Mutex
+}
+
+// DialTCP connects to a raw serial bridge which passes bytes through as is
+func DialTCP(address string) (*NetworkPort, error) {
+	conn, err := net.DialTimeout("tcp", address, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkPort{conn: conn, readTimeout: serial.NoTimeout}, nil
+}
+
+// DialRFC2217 connects to a serial bridge which speaks telnet with the
+// RFC 2217 com port option and sets the line up for mode
+func DialRFC2217(address string, mode *serial.Mode) (*NetworkPort, error) {
+	conn, err := net.DialTimeout("tcp", address, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	port := &NetworkPort{conn: conn, readTimeout: serial.NoTimeout, telnet: &telnet{}}
+	negotiation := []byte{
+		iac, will, optionComPort,
+		iac, will, optionBinary, iac, do, optionBinary,
+		iac, will, optionSuppressGoAhead, iac, do, optionSuppressGoAhead,
+	}
+	if _, err := port.writeRaw(negotiation); err != nil {
+		conn.Close()
+		return nil, closed(err)
+	}
+	if err := port.SetMode(mode); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// closed wraps errors from a connection that has gone away with ErrConnectionClosed
+func closed(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return fmt.Errorf("%w: %v", ErrConnectionClosed, err)
+	}
+	return err
+}
+
+// writeRaw writes bytes to the connection without any telnet escaping
+func (port *NetworkPort) writeRaw(p []byte) (int, error) {
+	port.writeMutex.Lock()
+	defer port.writeMutex.Unlock()
+	return port.conn.Write(p)
+}
+
+// Write writes to the bridge, escaping the data for telnet if needed
+func (port *NetworkPort) Write(p []byte) (int, error) {
+	data := p
+	if port.telnet != nil {
+		data = bytes.ReplaceAll(p, []byte{iac}, []byte{iac, iac})
+	}
+	if _, err := port.writeRaw(data); err != nil {
+		return 0, closed(err)
+	}
+	return len(p), nil
+}
+
+// Read reads from the bridge like a local port would so a read that
+// times out returns nothing instead of an error
+func (port *NetworkPort) Read(p []byte) (int, error) {
+	for {
+		deadline := time.Time{}
+		if port.readTimeout != serial.NoTimeout {
+			deadline = time.Now().Add(port.readTimeout)
+		}
+		port.conn.SetReadDeadline(deadline)
+		n, err := port.conn.Read(p)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return 0, nil
+		} else if err != nil {
+			return 0, closed(err)
+		}
+		if port.telnet == nil {
+			return n, nil
+		}
+		data, replies := port.telnet.filter(p[:n])
+		if len(replies) > 0 {
+			if _, err := port.writeRaw(replies); err != nil {
+				return 0, closed(err)
+			}
+		}
+		if len(data) > 0 {
+			return copy(p, data), nil
+		}
+		// only telnet commands arrived so keep waiting for data
+	}
+}
+
+// SetMode sets the baud rate and framing on RFC 2217 bridges
+func (port *NetworkPort) SetMode(mode *serial.Mode) error {
+	if port.telnet == nil || mode == nil {
+		return nil
+	}
+	dataBits := mode.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(mode.BaudRate))
+	for _, command := range [][]byte{
+		append([]byte{setBaudRate}, baud...),
+		{setDataSize, byte(dataBits)},
+		{setParity, parities[mode.Parity]},
+		{setStopSize, stopSizes[mode.StopBits]},
+	} {
+		if err := port.comPort(command...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comPort sends an RFC 2217 com port subnegotiation
+func (port *NetworkPort) comPort(command ...byte) error {
+	message := []byte{iac, subnegotiation, optionComPort, command[0]}
+	message = append(message, bytes.ReplaceAll(command[1:], []byte{iac}, []byte{iac, iac})...)
+	message = append(message, iac, subnegotiationEnd)
+	_, err := port.writeRaw(message)
+	return closed(err)
+}
+
+// control sends a SET-CONTROL value on RFC 2217 bridges
+func (port *NetworkPort) control(value byte) error {
+	if port.telnet == nil {
+		return nil
+	}
+	return port.comPort(setControl, value)
+}
+
+// purge sends a PURGE-DATA value on RFC 2217 bridges
+func (port *NetworkPort) purge(value byte) error {
+	if port.telnet == nil {
+		return nil
+	}
+	return port.comPort(purgeData, value)
+}
+
+func (port *NetworkPort) ResetInputBuffer() error  { return port.purge(purgeReceive) }
+func (port *NetworkPort) ResetOutputBuffer() error { return port.purge(purgeTransmit) }
+
+func (port *NetworkPort) SetDTR(dtr bool) error {
+	if dtr {
+		return port.control(controlDTROn)
+	}
+	return port.control(controlDTROff)
+}
+
+func (port *NetworkPort) SetRTS(rts bool) error {
+	if rts {
+		return port.control(controlRTSOn)
+	}
+	return port.control(controlRTSOff)
+}
+
+// GetModemStatusBits isn't tracked for network bridges so nothing is reported as set
+func (port *NetworkPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func (port *NetworkPort) SetReadTimeout(timeout time.Duration) error {
+	port.readTimeout = timeout
+	return nil
+}
+
+func (port *NetworkPort) Close() error {
+	return port.conn.Close()
+}
+
+func (port *NetworkPort) Break(duration time.Duration) error {
+	if err := port.control(controlBreakOn); err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return port.control(controlBreakOff)
+}
+
+// telnet and RFC 2217 codes
+const (
+	iac               byte = 255
+	dont              byte = 254
+	do                byte = 253
+	wont              byte = 252
+	will              byte = 251
+	subnegotiation    byte = 250
+	subnegotiationEnd byte = 240
+
+	optionBinary          byte = 0
+	optionSuppressGoAhead byte = 3
+	optionComPort         byte = 44
+
+	setBaudRate byte = 1
+	setDataSize byte = 2
+	setParity   byte = 3
+	setStopSize byte = 4
+	setControl  byte = 5
+	purgeData   byte = 12
+
+	controlBreakOn  byte = 5
+	controlBreakOff byte = 6
+	controlDTROn    byte = 8
+	controlDTROff   byte = 9
+	controlRTSOn    byte = 11
+	controlRTSOff   byte = 12
+
+	purgeReceive  byte = 1
+	purgeTransmit byte = 2
+)
+
+var parities = map[serial.Parity]byte{
+	serial.NoParity:    1,
+	serial.OddParity:   2,
+	serial.EvenParity:  3,
+	serial.MarkParity:  4,
+	serial.SpaceParity: 5,
+}
+
+var stopSizes = map[serial.StopBits]byte{
+	serial.OneStopBit:           1,
+	serial.TwoStopBits:          2,
+	serial.OnePointFiveStopBits: 3,
+}
+
+// telnet strips telnet commands out of the received stream, keeping
+// its state between reads since a command can be split across them
+type telnet struct {
+	state   int
+	command byte
+}
+
+const (
+	telnetData = iota
+	telnetIAC
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+// filter returns the data in the received bytes and any replies to send back.
+//
+// Options the bridge offers or asks for that weren't asked for are refused
+func (t *telnet) filter(received []byte) (data []byte, replies []byte) {
+	for _, b := range received {
+		switch t.state {
+		case telnetData:
+			if b == iac {
+				t.state = telnetIAC
+			} else {
+				data = append(data, b)
+			}
+		case telnetIAC:
+			switch b {
+			case iac:
+				data = append(data, iac)
+				t.state = telnetData
+			case will, wont, do, dont:
+				t.command = b
+				t.state = telnetOption
+			case subnegotiation:
+				t.state = telnetSubnegotiation
+			default:
+				t.state = telnetData
+			}
+		case telnetOption:
+			wanted := b == optionComPort || b == optionBinary || b == optionSuppressGoAhead
+			if !wanted && t.command == will {
+				replies = append(replies, iac, dont, b)
+			} else if !wanted && t.command == do {
+				replies = append(replies, iac, wont, b)
+			}
+			t.state = telnetData
+		case telnetSubnegotiation:
+			if b == iac {
+				t.state = telnetSubnegotiationIAC
+			}
+		case telnetSubnegotiationIAC:
+			if b == subnegotiationEnd {
+				t.state = telnetData
+			} else {
+				t.state = telnetSubnegotiation
+			}
+		}
+	}
+	return data, replies
+}
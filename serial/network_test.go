@@ -0,0 +1,138 @@
+package phocus_serial
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	crc "github.com/wolffshots/phocus/v2/crc"
+	simulator "github.com/wolffshots/phocus/v2/simulator"
+	"go.bug.st/serial"
+)
+
+// listen starts a local listener which hands each connection to serve
+func listen(t *testing.T, serve func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open("udp://127.0.0.1:1", &serial.Mode{BaudRate: 2400})
+	assert.EqualError(t, err, `unsupported serial transport "udp" in udp://127.0.0.1:1`)
+
+	port, err := Open("tcp://127.0.0.1:1", &serial.Mode{BaudRate: 2400})
+	assert.Error(t, err)
+	assert.Nil(t, port) // not a typed nil
+}
+
+func TestTCP(t *testing.T) {
+	var conns = make(chan net.Conn, 2)
+	address := listen(t, func(conn net.Conn) {
+		conns <- conn
+		simulator.New(simulator.DefaultScenario).Serve(conn)
+	})
+
+	port, err := Setup("tcp://"+address, 2400, 1)
+	assert.NoError(t, err)
+	defer func() { port.Port.Close() }()
+
+	_, err = port.Write(port.Port, "QID")
+	assert.NoError(t, err)
+	response, err := port.Read(port.Port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, crc.Encode("(92932004102443"), response)
+
+	// nothing comes back for an incomplete frame so the read times out
+	_, err = port.Port.Write([]byte("QID"))
+	assert.NoError(t, err)
+	_, err = port.Read(port.Port, 10*time.Millisecond)
+	assert.Equal(t, ErrReadNothing, err)
+
+	// the bridge going away needs a reconnect
+	(<-conns).Close()
+	_, err = port.Read(port.Port, time.Second)
+	assert.ErrorIs(t, err, ErrConnectionClosed)
+	assert.True(t, NeedsRecovery(err))
+
+	recovery := NewRecovery(time.Millisecond, time.Millisecond, 1, nil)
+	assert.NoError(t, recovery.Recover(&port))
+	_, err = port.Write(port.Port, "QID")
+	assert.NoError(t, err)
+	response, err = port.Read(port.Port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, crc.Encode("(92932004102443"), response)
+}
+
+func TestTelnetFilter(t *testing.T) {
+	filter := &telnet{}
+	data, replies := filter.filter([]byte{'(', iac, iac, 'A', iac, will, 1, iac, do, 24, iac, will, optionComPort})
+	assert.Equal(t, []byte{'(', iac, 'A'}, data)
+	assert.Equal(t, []byte{iac, dont, 1, iac, wont, 24}, replies)
+
+	// commands split across reads
+	data, _ = filter.filter([]byte{'B', iac})
+	assert.Equal(t, []byte{'B'}, data)
+	data, _ = filter.filter([]byte{subnegotiation, optionComPort, 107, iac, iac, 0})
+	assert.Nil(t, data)
+	data, _ = filter.filter([]byte{iac, subnegotiationEnd, 'C', '\r'})
+	assert.Equal(t, []byte{'C', '\r'}, data)
+}
+
+func TestRFC2217(t *testing.T) {
+	received := make(chan []byte, 1)
+	reply := []byte(crc.Encode("(ACK"))
+	address := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		var all []byte
+		buffer := make([]byte, 256)
+		for !bytes.HasSuffix(all, []byte{iac, iac, '\r'}) {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			all = append(all, buffer[:n]...)
+		}
+		received <- all
+		// acknowledge the baud rate, then the reply with a line state notification inside it
+		conn.Write([]byte{iac, subnegotiation, optionComPort, 101, 0, 0, 9, 96, iac, subnegotiationEnd})
+		conn.Write(reply[:2])
+		conn.Write([]byte{iac, subnegotiation, optionComPort, 106, 0x60, iac, subnegotiationEnd})
+		conn.Write(reply[2:])
+		io.Copy(io.Discard, conn)
+	})
+
+	port, err := Setup("rfc2217://"+address, 2400, 1)
+	assert.NoError(t, err)
+	defer port.Port.Close()
+
+	_, err = port.Port.Write([]byte{'Q', iac, '\r'})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		iac, will, optionComPort,
+		iac, will, optionBinary, iac, do, optionBinary,
+		iac, will, optionSuppressGoAhead, iac, do, optionSuppressGoAhead,
+		iac, subnegotiation, optionComPort, setBaudRate, 0, 0, 9, 96, iac, subnegotiationEnd,
+		iac, subnegotiation, optionComPort, setDataSize, 8, iac, subnegotiationEnd,
+		iac, subnegotiation, optionComPort, setParity, 1, iac, subnegotiationEnd,
+		iac, subnegotiation, optionComPort, setStopSize, 1, iac, subnegotiationEnd,
+		'Q', iac, iac, '\r',
+	}, <-received)
+
+	response, err := port.Read(port.Port, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, string(reply), response)
+}
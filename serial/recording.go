@@ -0,0 +1,100 @@
+package phocus_serial
+
+import (
+	"encoding/json" // one frame per line
+	"io"            // recording to any writer
+	"log"           // logging
+	"os"            // recording files
+	"sync"          // guarding the writer
+	"time"          // timestamps
+
+	crc "github.com/wolffshots/phocus/v2/crc" // framing what was written
+	"go.bug.st/serial"                        // rs232 serial
+)
+
+// Direction of a recorded frame
+type Direction string
+
+const (
+	Sent     Direction = "write" // written to the inverter
+	Received Direction = "read"  // read from the inverter
+)
+
+// Frame is a single raw write or read on the port
+//
+// Data is the exact bytes including the CRC and carriage return so
+// it is base64 encoded in the recording
+type Frame struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+	Error     string `json:",omitempty"`
+}
+
+// Recorder appends frames to a recording as JSON lines
+type Recorder struct {
+	Now func() time.Time // defaults to time.Now
+
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewRecorder creates a Recorder which writes to a writer
+func NewRecorder(writer io.Writer) *Recorder {
+	recorder := &Recorder{Now: time.Now, encoder: json.NewEncoder(writer)}
+	if closer, ok := writer.(io.Closer); ok {
+		recorder.closer = closer
+	}
+	return recorder
+}
+
+// OpenRecorder creates a Recorder which appends to a file, creating it if needed
+func OpenRecorder(fileName string) (*Recorder, error) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(file), nil
+}
+
+// Record appends a frame to the recording with the current time
+func (recorder *Recorder) Record(direction Direction, data []byte, err error) error {
+	frame := Frame{Time: recorder.Now(), Direction: direction, Data: data}
+	if err != nil {
+		frame.Error = err.Error()
+	}
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.encoder.Encode(frame)
+}
+
+// Close closes the underlying file if there is one
+func (recorder *Recorder) Close() error {
+	if recorder.closer == nil {
+		return nil
+	}
+	return recorder.closer.Close()
+}
+
+// Recorded wraps the Write and Read of a port so that every frame
+// passing through them is also recorded. Failing to record is only
+// logged so that it never interrupts talking to the inverter.
+func (port Port) Recorded(recorder *Recorder) Port {
+	write, read := port.Write, port.Read
+	port.Write = func(serialPort serial.Port, input string) (int, error) {
+		n, err := write(serialPort, input)
+		if recordErr := recorder.Record(Sent, []byte(crc.Encode(input)), err); recordErr != nil {
+			log.Printf("Failed to record write: %v\n", recordErr)
+		}
+		return n, err
+	}
+	port.Read = func(serialPort serial.Port, timeout time.Duration) (string, error) {
+		response, err := read(serialPort, timeout)
+		if recordErr := recorder.Record(Received, []byte(response), err); recordErr != nil {
+			log.Printf("Failed to record read: %v\n", recordErr)
+		}
+		return response, err
+	}
+	return port
+}
@@ -0,0 +1,110 @@
+package phocus_serial
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.bug.st/serial"
+)
+
+func TestRecorded(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	recorder.Now = func() time.Time { return time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC) }
+
+	responses := []struct {
+		response string
+		err      error
+	}{{"(ACK9 \r", nil}, {"", ErrReadNothing}}
+	port := Port{
+		Write: func(port serial.Port, input string) (int, error) { return len(input) + 3, nil },
+		Read: func(port serial.Port, timeout time.Duration) (string, error) {
+			response := responses[0]
+			responses = responses[1:]
+			return response.response, response.err
+		},
+	}.Recorded(recorder)
+
+	n, err := port.Write(nil, "POP01")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	response, err := port.Read(nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "(ACK9 \r", response)
+	_, err = port.Read(nil, 0)
+	assert.Equal(t, ErrReadNothing, err)
+
+	assert.Equal(t, strings.Join([]string{
+		`{"Time":"2024-03-02T06:00:00Z","Direction":"write","Data":"UE9QMDHSaQ0="}`,
+		`{"Time":"2024-03-02T06:00:00Z","Direction":"read","Data":"KEFDSzkgDQ=="}`,
+		`{"Time":"2024-03-02T06:00:00Z","Direction":"read","Data":"","Error":"read returned nothing"}`,
+		``,
+	}, "\n"), recording.String())
+	assert.NoError(t, recorder.Close())
+}
+
+func TestReplay(t *testing.T) {
+	// a recording has to come back out the same way it went in
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	long := "(" + strings.Repeat("1", 200) + "\xab\xcd\r"
+	reads := map[string]struct {
+		response string
+		err      error
+	}{
+		"QPGS1": {long, nil},
+		"QPGS2": {"", ErrReadNothing},
+		"QPGS3": {"(1 9293", errors.New("device disconnected")},
+		"QID":   {"(92932004102443.*\r", nil},
+	}
+	var lastCommand string
+	original := Port{
+		Write: func(port serial.Port, input string) (int, error) { lastCommand = input; return len(input) + 3, nil },
+		Read: func(port serial.Port, timeout time.Duration) (string, error) {
+			return reads[lastCommand].response, reads[lastCommand].err
+		},
+	}.Recorded(recorder)
+	commands := []string{"QPGS1", "QPGS2", "QPGS3", "QID"}
+	for _, command := range commands {
+		original.Write(nil, command)
+		original.Read(nil, 0)
+	}
+
+	recorded := recording.String()
+	replay, err := NewReplay(strings.NewReader(recorded))
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(replay.Frames))
+	assert.Equal(t, commands, replay.Commands())
+
+	port := replay.Port()
+	for _, command := range commands {
+		n, err := port.Write(port.Port, command)
+		assert.NoError(t, err)
+		assert.Equal(t, len(command)+3, n)
+		response, err := port.Read(port.Port, 0)
+		assert.Equal(t, reads[command].response, response, command)
+		if reads[command].err == nil {
+			assert.NoError(t, err, command)
+		} else {
+			assert.EqualError(t, err, reads[command].err.Error(), command)
+		}
+	}
+	_, err = port.Write(port.Port, "QPGS1")
+	assert.Equal(t, ErrReplayFinished, err)
+
+	// writes out of order
+	replay, _ = NewReplay(strings.NewReader(recorded))
+	port = replay.Port()
+	_, err = port.Write(port.Port, "QPGS2")
+	assert.ErrorIs(t, err, ErrReplayMismatch)
+
+	// broken recordings
+	_, err = NewReplay(strings.NewReader("{}\n\nnot json\n"))
+	assert.EqualError(t, err, "invalid frame on line 3: invalid character 'o' in literal null (expecting 'u')")
+	_, err = LoadReplay("./not_a_recording.jsonl")
+	assert.Error(t, err)
+}
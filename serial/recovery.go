@@ -0,0 +1,125 @@
+package phocus_serial
+
+import (
+	"errors" // matching recoverable errors
+	"log"    // logging
+	"sync"   // guarding the status
+	"time"   // backoff
+
+	"go.bug.st/serial" // rs232 serial
+)
+
+// ErrRecoveryFailed is returned when the port couldn't be reopened within the allowed attempts
+var ErrRecoveryFailed = errors.New("failed to recover serial port")
+
+// State is the state of the connection to the inverter
+type State string
+
+const (
+	Connected    State = "connected"    // port is open and responding
+	Disconnected State = "disconnected" // port has been closed after it stopped responding
+	Reconnecting State = "reconnecting" // port is being reopened
+	Failed       State = "failed"       // port couldn't be reopened within the allowed attempts
+)
+
+// Status is a snapshot of the Recovery which gets reported on every state change
+type Status struct {
+	State   State
+	Attempt int
+	Backoff string
+	Error   string
+}
+
+// Recovery reopens a port that has stopped responding using exponential backoff
+type Recovery struct {
+	InitialBackoff time.Duration                                              // wait before the first attempt
+	MaxBackoff     time.Duration                                              // cap on the doubling wait between attempts
+	MaxAttempts    int                                                        // attempts before giving up, 0 means never give up
+	Retries        int                                                        // retries passed on to Open for each attempt
+	Open           func(portPath string, baud int, retries int) (Port, error) // defaults to Setup
+	OnState        func(status Status)                                        // called on every state change
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// NewRecovery creates a Recovery which reopens ports with Setup
+func NewRecovery(initialBackoff time.Duration, maxBackoff time.Duration, retries int, onState func(status Status)) *Recovery {
+	return &Recovery{
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		Retries:        retries,
+		Open:           Setup,
+		OnState:        onState,
+		status:         Status{State: Connected},
+	}
+}
+
+// NeedsRecovery reports whether an error means that the port should be reopened
+func NeedsRecovery(err error) bool {
+	if err == nil {
+		return false
+	}
+	var portError *serial.PortError
+	return errors.Is(err, ErrReadNothing) ||
+		errors.Is(err, ErrNilPortOnWrite) ||
+		errors.Is(err, ErrNilPortOnRead) ||
+		errors.Is(err, ErrConnectionClosed) ||
+		(errors.As(err, &portError) && portError.Code() == serial.PortClosed)
+}
+
+// Status returns the latest Status of the Recovery
+func (recovery *Recovery) Status() Status {
+	recovery.mutex.Lock()
+	defer recovery.mutex.Unlock()
+	return recovery.status
+}
+
+// setStatus stores and reports a new Status
+func (recovery *Recovery) setStatus(status Status) {
+	recovery.mutex.Lock()
+	recovery.status = status
+	recovery.mutex.Unlock()
+	log.Printf("Serial is %s (attempt %d, backoff %s): %s\n", status.State, status.Attempt, status.Backoff, status.Error)
+	if recovery.OnState != nil {
+		recovery.OnState(status)
+	}
+}
+
+// Recover closes the port and keeps trying to reopen it, doubling the wait between
+// attempts up to MaxBackoff. Only the underlying serial.Port is replaced so any
+// Write and Read functions set on the port are kept.
+//
+// Returns ErrRecoveryFailed if MaxAttempts is reached
+func (recovery *Recovery) Recover(port *Port) error {
+	if port.Port != nil {
+		port.Port.Close()
+		port.Port = nil
+	}
+	recovery.setStatus(Status{State: Disconnected})
+
+	open := recovery.Open
+	if open == nil {
+		open = Setup
+	}
+	backoff := recovery.InitialBackoff
+	var lastErr error
+	for attempt := 1; recovery.MaxAttempts <= 0 || attempt <= recovery.MaxAttempts; attempt++ {
+		recovery.setStatus(Status{State: Reconnecting, Attempt: attempt, Backoff: backoff.String()})
+		time.Sleep(backoff)
+		reopened, err := open(port.Path, port.Baud, recovery.Retries)
+		if err == nil {
+			port.Port = reopened.Port
+			recovery.setStatus(Status{State: Connected, Attempt: attempt})
+			return nil
+		}
+		lastErr = err
+		recovery.setStatus(Status{State: Reconnecting, Attempt: attempt, Backoff: backoff.String(), Error: err.Error()})
+		backoff *= 2
+		if backoff > recovery.MaxBackoff {
+			backoff = recovery.MaxBackoff
+		}
+	}
+	recovery.setStatus(Status{State: Failed, Attempt: recovery.MaxAttempts, Error: lastErr.Error()})
+	return errors.Join(ErrRecoveryFailed, lastErr)
+}
@@ -0,0 +1,90 @@
+package phocus_serial
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.bug.st/serial"
+)
+
+// closeTrackingPort is a serial.Port that only records whether it was closed
+type closeTrackingPort struct {
+	serial.Port
+	closed bool
+}
+
+func (port *closeTrackingPort) Close() error {
+	port.closed = true
+	return nil
+}
+
+func TestNeedsRecovery(t *testing.T) {
+	assert.False(t, NeedsRecovery(nil))
+	assert.False(t, NeedsRecovery(errors.New("invalid response from QPGS1")))
+	assert.True(t, NeedsRecovery(ErrReadNothing))
+	assert.True(t, NeedsRecovery(ErrNilPortOnWrite))
+	assert.True(t, NeedsRecovery(ErrNilPortOnRead))
+	assert.True(t, NeedsRecovery(ErrConnectionClosed))
+	assert.True(t, NeedsRecovery(fmt.Errorf("wrapped: %w", ErrReadNothing)))
+	assert.False(t, NeedsRecovery(&serial.PortError{})) // a busy port won't be fixed by reopening
+}
+
+func TestRecover(t *testing.T) {
+	var statuses []Status
+	recovery := NewRecovery(time.Millisecond, 2*time.Millisecond, 1, func(status Status) {
+		statuses = append(statuses, status)
+	})
+	assert.Equal(t, Connected, recovery.Status().State)
+
+	attempts := 0
+	reopened := &closeTrackingPort{}
+	recovery.Open = func(portPath string, baud int, retries int) (Port, error) {
+		attempts++
+		assert.Equal(t, "./recovery", portPath)
+		assert.Equal(t, 2400, baud)
+		assert.Equal(t, 1, retries)
+		if attempts < 3 {
+			return Port{Path: portPath}, errors.New("no such file or directory")
+		}
+		return Port{Port: reopened, Path: portPath}, nil
+	}
+
+	original := &closeTrackingPort{}
+	read := func(port serial.Port, timeout time.Duration) (string, error) { return "custom", nil }
+	port := Port{Port: original, Path: "./recovery", Baud: 2400, Write: Write, Read: read}
+
+	err := recovery.Recover(&port)
+	assert.NoError(t, err)
+	assert.True(t, original.closed)
+	assert.Equal(t, reopened, port.Port)
+	response, _ := port.Read(port.Port, 0)
+	assert.Equal(t, "custom", response) // read function is kept
+	assert.Equal(t, Connected, recovery.Status().State)
+	assert.Equal(t, 3, recovery.Status().Attempt)
+
+	assert.Equal(t, []Status{
+		{State: Disconnected},
+		{State: Reconnecting, Attempt: 1, Backoff: "1ms"},
+		{State: Reconnecting, Attempt: 1, Backoff: "1ms", Error: "no such file or directory"},
+		{State: Reconnecting, Attempt: 2, Backoff: "2ms"},
+		{State: Reconnecting, Attempt: 2, Backoff: "2ms", Error: "no such file or directory"},
+		{State: Reconnecting, Attempt: 3, Backoff: "2ms"},
+		{State: Connected, Attempt: 3},
+	}, statuses)
+
+	// giving up after the max attempts
+	statuses = nil
+	recovery.MaxAttempts = 2
+	recovery.Open = func(portPath string, baud int, retries int) (Port, error) {
+		return Port{}, errors.New("no such file or directory")
+	}
+	err = recovery.Recover(&port)
+	assert.ErrorIs(t, err, ErrRecoveryFailed)
+	assert.EqualError(t, err, "failed to recover serial port\nno such file or directory")
+	assert.Nil(t, port.Port)
+	assert.Equal(t, Status{State: Failed, Attempt: 2, Error: "no such file or directory"}, recovery.Status())
+	assert.Equal(t, 6, len(statuses))
+}
@@ -0,0 +1,133 @@
+package phocus_serial
+
+import (
+	"bufio"         // reading recordings line by line
+	"bytes"         // comparing writes
+	"encoding/json" // one frame per line
+	"errors"        // creating custom err messages
+	"fmt"           // formatting
+	"io"            // replaying from any reader
+	"os"            // recording files
+	"time"          // satisfying serial.Port
+
+	"go.bug.st/serial" // rs232 serial
+)
+
+// ErrReplayMismatch is returned when a write doesn't match the next write in the recording
+var ErrReplayMismatch = errors.New("write does not match the recording")
+
+// ErrReplayFinished is returned when writing after the last frame in the recording
+var ErrReplayFinished = errors.New("recording has no more frames")
+
+// Replay is a serial.Port which plays back a recording instead of talking to an inverter.
+//
+// Writes have to match the recorded writes in order and reads return
+// what was recorded after them, including errors, without any of the
+// original timing so that a recording always plays back the same way
+type Replay struct {
+	Frames []Frame
+
+	position int
+	pending  []byte
+	err      error
+}
+
+// NewReplay reads a recording of JSON lines into a Replay
+func NewReplay(reader io.Reader) (*Replay, error) {
+	replay := &Replay{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		frame := Frame{}
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("invalid frame on line %d: %w", line, err)
+		}
+		replay.Frames = append(replay.Frames, frame)
+	}
+	return replay, scanner.Err()
+}
+
+// LoadReplay reads a recording file into a Replay
+func LoadReplay(fileName string) (*Replay, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewReplay(file)
+}
+
+// Commands returns the commands that were written in the recording without their CRC
+func (replay *Replay) Commands() []string {
+	var commands []string
+	for _, frame := range replay.Frames {
+		if frame.Direction == Sent && len(frame.Data) > 3 {
+			commands = append(commands, string(frame.Data[:len(frame.Data)-3]))
+		}
+	}
+	return commands
+}
+
+// Port wraps the Replay in a Port with the usual Write and Read
+func (replay *Replay) Port() Port {
+	return Port{Port: replay, Path: "replay", Write: Write, Read: Read}
+}
+
+// Write checks the written bytes against the next recorded write
+func (replay *Replay) Write(p []byte) (int, error) {
+	replay.pending, replay.err = nil, nil
+	for replay.position < len(replay.Frames) && replay.Frames[replay.position].Direction != Sent {
+		replay.position++ // reads that were never asked for
+	}
+	if replay.position >= len(replay.Frames) {
+		return 0, ErrReplayFinished
+	}
+	frame := replay.Frames[replay.position]
+	replay.position++
+	if !bytes.Equal(frame.Data, p) {
+		return 0, fmt.Errorf("%w: wrote %q but recorded %q", ErrReplayMismatch, p, frame.Data)
+	}
+	if frame.Error != "" {
+		return -1, errors.New(frame.Error)
+	}
+	return len(p), nil
+}
+
+// Read returns the next recorded read, split across calls if it doesn't fit in p.
+//
+// A read that recorded nothing returns nothing so that Read produces ErrReadNothing
+// again and any other recorded error is returned once the recorded bytes are used up
+func (replay *Replay) Read(p []byte) (int, error) {
+	if len(replay.pending) == 0 && replay.err == nil &&
+		replay.position < len(replay.Frames) && replay.Frames[replay.position].Direction == Received {
+		frame := replay.Frames[replay.position]
+		replay.position++
+		replay.pending = frame.Data
+		if frame.Error != "" && frame.Error != ErrReadNothing.Error() {
+			replay.err = errors.New(frame.Error)
+		}
+	}
+	if len(replay.pending) > 0 {
+		n := copy(p, replay.pending)
+		replay.pending = replay.pending[n:]
+		return n, nil
+	}
+	err := replay.err
+	replay.err = nil
+	return 0, err
+}
+
+func (replay *Replay) SetMode(mode *serial.Mode) error { return nil }
+func (replay *Replay) ResetInputBuffer() error         { return nil }
+func (replay *Replay) ResetOutputBuffer() error        { return nil }
+func (replay *Replay) SetDTR(dtr bool) error           { return nil }
+func (replay *Replay) SetRTS(rts bool) error           { return nil }
+func (replay *Replay) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+func (replay *Replay) SetReadTimeout(t time.Duration) error { return nil }
+func (replay *Replay) Close() error                         { return nil }
+func (replay *Replay) Break(d time.Duration) error          { return nil }
@@ -3,15 +3,25 @@
 package phocus_serial
 
 import (
-	"errors" // creating custom err messages
-	"fmt"    // formatting
-	"log"    // logging
-	"time"   // timeouts
+	"errors"  // creating custom err messages
+	"fmt"     // formatting
+	"log"     // logging
+	"strings" // finding the end of a frame
+	"time"    // timeouts
 
 	crc "github.com/wolffshots/phocus/v2/crc" // checksum generation
 	"go.bug.st/serial"                        // rs232 serial
 )
 
+// ErrNilPortOnWrite is returned when writing to a port that isn't open
+var ErrNilPortOnWrite = errors.New("port is nil on write")
+
+// ErrNilPortOnRead is returned when reading from a port that isn't open
+var ErrNilPortOnRead = errors.New("port is nil on read")
+
+// ErrReadNothing is returned when a read times out without a response
+var ErrReadNothing = errors.New("read returned nothing")
+
 type Writer func(port serial.Port, input string) (int, error)
 type Reader func(port serial.Port, timeout time.Duration) (string, error)
 
@@ -21,11 +31,13 @@ type Reader func(port serial.Port, timeout time.Duration) (string, error)
 type Port struct {
 	Port  serial.Port
 	Path  string
+	Baud  int
 	Write Writer
 	Read  Reader
 }
 
-// Setup opens a connection to the inverter.
+// Setup opens a connection to the inverter, either on a local device,
+// a USB HID device or through a network serial bridge (see Open).
 //
 // Returns the port or an error if the port fails to open.
 func Setup(portPath string, baud int, retries int) (Port, error) {
@@ -35,7 +47,7 @@ func Setup(portPath string, baud int, retries int) (Port, error) {
 		mode := &serial.Mode{
 			BaudRate: baud,
 		}
-		port, err = serial.Open(portPath, mode)
+		port, err = Open(portPath, mode)
 		if err != nil {
 			log.Printf("Failed to set up serial %d times with err: %v", i+1, err)
 			time.Sleep(50 * time.Millisecond)
@@ -44,9 +56,19 @@ func Setup(portPath string, baud int, retries int) (Port, error) {
 			break
 		}
 	}
+	if IsHID(portPath) {
+		return Port{
+			Port:  port,
+			Path:  portPath,
+			Baud:  baud,
+			Write: WriteHID,
+			Read:  ReadHID,
+		}, err
+	}
 	return Port{
 		Port:  port,
 		Path:  portPath,
+		Baud:  baud,
 		Write: Write,
 		Read:  Read,
 	}, err
@@ -58,7 +80,7 @@ func Setup(portPath string, baud int, retries int) (Port, error) {
 var Write = func(port serial.Port, input string) (int, error) {
 	message := crc.Encode(input)
 	if port == nil {
-		return 0, errors.New("port is nil on write")
+		return 0, ErrNilPortOnWrite
 	}
 	n, err := port.Write([]byte(message))
 	if err != nil {
@@ -67,7 +89,7 @@ var Write = func(port serial.Port, input string) (int, error) {
 	return n, err
 }
 
-// Read from the open serial port until reaching a carriage return, nil or nothing.
+// Read from the open serial port until reaching a carriage return after a matching CRC, nil or nothing.
 // Takes a duration as an input and times out the read after that long.
 //
 // Returns the read string and the error
@@ -75,7 +97,7 @@ var Read = func(port serial.Port, timeout time.Duration) (string, error) {
 	log.Printf("Starting read\n")
 	buff := make([]byte, 140)
 	if port == nil {
-		return "", errors.New("port is nil on read")
+		return "", ErrNilPortOnRead
 	}
 	port.SetReadTimeout(timeout)
 	var err error
@@ -87,14 +109,19 @@ var Read = func(port serial.Port, timeout time.Duration) (string, error) {
 			err = readErr
 			break
 		} else if n == 0 {
+			if strings.HasSuffix(response, "\r") {
+				// nothing came after the carriage return so it was the end of a frame with a bad CRC
+				break
+			}
 			log.Println("\nEOF")
-			err = errors.New("read returned nothing")
-			break
-		} else if string(buff[:n]) == "\r" {
-			response = fmt.Sprintf("%v%v", response, string(buff[:n]))
+			err = ErrReadNothing
 			break
 		}
 		response = fmt.Sprintf("%v%v", response, string(buff[:n]))
+		// a byte of the CRC can be a carriage return too so it's only the end if the CRC matches
+		if buff[n-1] == '\r' && crc.Verify(response) {
+			break
+		}
 	}
 	return response, err
 }
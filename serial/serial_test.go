@@ -135,3 +135,32 @@ func TestSerial(t *testing.T) {
 		assert.Equal(t, errors.New("port is nil on read"), err)
 	})
 }
+
+func TestReadFrames(t *testing.T) {
+	received := func(chunks ...string) serial.Port {
+		replay := &Replay{}
+		for _, chunk := range chunks {
+			replay.Frames = append(replay.Frames, Frame{Direction: Received, Data: []byte(chunk)})
+		}
+		return replay
+	}
+
+	// the frame can arrive in more than one chunk
+	read, err := Read(received("(00", "138\x0d\x45\r"), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "(00138\x0d\x45\r", read)
+
+	// including one that ends with a byte of the CRC which is a carriage return
+	read, err = Read(received("(00138\x0d", "\x45\r", "(ACK9 \r"), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "(00138\x0d\x45\r", read)
+
+	// a frame with a bad CRC still ends at the carriage return when nothing comes after it
+	read, err = Read(received("(00138\xff\xff\r"), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "(00138\xff\xff\r", read)
+
+	read, err = Read(received("(00138"), time.Millisecond)
+	assert.Equal(t, "(00138", read)
+	assert.Equal(t, ErrReadNothing, err)
+}
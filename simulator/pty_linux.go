@@ -0,0 +1,73 @@
+//go:build linux
+
+package phocus_simulator
+
+import (
+	"fmt"     // formatting the slave path
+	"os"      // opening the pty
+	"syscall" // pty ioctls
+	"unsafe"  // ioctl arguments
+)
+
+// ioctl runs an ioctl request on a file descriptor
+func ioctl(fd uintptr, request uintptr, argument unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(argument))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Pty is a pseudo-terminal where the simulator holds the master side and
+// phocus opens the slave side as if it were a serial port
+type Pty struct {
+	Master *os.File
+	Slave  *os.File // held open so that reads on the master don't fail while phocus reconnects
+	Path   string
+}
+
+// OpenPty opens a new raw pseudo-terminal
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, err
+	}
+	var ptyNumber uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&ptyNumber)); err != nil {
+		master.Close()
+		return nil, err
+	}
+	// raw mode so that the frames aren't echoed or translated
+	var termios syscall.Termios
+	if err := ioctl(master.Fd(), syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		return nil, err
+	}
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	if err := ioctl(master.Fd(), syscall.TCSETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		return nil, err
+	}
+	path := fmt.Sprintf("/dev/pts/%d", ptyNumber)
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &Pty{Master: master, Slave: slave, Path: path}, nil
+}
+
+// Close closes both sides of the pty
+func (pty *Pty) Close() error {
+	pty.Slave.Close()
+	return pty.Master.Close()
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package phocus_simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	phocus_messages "github.com/wolffshots/phocus/v2/messages"
+	phocus_serial "github.com/wolffshots/phocus/v2/serial"
+)
+
+func TestSimulatorOverPty(t *testing.T) {
+	pty, err := OpenPty()
+	assert.NoError(t, err)
+	defer pty.Close()
+	go New(DefaultScenario).Serve(pty.Master)
+
+	port, err := phocus_serial.Setup(pty.Path, 2400, 1)
+	assert.NoError(t, err)
+	defer port.Port.Close()
+
+	// discovery finds both simulated units
+	responses, err := phocus_messages.Discover(port, 2*time.Second, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, phocus_messages.InverterNumbers(responses))
+	assert.Equal(t, "92932004102443", responses[0].SerialNumber)
+	assert.Equal(t, "92932004102453", responses[1].SerialNumber)
+
+	// polling publishes which fails without a client but still parses
+	interpreted, err := phocus_messages.Interpret(nil, port, phocus_messages.Message{Command: "QPGS2"}, 2*time.Second)
+	assert.EqualError(t, err, "client not defined in send")
+	response := interpreted.(*phocus_messages.QPGSnResponse)
+	assert.Equal(t, "92932004102453", response.SerialNumber)
+	assert.Equal(t, phocus_messages.OperationModes["B"], response.OperationMode)
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package phocus_simulator
+
+import (
+	"errors" // unsupported platforms
+	"os"     // matching the linux Pty
+)
+
+// Pty is a pseudo-terminal where the simulator holds the master side and
+// phocus opens the slave side as if it were a serial port
+type Pty struct {
+	Master *os.File
+	Slave  *os.File
+	Path   string
+}
+
+// OpenPty is only supported on linux
+func OpenPty() (*Pty, error) {
+	return nil, errors.New("the simulator pty is only supported on linux")
+}
+
+// Close closes both sides of the pty
+func (pty *Pty) Close() error {
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package phocus_simulator pretends to be a set of paralleled inverters
+// so that phocus can be run end to end without any hardware
+package phocus_simulator
+
+import (
+	"encoding/json" // reading scenarios
+	"fmt"           // formatting responses
+	"io"            // serving on any stream
+	"log"           // logging
+	"math"          // clamping
+	"math/rand"     // slow replies and dropped bytes
+	"os"            // reading scenarios
+	"strings"       // command matching
+	"sync"          // guarding state
+	"time"          // load profile and drift
+
+	phocus_crc "github.com/wolffshots/phocus/v2/crc" // framing responses
+)
+
+// LoadStep is a step in the load profile which lasts for Seconds
+type LoadStep struct {
+	Seconds int
+	Watts   int
+}
+
+// Drift describes how the state of charge changes over time
+type Drift struct {
+	Start     float64 // percentage at the start of the simulation
+	PerMinute float64 // percentage points added per minute, negative to discharge
+	Min       float64 // lower bound on the state of charge
+	Max       float64 // upper bound on the state of charge
+}
+
+// Fault puts a unit into fault mode with Code for a period of the simulation
+type Fault struct {
+	Unit         int    // index into Units
+	Code         string // fault code as reported by QPGSn, ie "07"
+	AfterSeconds int    // when the fault starts
+	ForSeconds   int    // how long it lasts, 0 means forever
+}
+
+// Chance of something happening to a reply
+type Chance struct {
+	Chance            float64 // probability between 0 and 1
+	DelayMilliseconds int     // only used for slow replies
+}
+
+// Scenario is the scriptable state of the simulator
+type Scenario struct {
+	Seed          int64      // seed for the random slow replies and dropped bytes
+	Units         []string   // serial numbers of the paralleled units
+	Load          []LoadStep // repeating load profile shared between the units
+	StateOfCharge Drift      // battery state of charge over time
+	Faults        []Fault    // faults to inject
+	SlowReplies   Chance     // replies which are delayed
+	DroppedBytes  Chance     // replies which have a byte dropped
+}
+
+// DefaultScenario is two paralleled units with a steady load and a slowly discharging battery
+var DefaultScenario = Scenario{
+	Seed:          1,
+	Units:         []string{"92932004102443", "92932004102453"},
+	Load:          []LoadStep{{Seconds: 60, Watts: 400}, {Seconds: 60, Watts: 1200}},
+	StateOfCharge: Drift{Start: 69, PerMinute: -0.1, Min: 10, Max: 100},
+}
+
+// LoadScenario reads a Scenario from a JSON file
+func LoadScenario(fileName string) (Scenario, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer file.Close()
+	scenario := Scenario{}
+	err = json.NewDecoder(file).Decode(&scenario)
+	return scenario, err
+}
+
+// setters are the command prefixes that are acknowledged without being validated
+var setters = []string{"POP", "PCP", "MCHGC", "MNCHGC", "MUCHGC", "PBCV", "PBDV", "PBT", "PBFT", "PCVV", "PE", "PD"}
+
+// Simulator answers inverter queries according to a Scenario
+type Simulator struct {
+	Scenario Scenario
+	Start    time.Time
+	Now      func() time.Time // defaults to time.Now
+	Sleep    func(d time.Duration)
+
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// New creates a Simulator for a Scenario which starts now
+func New(scenario Scenario) *Simulator {
+	return &Simulator{
+		Scenario: scenario,
+		Start:    time.Now(),
+		Now:      time.Now,
+		Sleep:    time.Sleep,
+		random:   rand.New(rand.NewSource(scenario.Seed)),
+	}
+}
+
+// elapsed is how long the simulation has been running
+func (simulator *Simulator) elapsed() time.Duration {
+	return simulator.Now().Sub(simulator.Start)
+}
+
+// Load returns the current load in watts of each unit from the load profile
+func (simulator *Simulator) Load() int {
+	total := 0
+	for _, step := range simulator.Scenario.Load {
+		total += step.Seconds
+	}
+	if total <= 0 {
+		return 0
+	}
+	position := int(simulator.elapsed().Seconds()) % total
+	for _, step := range simulator.Scenario.Load {
+		if position < step.Seconds {
+			return step.Watts
+		}
+		position -= step.Seconds
+	}
+	return 0
+}
+
+// StateOfCharge returns the current state of charge in percent after drifting
+func (simulator *Simulator) StateOfCharge() float64 {
+	drift := simulator.Scenario.StateOfCharge
+	stateOfCharge := drift.Start + drift.PerMinute*simulator.elapsed().Minutes()
+	if drift.Max > 0 {
+		stateOfCharge = math.Min(stateOfCharge, drift.Max)
+	}
+	return math.Max(stateOfCharge, drift.Min)
+}
+
+// FaultCode returns the active fault code of a unit or an empty string
+func (simulator *Simulator) FaultCode(unit int) string {
+	elapsed := simulator.elapsed()
+	for _, fault := range simulator.Scenario.Faults {
+		start := time.Duration(fault.AfterSeconds) * time.Second
+		end := start + time.Duration(fault.ForSeconds)*time.Second
+		if fault.Unit == unit && elapsed >= start && (fault.ForSeconds == 0 || elapsed < end) {
+			return fault.Code
+		}
+	}
+	return ""
+}
+
+// qpgsn builds the body of a QPGSn response for a parallel index
+//
+// QPGS0 and QPGS1 both answer for the first unit and indices
+// past the last unit answer as an empty slot
+func (simulator *Simulator) qpgsn(inverterNum int) string {
+	units := simulator.Scenario.Units
+	unit := inverterNum - 1
+	if inverterNum == 0 {
+		unit = 0
+	}
+	if unit < 0 || unit >= len(units) {
+		return "(0 00000000000000 S 00 000.0 00.00 000.0 00.00 0000 0000 000 00.0 000 000 000.0 000 00000 00000 000 00000000 0 0 000 000 00 00.0 000"
+	}
+	otherUnits, outputMode := "0", "0"
+	if len(units) > 1 {
+		otherUnits, outputMode = "1", "1"
+	}
+	mode, faultCode := "B", "00"
+	if code := simulator.FaultCode(unit); code != "" {
+		mode, faultCode = "F", code
+	}
+	watts := simulator.Load()
+	stateOfCharge := simulator.StateOfCharge()
+	batteryVoltage := 46 + stateOfCharge*0.075
+	dischargeCurrent := int(float64(watts) / batteryVoltage)
+	totalWatts := watts * len(units)
+	return fmt.Sprintf(
+		"(%s %s %s %s 237.0 50.01 230.0 50.00 %04d %04d %03d %04.1f 000 %03d 000.0 000 %05d %05d %03d 00000010 %s 1 060 080 10 00.0 %03d",
+		otherUnits, units[unit], mode, faultCode,
+		watts*10/8, watts, watts*100/5000,
+		batteryVoltage, int(stateOfCharge),
+		totalWatts*10/8, totalWatts, watts*100/5000,
+		outputMode, dischargeCurrent,
+	)
+}
+
+// qpigs builds the body of a QPIGS response for the first unit
+func (simulator *Simulator) qpigs() string {
+	watts := simulator.Load()
+	stateOfCharge := simulator.StateOfCharge()
+	batteryVoltage := 46 + stateOfCharge*0.075
+	dischargeCurrent := int(float64(watts) / batteryVoltage)
+	status := "00010000"
+	if code := simulator.FaultCode(0); code != "" {
+		status = "00000000"
+	}
+	return fmt.Sprintf(
+		"(237.0 50.0 230.0 50.0 %04d %04d %03d 390 %05.2f 000 %03d 0035 0000 000.0 00.00 %05d %s 00 00 00000 010",
+		watts*10/8, watts, watts*100/5000, batteryVoltage, int(stateOfCharge), dischargeCurrent, status,
+	)
+}
+
+// qpiws builds the body of a QPIWS response where a fault on any unit shows up as an inverter fault
+func (simulator *Simulator) qpiws() string {
+	bits := []byte("00000000000000000000000000000000")
+	for unit := range simulator.Scenario.Units {
+		if simulator.FaultCode(unit) != "" {
+			bits[1] = '1'
+		}
+	}
+	if simulator.StateOfCharge() <= simulator.Scenario.StateOfCharge.Min {
+		bits[12] = '1' // battery low alarm
+	}
+	return "(" + string(bits)
+}
+
+// isDated checks that the request is the command followed by a date of length digits
+func isDated(request string, command string, length int) bool {
+	date, found := strings.CutPrefix(request, command)
+	return found && len(date) == length && strings.Trim(date, "0123456789") == ""
+}
+
+// Respond returns the CRC framed response to a request without the request's CRC
+func (simulator *Simulator) Respond(request string) string {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	var body string
+	switch {
+	case request == "QID" && len(simulator.Scenario.Units) > 0:
+		body = "(" + simulator.Scenario.Units[0]
+	case request == "QVFW":
+		body = "(VERFW:00072.70"
+	case request == "QPIGS" && len(simulator.Scenario.Units) > 0:
+		body = simulator.qpigs()
+	case request == "QPIWS":
+		body = simulator.qpiws()
+	case request == "QET":
+		body = "(00012345"
+	case isDated(request, "QEY", 4):
+		body = "(00002345"
+	case isDated(request, "QEM", 6):
+		body = "(00000456"
+	case isDated(request, "QED", 8):
+		body = "(00012345" // in Wh
+	case request == "QPIRI":
+		body = "(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 1 54.0 0 1"
+	case strings.HasPrefix(request, "QPGS"):
+		var inverterNum int
+		if _, err := fmt.Sscanf(request, "QPGS%d", &inverterNum); err != nil {
+			body = "(NAK"
+		} else {
+			body = simulator.qpgsn(inverterNum)
+		}
+	default:
+		body = "(NAK"
+		for _, setter := range setters {
+			if strings.HasPrefix(request, setter) && len(request) > len(setter) {
+				body = "(ACK"
+				break
+			}
+		}
+	}
+	return phocus_crc.Encode(body)
+}
+
+// corrupt applies the slow replies and dropped bytes from the scenario to a reply
+func (simulator *Simulator) corrupt(reply string) string {
+	simulator.mutex.Lock()
+	slow := simulator.random.Float64() < simulator.Scenario.SlowReplies.Chance
+	drop := simulator.random.Float64() < simulator.Scenario.DroppedBytes.Chance
+	index := simulator.random.Intn(len(reply))
+	simulator.mutex.Unlock()
+	if slow {
+		simulator.Sleep(time.Duration(simulator.Scenario.SlowReplies.DelayMilliseconds) * time.Millisecond)
+	}
+	if drop {
+		reply = reply[:index] + reply[index+1:]
+	}
+	return reply
+}
+
+// isFrame checks that a frame ends with a valid CRC and carriage return
+func isFrame(frame string) bool {
+	return len(frame) > 3 && phocus_crc.Encode(frame[:len(frame)-3]) == frame
+}
+
+// Serve reads CRC framed requests from a stream and writes responses back until the stream fails
+//
+// A CRC byte can also be a carriage return so a frame is only handled once its CRC is valid
+func (simulator *Simulator) Serve(stream io.ReadWriter) error {
+	buffer := make([]byte, 256)
+	var pending []byte
+	for {
+		n, err := stream.Read(buffer)
+		if err != nil {
+			return err
+		}
+		for _, character := range buffer[:n] {
+			pending = append(pending, character)
+			if character != '\r' {
+				continue
+			}
+			for start := 0; start < len(pending); start++ {
+				if (start == 0 || pending[start-1] == '\r') && isFrame(string(pending[start:])) {
+					request := string(pending[start : len(pending)-3])
+					reply := simulator.corrupt(simulator.Respond(request))
+					log.Printf("Simulator answering %q with %q\n", request, reply)
+					if _, err := stream.Write([]byte(reply)); err != nil {
+						return err
+					}
+					pending = nil
+					break
+				}
+			}
+			if len(pending) > len(buffer) {
+				pending = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package phocus_simulator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	phocus_crc "github.com/wolffshots/phocus/v2/crc"
+)
+
+// newAt creates a simulator for a scenario with a clock that can be moved
+func newAt(scenario Scenario, elapsed *time.Duration) *Simulator {
+	simulator := New(scenario)
+	simulator.Start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	simulator.Now = func() time.Time { return simulator.Start.Add(*elapsed) }
+	simulator.Sleep = func(d time.Duration) {}
+	return simulator
+}
+
+func TestLoadScenario(t *testing.T) {
+	scenario, err := LoadScenario("../simulator.json.example")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"92932004102443", "92932004102453", "92932004102463"}, scenario.Units)
+	assert.Equal(t, 3, len(scenario.Load))
+	assert.Equal(t, Fault{Unit: 2, Code: "07", AfterSeconds: 120, ForSeconds: 60}, scenario.Faults[0])
+
+	_, err = LoadScenario("./not_a_scenario.json")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestLoadAndStateOfCharge(t *testing.T) {
+	elapsed := time.Duration(0)
+	simulator := newAt(Scenario{
+		Load:          []LoadStep{{Seconds: 10, Watts: 100}, {Seconds: 20, Watts: 2000}},
+		StateOfCharge: Drift{Start: 50, PerMinute: 10, Min: 20, Max: 80},
+	}, &elapsed)
+
+	assert.Equal(t, 100, simulator.Load())
+	assert.Equal(t, 50.0, simulator.StateOfCharge())
+
+	elapsed = 15 * time.Second
+	assert.Equal(t, 2000, simulator.Load())
+	assert.Equal(t, 52.5, simulator.StateOfCharge())
+
+	elapsed = 35 * time.Second // wraps around the profile
+	assert.Equal(t, 100, simulator.Load())
+
+	elapsed = 10 * time.Minute
+	assert.Equal(t, 80.0, simulator.StateOfCharge())
+
+	simulator.Scenario.StateOfCharge.PerMinute = -10
+	assert.Equal(t, 20.0, simulator.StateOfCharge())
+
+	simulator.Scenario.Load = nil
+	assert.Equal(t, 0, simulator.Load())
+}
+
+func TestRespond(t *testing.T) {
+	elapsed := time.Duration(0)
+	simulator := newAt(Scenario{
+		Units:         []string{"92932004102443", "92932004102453"},
+		Load:          []LoadStep{{Seconds: 60, Watts: 400}},
+		StateOfCharge: Drift{Start: 69, Max: 100},
+		Faults:        []Fault{{Unit: 1, Code: "07", AfterSeconds: 10, ForSeconds: 10}},
+	}, &elapsed)
+
+	assert.Equal(t, phocus_crc.Encode("(92932004102443"), simulator.Respond("QID"))
+	assert.Equal(t, phocus_crc.Encode("(VERFW:00072.70"), simulator.Respond("QVFW"))
+	assert.Equal(t, phocus_crc.Encode("(00012345"), simulator.Respond("QET"))
+	assert.Equal(t, phocus_crc.Encode("(00002345"), simulator.Respond("QEY2024"))
+	assert.Equal(t, phocus_crc.Encode("(00000456"), simulator.Respond("QEM202401"))
+	assert.Equal(t, phocus_crc.Encode("(00012345"), simulator.Respond("QED20240131"))
+	assert.Equal(t, phocus_crc.Encode("(NAK"), simulator.Respond("QED2024"))
+
+	want := phocus_crc.Encode("(1 92932004102443 B 00 237.0 50.01 230.0 50.00 0500 0400 008 51.2 000 069 000.0 000 01000 00800 008 00000010 1 1 060 080 10 00.0 007")
+	assert.Equal(t, want, simulator.Respond("QPGS0"))
+	assert.Equal(t, want, simulator.Respond("QPGS1"))
+	assert.Equal(t, phocus_crc.Encode("(1 92932004102453 B 00 237.0 50.01 230.0 50.00 0500 0400 008 51.2 000 069 000.0 000 01000 00800 008 00000010 1 1 060 080 10 00.0 007"), simulator.Respond("QPGS2"))
+	assert.Equal(t, phocus_crc.Encode("(0 00000000000000 S 00 000.0 00.00 000.0 00.00 0000 0000 000 00.0 000 000 000.0 000 00000 00000 000 00000000 0 0 000 000 00 00.0 000"), simulator.Respond("QPGS3"))
+
+	// fault injected on the second unit
+	elapsed = 15 * time.Second
+	assert.Equal(t, phocus_crc.Encode("(1 92932004102453 F 07 237.0 50.01 230.0 50.00 0500 0400 008 51.2 000 069 000.0 000 01000 00800 008 00000010 1 1 060 080 10 00.0 007"), simulator.Respond("QPGS2"))
+	assert.Equal(t, phocus_crc.Encode("(01000000000000000000000000000000"), simulator.Respond("QPIWS"))
+	elapsed = 20 * time.Second
+	assert.Equal(t, phocus_crc.Encode("(00000000000000000000000000000000"), simulator.Respond("QPIWS"))
+	assert.Equal(t, phocus_crc.Encode("(1 92932004102453 B 00 237.0 50.01 230.0 50.00 0500 0400 008 51.2 000 069 000.0 000 01000 00800 008 00000010 1 1 060 080 10 00.0 007"), simulator.Respond("QPGS2"))
+
+	assert.Equal(t, phocus_crc.Encode("(237.0 50.0 230.0 50.0 0500 0400 008 390 51.17 000 069 0035 0000 000.0 00.00 00007 00010000 00 00 00000 010"), simulator.Respond("QPIGS"))
+
+	assert.Equal(t, phocus_crc.Encode("(230.0 21.7 230.0 50.0 21.7 5000 5000 48.0 46.0 42.0 56.4 54.0 2 020 060 0 2 3 9 01 0 1 54.0 0 1"), simulator.Respond("QPIRI"))
+
+	// generic commands
+	assert.Equal(t, phocus_crc.Encode("(ACK"), simulator.Respond("POP01"))
+	assert.Equal(t, phocus_crc.Encode("(NAK"), simulator.Respond("POP"))
+	assert.Equal(t, phocus_crc.Encode("(NAK"), simulator.Respond("QPGSx"))
+	assert.Equal(t, phocus_crc.Encode("(NAK"), simulator.Respond("SOMETHING"))
+}
+
+func TestCorrupt(t *testing.T) {
+	elapsed := time.Duration(0)
+	simulator := newAt(Scenario{Units: []string{"92932004102443"}}, &elapsed)
+	var slept time.Duration
+	simulator.Sleep = func(d time.Duration) { slept += d }
+
+	reply := simulator.Respond("QID")
+	assert.Equal(t, reply, simulator.corrupt(reply))
+	assert.Equal(t, time.Duration(0), slept)
+
+	simulator.Scenario.SlowReplies = Chance{Chance: 1, DelayMilliseconds: 1500}
+	simulator.Scenario.DroppedBytes = Chance{Chance: 1}
+	assert.Equal(t, len(reply)-1, len(simulator.corrupt(reply)))
+	assert.Equal(t, 1500*time.Millisecond, slept)
+}
+
+// stream is an in memory io.ReadWriter for Serve
+type stream struct {
+	input  io.Reader
+	output bytes.Buffer
+}
+
+func (s *stream) Read(p []byte) (int, error)  { return s.input.Read(p) }
+func (s *stream) Write(p []byte) (int, error) { return s.output.Write(p) }
+
+func TestServe(t *testing.T) {
+	elapsed := time.Duration(0)
+	simulator := newAt(Scenario{Units: []string{"92932004102443"}}, &elapsed)
+
+	// garbage then a request, then a request with a carriage return in its CRC
+	requests := "junk\r" + phocus_crc.Encode("QID") + phocus_crc.Encode("POP01")
+	s := &stream{input: bytes.NewBufferString(requests)}
+	err := simulator.Serve(s)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, phocus_crc.Encode("(92932004102443")+phocus_crc.Encode("(ACK"), s.output.String())
+}
@@ -1,25 +1,26 @@
 // Define the type for the JSON data
 interface InverterData {
+    Version?: number; // 2 when the measurements are numbers, missing for the strings of version 1
     InverterNumber: number;
     OtherUnits: boolean;
     SerialNumber: string;
     OperationMode: string;
     FaultCode: string;
-    ACInputVoltage: string;
-    ACInputFrequency: string;
-    ACOutputVoltage: string;
-    ACOutputFrequency: string;
-    ACOutputApparentPower: string;
-    ACOutputActivePower: string;
-    PercentageOfNominalOutputPower: string;
-    BatteryVoltage: string;
-    BatteryChargingCurrent: string;
-    BatteryStateOfCharge: string;
-    PVInputVoltage: string;
-    TotalChargingCurrent: string;
-    TotalACOutputApparentPower: string;
-    TotalACOutputActivePower: string;
-    TotalPercentageOfNominalOutputPower: string;
+    ACInputVoltage: number | string;
+    ACInputFrequency: number | string;
+    ACOutputVoltage: number | string;
+    ACOutputFrequency: number | string;
+    ACOutputApparentPower: number | string;
+    ACOutputActivePower: number | string;
+    PercentageOfNominalOutputPower: number | string;
+    BatteryVoltage: number | string;
+    BatteryChargingCurrent: number | string;
+    BatteryStateOfCharge: number | string;
+    PVInputVoltage: number | string;
+    TotalChargingCurrent: number | string;
+    TotalACOutputApparentPower: number | string;
+    TotalACOutputActivePower: number | string;
+    TotalPercentageOfNominalOutputPower: number | string;
     InverterStatus: {
         MPPT: string;
         ACCharging: string;
@@ -31,11 +32,11 @@ interface InverterData {
     };
     ACOutputMode: string;
     BatteryChargerSourcePriority: string;
-    MaxChargingCurrentSet: string;
-    MaxChargingCurrentPossible: string;
-    MaxACChargingCurrentSet: string;
-    PVInputCurrent: string;
-    BatteryDischargeCurrent: string;
+    MaxChargingCurrentSet: number | string;
+    MaxChargingCurrentPossible: number | string;
+    MaxACChargingCurrentSet: number | string;
+    PVInputCurrent: number | string;
+    BatteryDischargeCurrent: number | string;
     Checksum: string;
     [key: string]: any;
 };